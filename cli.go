@@ -1,94 +1,239 @@
 package main
 
 import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
+	"io/ioutil"
 	"log"
 	"os"
+	"os/signal"
+	"sort"
 	"strconv"
+	"strings"
+	"time"
+
+	"blockchain/config"
+	"blockchain/core"
+	"blockchain/core/chain"
+	"blockchain/core/mempool"
+	"blockchain/core/miner"
+	"blockchain/node"
+	"blockchain/pow"
+	"blockchain/wallet"
 )
 
-type CLI struct{}
+type CLI struct {
+	// 由全局参数、环境变量及配置文件解析得到的配置
+	config *config.Config
+	// 配置中所选网络的参数
+	params *chain.ChainParams
+}
 
-// 创建一个区块链
-func (cli *CLI) createBlockchain(address string) {
-	if !ValidateAddress(address) {
-		log.Panic("ERROR: Address is not valid")
+// 创建一个只包含当前网络创世块的区块链
+func (cli *CLI) createBlockchain() error {
+	if err := cli.config.EnsureDirs(); err != nil {
+		return err
+	}
+	bc, err := chain.CreateBlockChain(cli.config.DBPath(), cli.params)
+	if err != nil {
+		return err
 	}
-	bc := CreateBlockChain(address)
-	defer bc.db.Close()
+	defer bc.Close()
 
-	UTXOset := UTXOset{bc}
-	UTXOset.Reindex()
+	fmt.Printf("Done! Created the %s blockchain with genesis block %s\n", cli.params.Name, cli.params.GenesisHash)
+	return nil
+}
 
-	fmt.Println("Done!")
+func (cli *CLI) reindexUTXO() error {
+	return cli.reindex(false)
 }
 
-func (cli *CLI) reindexUTXO() {
-	bc := NewBlockChain()
-	UTXOset := UTXOset{bc}
-	UTXOset.Reindex()
+// 重建UTXO集 txIndex为true时同时启用并重建交易索引
+func (cli *CLI) reindex(txIndex bool) error {
+	bc, err := chain.NewBlockChain(cli.config.DBPath(), cli.params)
+	if err != nil {
+		return err
+	}
+	defer bc.Close()
+
+	UTXOset := chain.UTXOset{Blockchain: bc}
+	if err := UTXOset.Reindex(); err != nil {
+		return err
+	}
 
-	count := UTXOset.CountTransactions()
+	count, err := UTXOset.CountTransactions()
+	if err != nil {
+		return err
+	}
 	fmt.Printf("Done! There are %d transactions in the UTXO set.\n", count)
+
+	if txIndex {
+		if err := bc.ReindexTransactions(); err != nil {
+			return err
+		}
+		fmt.Println("Transaction index rebuilt.")
+	}
+	return nil
+}
+
+// 从主链尾部断开n个区块
+func (cli *CLI) disconnectBlocks(n int) error {
+	bc, err := chain.NewBlockChain(cli.config.DBPath(), cli.params)
+	if err != nil {
+		return err
+	}
+	defer bc.Close()
+
+	blocks, err := bc.DisconnectBlocks(n)
+	if err != nil {
+		return err
+	}
+
+	for _, block := range blocks {
+		fmt.Printf("Disconnected block %x\n", block.Hash)
+	}
+	return nil
 }
 
 // 查找当前账户的余额
-func (cli *CLI) getBalance(address string) {
-	bc := NewBlockChain()
-	UTXOSet := UTXOset{bc}
-	defer bc.db.Close()
+func (cli *CLI) getBalance(address string) error {
+	pubKeyHash, err := wallet.PubKeyHashFromAddress(address, cli.params.AddressVersion)
+	if err != nil {
+		return err
+	}
+	bc, err := chain.NewBlockChain(cli.config.DBPath(), cli.params)
+	if err != nil {
+		return err
+	}
+	UTXOSet := chain.UTXOset{Blockchain: bc}
+	defer bc.Close()
 
 	// 找到未使用的UTXO
 	balance := 0
-	pubKeyHash := Base58Decode([]byte(address))
-	pubKeyHash = pubKeyHash[1 : len(pubKeyHash)-4]
-	UTXOs := UTXOSet.FindUTXO(pubKeyHash)
+	UTXOs, err := UTXOSet.FindUTXO(pubKeyHash)
+	if err != nil {
+		return err
+	}
 
 	for _, out := range UTXOs {
 		balance += out.Value
 	}
 
+	// 尚未成熟的铸币交易输出计入余额 但暂时不能花费
+	outputs, err := UTXOSet.SpendableOutputs(pubKeyHash)
+	if err != nil {
+		return err
+	}
+	spendable := 0
+	for _, out := range outputs {
+		spendable += out.Output.Value
+	}
+
 	fmt.Printf("Balance of '%s': %d\n", address, balance)
+	if spendable < balance {
+		fmt.Printf("Immature: %d\n", balance-spendable)
+	}
+	return nil
+}
+
+// 打印与地址相关的所有主链交易
+func (cli *CLI) history(address string) error {
+	pubKeyHash, err := wallet.PubKeyHashFromAddress(address, cli.params.AddressVersion)
+	if err != nil {
+		return err
+	}
+	bc, err := chain.NewBlockChain(cli.config.DBPath(), cli.params)
+	if err != nil {
+		return err
+	}
+	defer bc.Close()
+
+	txs, err := bc.AddressHistory(pubKeyHash)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("History of '%s':\n", address)
+	balance := 0
+	for _, tx := range txs {
+		balance += tx.Received - tx.Sent
+		fmt.Printf("  height %d  tx %x  received %d  sent %d  balance %d\n", tx.Height, tx.TxID, tx.Received, tx.Sent, balance)
+	}
+	return nil
 }
 
 // 创建钱包
-func (cli *CLI) createWallet() {
-	wallets, _ := NewWallets()
-	address := wallets.CreateWallet()
-	wallets.SaveToFile()
+func (cli *CLI) createWallet() error {
+	if err := cli.config.EnsureDirs(); err != nil {
+		return err
+	}
+	wallets, err := wallet.NewWallets(cli.config.WalletPath(), cli.params.AddressVersion)
+	if err != nil {
+		return err
+	}
+	address, err := wallets.CreateWallet()
+	if err != nil {
+		return err
+	}
+	if err := wallets.SaveToFile(); err != nil {
+		return err
+	}
 
 	fmt.Printf("Your new address: %s\n", address)
+	return nil
 }
 
 // 打印钱包中包含的地址
-func (cli *CLI) listAddresses() {
-	wallets, err := NewWallets()
+func (cli *CLI) listAddresses() error {
+	wallets, err := wallet.NewWallets(cli.config.WalletPath(), cli.params.AddressVersion)
 	if err != nil {
-		log.Panic(err)
+		return err
 	}
 	addresses := wallets.GetAddresses()
 
 	for _, address := range addresses {
 		fmt.Println(address)
 	}
+	return nil
 }
 
 // 打印当前cli的帮助
 func (cli *CLI) printUsage() {
-	fmt.Println("Usage:")
+	fmt.Println("Usage: blockchain [global options] COMMAND [command options]")
+	fmt.Println("Global options (also settable via env vars and the JSON config file):")
+	fmt.Println("  -config FILE - Config file, defaults to DATADIR/config.json")
+	fmt.Println("  -datadir DIR - Data directory, defaults to ~/.blockchain")
+	fmt.Println("  -network NAME - Network: mainnet, testnet or regtest")
+	fmt.Println("  -db FILE - Blockchain database path, defaults to DATADIR[/NETWORK]/blockchain.db")
+	fmt.Println("  -wallet FILE - Wallet file path, defaults to DATADIR[/NETWORK]/wallet.dat")
+	fmt.Println("Commands:")
 	fmt.Println("  getbalance -address ADDRESS - Get balance of ADDRESS")
-	fmt.Println("  createblockchain -address ADDRESS - Create a blockchain and send genesis block reward to ADDRESS")
+	fmt.Println("  history -address ADDRESS - Print all transactions that paid to or spent from ADDRESS")
+	fmt.Println("  createblockchain - Create a blockchain holding the fixed genesis block of the selected network")
 	fmt.Println("  printchain - Print all the blocks of the blockchain")
-	fmt.Println("  send -from FROM -to TO -amount AMOUNT - Send AMOUNT of coins from FROM address to TO")
+	fmt.Println("  getblock -height N | -hash HASH - Print a block of the main chain by height, or any block by hash")
+	fmt.Println("  supply - Print the coins issued so far and the maximum supply")
+	fmt.Println("  send -from FROM[,FROM...] | -fromwallet -to TO -amount AMOUNT [-change ADDRESS] [-fee FEE | -feerate RATE] [-coinselect STRATEGY] [-nomine] - Send AMOUNT of coins to TO from the FROM addresses or the whole wallet, paying a fixed FEE or RATE per byte; change goes to ADDRESS (default: the first source); STRATEGY is largest, smallest, bnb (default) or random; with -nomine the transaction only enters the mempool")
+	fmt.Println("  sendmany -from FROM[,FROM...] | -fromwallet -to ADDR:AMOUNT,... | -file PAYMENTS.json [options of send] - Pay several addresses in one transaction; the file holds [{\"address\": ADDR, \"amount\": AMOUNT}, ...]")
+	fmt.Println("  mempool [-tx TXID [-remove]] - List the mempool, print transaction TXID, or remove it with its descendants")
+	fmt.Println("  mine -address ADDRESS [-blocks N] [-continuous] [-workers W] - Mine N blocks from the mempool, or keep mining until interrupted, with W parallel workers and send the rewards to ADDRESS")
+	fmt.Println("  startnode [-port PORT] [-miner ADDRESS] [-connect HOST:PORT,...] [-addnode HOST:PORT,...] - Run a network node on PORT (default: 3000 on mainnet, 13000 on testnet, 23000 on regtest) that syncs blocks and transactions with its peers; peers come from the address book in DATADIR[/NETWORK]/peers.json and the \"seeds\" of the config file, -connect restricts the node to the given peers, and -addnode (or \"addnode\" in the config file) keeps the given peers connected; with -miner it mines whenever the mempool has transactions")
+	fmt.Println("  listbanned - List the peers banned for misbehaving and when their bans expire")
+	fmt.Println("  unban -host IP - Lift the ban on IP; a running node picks it up on its next check")
 	fmt.Println("  listaddresses - Lists all addresses from the wallet file")
 	fmt.Println("  createwallet - Generates a new key-pair and saves it into the wallet file")
 	fmt.Println("  reindexutxo - Rebuilds the UTXO set")
+	fmt.Println("  reindex [-txindex] - Rebuilds the UTXO set, and with -txindex enables and rebuilds the transaction index")
+	fmt.Println("  disconnect -blocks N - Disconnects the last N blocks from the main chain and reverts the UTXO set")
 }
 
-// 简单的参数校验 仅验证是否小于2
-func (cli *CLI) validateArgs() {
-	if len(os.Args) < 2 {
+// 简单的参数校验 仅验证是否存在命令
+func (cli *CLI) validateArgs(args []string) {
+	if len(args) < 1 {
 		cli.printUsage()
 		// 发现在打印usage时存在获取锁不释放的情况
 		// 注意：此处一定不要使用defer语句 os.exit(1)会使得defer无效 导致数据库连接不释放
@@ -96,17 +241,29 @@ func (cli *CLI) validateArgs() {
 	}
 }
 
-func (cli *CLI) printChain() {
-	bc := NewBlockChain()
-	defer bc.db.Close()
+func (cli *CLI) printChain() error {
+	bc, err := chain.NewBlockChain(cli.config.DBPath(), cli.params)
+	if err != nil {
+		return err
+	}
+	defer bc.Close()
 
 	bci := bc.Iterator()
 	for {
-		block := bci.Next()
+		block, err := bci.Next()
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Height: %d\n", block.Height)
 		fmt.Printf("Prev. hash: %x\n", block.PrevBlockHash)
 		fmt.Printf("Hash: %x\n", block.Hash)
-		pow := NewProofOfWork(block)
-		fmt.Printf("PoW: %s\n", strconv.FormatBool(pow.Validate()))
+		bits, err := bc.RequiredBits(block.PrevBlockHash)
+		if err != nil {
+			return err
+		}
+		proof := pow.NewProofOfWork(block)
+		fmt.Printf("Bits: %08x\n", block.Bits)
+		fmt.Printf("PoW: %s\n", strconv.FormatBool(proof.Validate(bits)))
 		fmt.Println()
 
 		// 根据创世区块没有prevhash的性质 来终止循环
@@ -115,86 +272,593 @@ func (cli *CLI) printChain() {
 		}
 	}
 
+	return nil
+}
+
+// 按高度或hash打印一个区块及其包含的交易 hash不为空时优先使用hash
+func (cli *CLI) getBlock(height int64, hash string) error {
+	bc, err := chain.NewBlockChain(cli.config.DBPath(), cli.params)
+	if err != nil {
+		return err
+	}
+	defer bc.Close()
+
+	var block *core.Block
+	if hash != "" {
+		blockHash, err := hex.DecodeString(hash)
+		if err != nil {
+			return fmt.Errorf("invalid block hash %q: %w", hash, err)
+		}
+		block, err = bc.GetBlockByHash(blockHash)
+		if err != nil {
+			return err
+		}
+	} else {
+		block, err = bc.GetBlockByHeight(height)
+		if err != nil {
+			return err
+		}
+	}
+
+	fmt.Printf("Height: %d\n", block.Height)
+	fmt.Printf("Hash: %x\n", block.Hash)
+	fmt.Printf("Prev. hash: %x\n", block.PrevBlockHash)
+	fmt.Printf("Merkle root: %x\n", block.MerkleRoot)
+	fmt.Printf("Timestamp: %d\n", block.Timestamp)
+	fmt.Printf("Bits: %08x\n", block.Bits)
+	fmt.Printf("Nonce: %d\n", block.Nonce)
+	fmt.Printf("Transactions: %d\n", len(block.Transactions))
+	for _, tx := range block.Transactions {
+		fmt.Println(tx)
+	}
+
+	return nil
+}
+
+// 打印已发行的货币数量与供应量上限
+func (cli *CLI) supply() error {
+	bc, err := chain.NewBlockChain(cli.config.DBPath(), cli.params)
+	if err != nil {
+		return err
+	}
+	defer bc.Close()
+
+	height, err := bc.GetBestHeight()
+	if err != nil {
+		return err
+	}
+
+	params := bc.Params()
+	issued := params.IssuedSupply(height)
+	maxSupply := params.MaxSupply()
+	nextHalving := (height/params.SubsidyHalvingInterval + 1) * params.SubsidyHalvingInterval
+
+	fmt.Printf("Height: %d\n", height)
+	fmt.Printf("Issued: %d of %d (%.4f%%)\n", issued, maxSupply, float64(issued)*100/float64(maxSupply))
+	fmt.Printf("Block subsidy: %d\n", params.BlockSubsidy(height+1))
+	fmt.Printf("Next halving: height %d\n", nextHalving)
+
+	return nil
+}
+
+// send与sendmany构造交易的选项
+type sendOptions struct {
+	// 提供输入的地址 fromWallet为true时使用钱包中的所有地址
+	from       []string
+	fromWallet bool
+	// 找零地址 为空时使用第一个提供输入的地址
+	change string
+	// 手续费为固定的fee或按交易大小以每字节feeRate计算 两者最多指定一个
+	fee     int
+	feeRate int
+	// 交易先加入交易池 noMine为false时立即挖出一个包含交易池中所有交易的区块
+	noMine bool
+	// 选择输入的策略 为空时使用默认策略
+	coinSelect string
 }
 
-func (cli *CLI) send(from, to string, amount int) {
+// 按照opts向to转账amount
+func (cli *CLI) send(to string, amount int, opts sendOptions) error {
 	// 增加地址校验机制
-	if !ValidateAddress(from) {
-		log.Panic("ERROR: Sender address is not valid")
+	if !wallet.ValidateAddress(to, cli.params.AddressVersion) {
+		return fmt.Errorf("recipient %w", wallet.ErrInvalidAddress)
 	}
 
-	if !ValidateAddress(to) {
-		log.Panic("ERROR: Recipient address is not valid")
+	return cli.sendPayments([]chain.Payment{{Address: to, Amount: amount}}, opts)
+}
+
+// 按照opts在一笔交易中向多个地址付款
+func (cli *CLI) sendPayments(payments []chain.Payment, opts sendOptions) error {
+	for _, from := range opts.from {
+		if !wallet.ValidateAddress(from, cli.params.AddressVersion) {
+			return fmt.Errorf("sender %w: %s", wallet.ErrInvalidAddress, from)
+		}
 	}
 
-	bc := NewBlockChain()
-	UTXOset := UTXOset{bc}
-	defer bc.db.Close()
+	var selector chain.CoinSelector
+	if opts.coinSelect != "" {
+		var err error
+		selector, err = chain.NewCoinSelector(opts.coinSelect)
+		if err != nil {
+			return err
+		}
+	}
 
-	// 实现出块奖励
-	tx := NewUTXOTransction(from, to, amount, &UTXOset)
-	cbTx := NewCoinbaseTX(from, "")
-	txs := []*Transaction{cbTx, tx}
+	wallets, err := wallet.NewWallets(cli.config.WalletPath(), cli.params.AddressVersion)
+	if err != nil {
+		return err
+	}
 
-	newBlock := bc.MineBlock(txs)
+	from := opts.from
+	if opts.fromWallet {
+		from = wallets.GetAddresses()
+		sort.Strings(from)
+	}
+	if len(from) == 0 {
+		return fmt.Errorf("no source addresses in %s", cli.config.WalletPath())
+	}
+	change := opts.change
+	if change == "" {
+		change = from[0]
+	}
+
+	bc, err := chain.NewBlockChain(cli.config.DBPath(), cli.params)
+	if err != nil {
+		return err
+	}
+	defer bc.Close()
+
+	mp, err := mempool.New(bc)
+	if err != nil {
+		return err
+	}
+	// 可以花费交易池中尚未确认的找零
+	UTXOset := chain.UTXOset{Blockchain: bc, Pending: mp, Selector: selector}
+
+	var tx *core.Transaction
+	if opts.feeRate > 0 {
+		tx, err = chain.NewMultiSourceTransactionWithFeeRate(wallets, from, payments, change, opts.feeRate, &UTXOset)
+	} else {
+		tx, err = chain.NewMultiSourceTransaction(wallets, from, payments, change, opts.fee, &UTXOset)
+	}
+	if err != nil {
+		return err
+	}
+
+	entry, err := mp.Add(tx)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Fee: %d\n", entry.Fee)
 
-	UTXOset.Update(newBlock)
+	if opts.noMine {
+		fmt.Printf("Transaction %x added to the mempool\n", tx.ID)
+		return nil
+	}
+
+	// 实现出块奖励 找零地址同时作为矿工领取交易的手续费
+	m, err := miner.New(bc, mp, change)
+	if err != nil {
+		return err
+	}
+	if _, err := m.MineBlock(context.Background()); err != nil {
+		return err
+	}
 	fmt.Println("Success!")
+	return nil
+}
+
+// send与sendmany共用的命令行参数
+type sendFlags struct {
+	from       *string
+	fromWallet *bool
+	change     *string
+	fee        *int
+	feeRate    *int
+	noMine     *bool
+	coinSelect *string
+}
+
+func newSendFlags(cmd *flag.FlagSet) *sendFlags {
+	return &sendFlags{
+		from:       cmd.String("from", "", "Comma separated source wallet addresses"),
+		fromWallet: cmd.Bool("fromwallet", false, "Spend from all addresses in the wallet"),
+		change:     cmd.String("change", "", "Change address (default: the first source address)"),
+		fee:        cmd.Int("fee", 0, "Fixed transaction fee"),
+		feeRate:    cmd.Int("feerate", 0, "Transaction fee per byte of the transaction"),
+		noMine:     cmd.Bool("nomine", false, "Only add the transaction to the mempool"),
+		coinSelect: cmd.String("coinselect", "", "Coin selection strategy: largest, smallest, bnb or random"),
+	}
+}
+
+// 检查参数并转换为sendOptions 参数不合法时返回false
+func (f *sendFlags) options() (sendOptions, bool) {
+	opts := sendOptions{
+		fromWallet: *f.fromWallet,
+		change:     *f.change,
+		fee:        *f.fee,
+		feeRate:    *f.feeRate,
+		noMine:     *f.noMine,
+		coinSelect: *f.coinSelect,
+	}
+	if *f.from != "" {
+		opts.from = strings.Split(*f.from, ",")
+	}
+
+	valid := len(opts.from) > 0 != opts.fromWallet && opts.fee >= 0 && opts.feeRate >= 0 && (opts.fee == 0 || opts.feeRate == 0)
+	return opts, valid
+}
+
+// 解析收款列表 list的格式为ADDRESS:AMOUNT,ADDRESS:AMOUNT,...
+// file为JSON文件 内容为[{"address": ADDRESS, "amount": AMOUNT}, ...] 两者只能指定一个
+func parsePayments(list, file string) ([]chain.Payment, error) {
+	var payments []chain.Payment
+
+	if file != "" {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(data, &payments); err != nil {
+			return nil, fmt.Errorf("invalid payments file %s: %w", file, err)
+		}
+		return payments, nil
+	}
+
+	for _, item := range strings.Split(list, ",") {
+		parts := strings.Split(strings.TrimSpace(item), ":")
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("invalid payment %q, expected ADDRESS:AMOUNT", item)
+		}
+		amount, err := strconv.Atoi(parts[1])
+		if err != nil {
+			return nil, fmt.Errorf("invalid amount in payment %q: %w", item, err)
+		}
+		payments = append(payments, chain.Payment{Address: parts[0], Amount: amount})
+	}
+
+	return payments, nil
+}
+
+// 返回一个在收到中断信号时被取消的context
+func interruptContext() (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(context.Background())
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt)
+	go func() {
+		defer signal.Stop(interrupt)
+		select {
+		case <-interrupt:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	return ctx, cancel
+}
+
+// 启动节点 在port上接受连接 直到收到中断信号
+// connect不为空时只连接其中的节点 否则从地址簿与配置的种子节点中选择节点 并始终连接addNodes与配置中的addnode
+// miner不为空时在交易池中有交易时挖矿 奖励支付给miner
+func (cli *CLI) startNode(port int, minerAddress string, connect, addNodes []string) error {
+	bc, err := chain.NewBlockChain(cli.config.DBPath(), cli.params)
+	if err != nil {
+		return err
+	}
+	defer bc.Close()
+
+	mp, err := mempool.New(bc)
+	if err != nil {
+		return err
+	}
+
+	n, err := node.New(bc, mp, node.Config{
+		ListenAddr:   fmt.Sprintf(":%d", port),
+		Connect:      connect,
+		AddNodes:     append(append([]string{}, cli.config.AddNodes...), addNodes...),
+		Seeds:        cli.config.Seeds,
+		PeersFile:    cli.config.PeersPath(),
+		MinerAddress: minerAddress,
+		BanFile:      cli.config.BanListPath(),
+		Progress: func(progress node.SyncProgress) {
+			if progress.Done {
+				fmt.Printf("Synced to height %d\n", progress.Height)
+				return
+			}
+			fmt.Printf("Syncing: height %d / %d (%.1f blocks/s)\n", progress.Height, progress.TargetHeight, progress.BlocksPerSecond)
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := interruptContext()
+	defer cancel()
+
+	return n.Run(ctx)
+}
+
+// 打印所有尚未过期的封禁
+func (cli *CLI) listBanned() error {
+	bans, err := node.OpenBanList(cli.config.BanListPath())
+	if err != nil {
+		return err
+	}
+	list, err := bans.List()
+	if err != nil {
+		return err
+	}
+
+	for _, ban := range list {
+		fmt.Printf("%s until %s: %s\n", ban.Host, ban.Until.Format(time.RFC3339), ban.Reason)
+	}
+	return nil
+}
+
+// 解除对host的封禁 正在运行的节点在下一次检查时生效
+func (cli *CLI) unban(host string) error {
+	bans, err := node.OpenBanList(cli.config.BanListPath())
+	if err != nil {
+		return err
+	}
+	banned, err := bans.Unban(host)
+	if err != nil {
+		return err
+	}
+
+	if !banned {
+		fmt.Printf("%s is not banned\n", host)
+		return nil
+	}
+	fmt.Printf("Unbanned %s\n", host)
+	return nil
+}
+
+// 按手续费率从交易池中选取交易挖出blocks个区块 区块奖励支付给address
+// continuous为true时持续挖矿直到收到中断信号 workers为并行进行工作量证明的goroutine数
+func (cli *CLI) mine(address string, blocks int, continuous bool, workers int) error {
+	bc, err := chain.NewBlockChain(cli.config.DBPath(), cli.params)
+	if err != nil {
+		return err
+	}
+	defer bc.Close()
+
+	mp, err := mempool.New(bc)
+	if err != nil {
+		return err
+	}
+
+	m, err := miner.New(bc, mp, address)
+	if err != nil {
+		return err
+	}
+	m.Workers = workers
+	m.HashRate = func(rate float64) {
+		fmt.Printf("\rHash rate: %.2f kH/s", rate/1000)
+	}
+
+	// 收到中断信号时停止正在进行的工作量证明
+	ctx, cancel := interruptContext()
+	defer cancel()
+
+	for i := 0; continuous || i < blocks; i++ {
+		block, err := m.MineBlock(ctx)
+		if errors.Is(err, context.Canceled) {
+			fmt.Println("\nInterrupted")
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		fmt.Printf("\rMined block %x at height %d with %d transactions\n", block.Hash, block.Height, len(block.Transactions))
+	}
+
+	return nil
+}
+
+// 列出交易池中的交易 txid不为空时打印该交易 remove为true时将其从交易池中移除
+func (cli *CLI) mempool(txid string, remove bool) error {
+	bc, err := chain.NewBlockChain(cli.config.DBPath(), cli.params)
+	if err != nil {
+		return err
+	}
+	defer bc.Close()
+
+	mp, err := mempool.New(bc)
+	if err != nil {
+		return err
+	}
+
+	if txid == "" {
+		fmt.Printf("%d transactions, %d bytes\n", mp.Count(), mp.Size())
+		for _, entry := range mp.List() {
+			fmt.Printf("  %x  fee %d  size %d  feerate %.3f\n", entry.Tx.ID, entry.Fee, entry.Size, entry.FeeRate())
+		}
+		return nil
+	}
+
+	id, err := hex.DecodeString(txid)
+	if err != nil {
+		return fmt.Errorf("invalid transaction id %q: %w", txid, err)
+	}
+
+	if remove {
+		removed, err := mp.Remove(id)
+		if err != nil {
+			return err
+		}
+		for _, entry := range removed {
+			fmt.Printf("Removed %x\n", entry.Tx.ID)
+		}
+		return nil
+	}
+
+	entry, err := mp.Get(id)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Fee: %d\nSize: %d\n", entry.Fee, entry.Size)
+	fmt.Println(entry.Tx)
+	return nil
 }
 
 // cli的核心处理函数
 func (cli *CLI) Run() {
-	cli.validateArgs()
+	cfg, args, err := config.Load(os.Args[1:])
+	if err != nil {
+		fmt.Println("ERROR:", err)
+		os.Exit(1)
+	}
+	cli.config = cfg
+	cli.params, err = chain.ParamsForNetwork(cfg.Network)
+	if err != nil {
+		fmt.Println("ERROR:", err)
+		os.Exit(1)
+	}
+	cli.validateArgs(args)
 
 	getBalanceCmd := flag.NewFlagSet("getbalance", flag.ExitOnError)
+	historyCmd := flag.NewFlagSet("history", flag.ExitOnError)
 	createBlockchainCmd := flag.NewFlagSet("createblockchain", flag.ExitOnError)
 	sendCmd := flag.NewFlagSet("send", flag.ExitOnError)
+	sendManyCmd := flag.NewFlagSet("sendmany", flag.ExitOnError)
 	printChainCmd := flag.NewFlagSet("printchain", flag.ExitOnError)
 	createWalletCmd := flag.NewFlagSet("createwallet", flag.ExitOnError)
 	listAddressesCmd := flag.NewFlagSet("listaddresses", flag.ExitOnError)
 	reindexUTXOCmd := flag.NewFlagSet("reindexutxo", flag.ExitOnError)
+	reindexCmd := flag.NewFlagSet("reindex", flag.ExitOnError)
+	disconnectCmd := flag.NewFlagSet("disconnect", flag.ExitOnError)
+	getBlockCmd := flag.NewFlagSet("getblock", flag.ExitOnError)
+	supplyCmd := flag.NewFlagSet("supply", flag.ExitOnError)
+	mempoolCmd := flag.NewFlagSet("mempool", flag.ExitOnError)
+	mineCmd := flag.NewFlagSet("mine", flag.ExitOnError)
+	startNodeCmd := flag.NewFlagSet("startnode", flag.ExitOnError)
+	listBannedCmd := flag.NewFlagSet("listbanned", flag.ExitOnError)
+	unbanCmd := flag.NewFlagSet("unban", flag.ExitOnError)
 
 	getBalanceAddress := getBalanceCmd.String("address", "", "The address to get balance for")
-	createBlockchainAddress := createBlockchainCmd.String("address", "", "The address to send genesis block reward to")
-	sendFrom := sendCmd.String("from", "", "Source wallet address")
+	historyAddress := historyCmd.String("address", "", "The address to print the history of")
+	sendOpts := newSendFlags(sendCmd)
 	sendTo := sendCmd.String("to", "", "Destination wallet address")
 	sendAmount := sendCmd.Int("amount", 0, "Amount to send")
-
-	switch os.Args[1] {
+	sendManyOpts := newSendFlags(sendManyCmd)
+	sendManyTo := sendManyCmd.String("to", "", "Comma separated ADDRESS:AMOUNT payments")
+	sendManyFile := sendManyCmd.String("file", "", "JSON file with the payments")
+	mempoolTx := mempoolCmd.String("tx", "", "Transaction to print or remove")
+	mempoolRemove := mempoolCmd.Bool("remove", false, "Remove the transaction and its descendants")
+	mineAddress := mineCmd.String("address", "", "The address to send the block reward to")
+	mineBlocks := mineCmd.Int("blocks", 1, "Number of blocks to mine")
+	mineContinuous := mineCmd.Bool("continuous", false, "Keep mining until interrupted")
+	mineWorkers := mineCmd.Int("workers", 0, "Number of parallel proof-of-work workers (default: number of CPUs)")
+	startNodePort := startNodeCmd.Int("port", cli.params.DefaultPort, "Port to accept connections on")
+	startNodeMiner := startNodeCmd.String("miner", "", "Mine blocks when the mempool has transactions and send the rewards to this address")
+	startNodeConnect := startNodeCmd.String("connect", "", "Comma separated HOST:PORT of the only nodes to connect to")
+	startNodeAddNode := startNodeCmd.String("addnode", "", "Comma separated HOST:PORT of nodes to keep connected to")
+	unbanHost := unbanCmd.String("host", "", "IP of the banned peer")
+	disconnectBlocks := disconnectCmd.Int("blocks", 1, "Number of blocks to disconnect")
+	getBlockHeight := getBlockCmd.Int64("height", -1, "Height of the block in the main chain")
+	getBlockHash := getBlockCmd.String("hash", "", "Hash of the block")
+	reindexTxIndex := reindexCmd.Bool("txindex", false, "Enable and rebuild the transaction index")
+
+	switch args[0] {
 	case "getbalance":
-		err := getBalanceCmd.Parse(os.Args[2:])
+		err := getBalanceCmd.Parse(args[1:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "history":
+		err := historyCmd.Parse(args[1:])
 		if err != nil {
 			log.Panic(err)
 		}
 	case "createblockchain":
-		err := createBlockchainCmd.Parse(os.Args[2:])
+		err := createBlockchainCmd.Parse(args[1:])
 		if err != nil {
 			log.Panic(err)
 		}
 	case "printchain":
-		err := printChainCmd.Parse(os.Args[2:])
+		err := printChainCmd.Parse(args[1:])
 		if err != nil {
 			log.Panic(err)
 		}
 	case "send":
-		err := sendCmd.Parse(os.Args[2:])
+		err := sendCmd.Parse(args[1:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "sendmany":
+		err := sendManyCmd.Parse(args[1:])
 		if err != nil {
 			log.Panic(err)
 		}
 	case "createwallet":
-		err := createWalletCmd.Parse(os.Args[2:])
+		err := createWalletCmd.Parse(args[1:])
 		if err != nil {
 			log.Panic(err)
 		}
 
 	case "listaddresses":
-		err := listAddressesCmd.Parse(os.Args[2:])
+		err := listAddressesCmd.Parse(args[1:])
 		if err != nil {
 			log.Panic(err)
 		}
 
 	case "reindexutxo":
-		err := reindexUTXOCmd.Parse(os.Args[2:])
+		err := reindexUTXOCmd.Parse(args[1:])
+		if err != nil {
+			log.Panic(err)
+		}
+
+	case "reindex":
+		err := reindexCmd.Parse(args[1:])
+		if err != nil {
+			log.Panic(err)
+		}
+
+	case "disconnect":
+		err := disconnectCmd.Parse(args[1:])
+		if err != nil {
+			log.Panic(err)
+		}
+
+	case "getblock":
+		err := getBlockCmd.Parse(args[1:])
+		if err != nil {
+			log.Panic(err)
+		}
+
+	case "supply":
+		err := supplyCmd.Parse(args[1:])
+		if err != nil {
+			log.Panic(err)
+		}
+
+	case "mempool":
+		err := mempoolCmd.Parse(args[1:])
+		if err != nil {
+			log.Panic(err)
+		}
+
+	case "mine":
+		err := mineCmd.Parse(args[1:])
+		if err != nil {
+			log.Panic(err)
+		}
+
+	case "startnode":
+		err := startNodeCmd.Parse(args[1:])
+		if err != nil {
+			log.Panic(err)
+		}
+
+	case "listbanned":
+		err := listBannedCmd.Parse(args[1:])
+		if err != nil {
+			log.Panic(err)
+		}
+
+	case "unban":
+		err := unbanCmd.Parse(args[1:])
 		if err != nil {
 			log.Panic(err)
 		}
@@ -204,44 +868,121 @@ func (cli *CLI) Run() {
 		os.Exit(1)
 	}
 
-	if getBalanceCmd.Parsed() {
+	switch {
+	case getBalanceCmd.Parsed():
 		if *getBalanceAddress == "" {
 			getBalanceCmd.Usage()
 			os.Exit(1)
 		}
-		cli.getBalance(*getBalanceAddress)
-	}
+		err = cli.getBalance(*getBalanceAddress)
 
-	if createBlockchainCmd.Parsed() {
-		if *createBlockchainAddress == "" {
-			createBlockchainCmd.Usage()
+	case historyCmd.Parsed():
+		if *historyAddress == "" {
+			historyCmd.Usage()
 			os.Exit(1)
 		}
-		cli.createBlockchain(*createBlockchainAddress)
-	}
+		err = cli.history(*historyAddress)
 
-	if createWalletCmd.Parsed() {
-		cli.createWallet()
-	}
+	case createBlockchainCmd.Parsed():
+		err = cli.createBlockchain()
 
-	if listAddressesCmd.Parsed() {
-		cli.listAddresses()
-	}
+	case createWalletCmd.Parsed():
+		err = cli.createWallet()
 
-	if printChainCmd.Parsed() {
-		cli.printChain()
-	}
+	case listAddressesCmd.Parsed():
+		err = cli.listAddresses()
 
-	if reindexUTXOCmd.Parsed() {
-		cli.reindexUTXO()
-	}
+	case printChainCmd.Parsed():
+		err = cli.printChain()
+
+	case reindexUTXOCmd.Parsed():
+		err = cli.reindexUTXO()
+
+	case reindexCmd.Parsed():
+		err = cli.reindex(*reindexTxIndex)
+
+	case disconnectCmd.Parsed():
+		if *disconnectBlocks <= 0 {
+			disconnectCmd.Usage()
+			os.Exit(1)
+		}
+		err = cli.disconnectBlocks(*disconnectBlocks)
+
+	case getBlockCmd.Parsed():
+		if *getBlockHeight < 0 && *getBlockHash == "" {
+			getBlockCmd.Usage()
+			os.Exit(1)
+		}
+		err = cli.getBlock(*getBlockHeight, *getBlockHash)
+
+	case supplyCmd.Parsed():
+		err = cli.supply()
+
+	case mempoolCmd.Parsed():
+		if *mempoolRemove && *mempoolTx == "" {
+			mempoolCmd.Usage()
+			os.Exit(1)
+		}
+		err = cli.mempool(*mempoolTx, *mempoolRemove)
+
+	case mineCmd.Parsed():
+		if *mineAddress == "" || *mineBlocks <= 0 {
+			mineCmd.Usage()
+			os.Exit(1)
+		}
+		err = cli.mine(*mineAddress, *mineBlocks, *mineContinuous, *mineWorkers)
+
+	case startNodeCmd.Parsed():
+		if *startNodePort <= 0 || *startNodePort > 65535 {
+			startNodeCmd.Usage()
+			os.Exit(1)
+		}
+
+		var connect, addNodes []string
+		if *startNodeConnect != "" {
+			connect = strings.Split(*startNodeConnect, ",")
+		}
+		if *startNodeAddNode != "" {
+			addNodes = strings.Split(*startNodeAddNode, ",")
+		}
+		err = cli.startNode(*startNodePort, *startNodeMiner, connect, addNodes)
+
+	case listBannedCmd.Parsed():
+		err = cli.listBanned()
+
+	case unbanCmd.Parsed():
+		if *unbanHost == "" {
+			unbanCmd.Usage()
+			os.Exit(1)
+		}
+		err = cli.unban(*unbanHost)
 
-	if sendCmd.Parsed() {
-		if *sendFrom == "" || *sendTo == "" || *sendAmount <= 0 {
+	case sendCmd.Parsed():
+		opts, valid := sendOpts.options()
+		if !valid || *sendTo == "" || *sendAmount <= 0 {
 			sendCmd.Usage()
 			os.Exit(1)
 		}
 
-		cli.send(*sendFrom, *sendTo, *sendAmount)
+		err = cli.send(*sendTo, *sendAmount, opts)
+
+	case sendManyCmd.Parsed():
+		opts, valid := sendManyOpts.options()
+		if !valid || (*sendManyTo == "") == (*sendManyFile == "") {
+			sendManyCmd.Usage()
+			os.Exit(1)
+		}
+
+		var payments []chain.Payment
+		payments, err = parsePayments(*sendManyTo, *sendManyFile)
+		if err == nil {
+			err = cli.sendPayments(payments, opts)
+		}
+	}
+
+	// 各个命令内部通过defer释放数据库 此处它们均已返回 可以安全退出
+	if err != nil {
+		fmt.Println("ERROR:", err)
+		os.Exit(1)
 	}
 }
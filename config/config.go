@@ -0,0 +1,231 @@
+// Package config 负责解析节点的配置 包括数据目录、数据库路径、钱包路径、网络名称与初始连接的节点
+//
+// 配置的优先级从低到高依次为: 默认值、配置文件、环境变量、命令行参数
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// 支持的网络名称
+const (
+	Mainnet = "mainnet"
+	Testnet = "testnet"
+	Regtest = "regtest"
+)
+
+const (
+	defaultDBFile     = "blockchain.db"
+	defaultWalletFile = "wallet.dat"
+	defaultConfigFile = "config.json"
+	defaultBanFile    = "banlist.json"
+	defaultPeersFile  = "peers.json"
+)
+
+// 环境变量的名称
+const (
+	EnvConfigFile = "BLOCKCHAIN_CONFIG"
+	EnvDataDir    = "BLOCKCHAIN_DATADIR"
+	EnvDBFile     = "BLOCKCHAIN_DB"
+	EnvWalletFile = "BLOCKCHAIN_WALLET"
+	EnvNetwork    = "BLOCKCHAIN_NETWORK"
+)
+
+// ErrUnknownNetwork 网络名称不是mainnet/testnet/regtest之一
+var ErrUnknownNetwork = errors.New("unknown network")
+
+// Config 节点的配置 DBFile与WalletFile为空时根据DataDir与Network推导
+type Config struct {
+	DataDir    string `json:"datadir"`
+	DBFile     string `json:"dbfile"`
+	WalletFile string `json:"walletfile"`
+	Network    string `json:"network"`
+	// Seeds 节点启动时加入地址簿的种子节点 HOST:PORT
+	Seeds []string `json:"seeds"`
+	// AddNodes 节点始终保持连接的节点 HOST:PORT
+	AddNodes []string `json:"addnode"`
+}
+
+// Default 返回默认配置 数据目录为用户主目录下的.blockchain
+func Default() *Config {
+	dataDir := ".blockchain"
+	if home, err := os.UserHomeDir(); err == nil {
+		dataDir = filepath.Join(home, ".blockchain")
+	}
+
+	return &Config{
+		DataDir: dataDir,
+		Network: Mainnet,
+	}
+}
+
+// Load 按照默认值、配置文件、环境变量、命令行参数的顺序加载配置
+// args为命令之前的全局参数 返回值中的rest为剩余未被解析的参数 其第一个元素即为命令
+func Load(args []string) (cfg *Config, rest []string, err error) {
+	cfg = Default()
+
+	var flags Config
+	var configFile string
+	fs := flag.NewFlagSet("blockchain", flag.ContinueOnError)
+	fs.StringVar(&configFile, "config", "", "Path of the JSON config file (env "+EnvConfigFile+")")
+	fs.StringVar(&flags.DataDir, "datadir", "", "Data directory (env "+EnvDataDir+")")
+	fs.StringVar(&flags.DBFile, "db", "", "Blockchain database path (env "+EnvDBFile+")")
+	fs.StringVar(&flags.WalletFile, "wallet", "", "Wallet file path (env "+EnvWalletFile+")")
+	fs.StringVar(&flags.Network, "network", "", "Network name: mainnet, testnet or regtest (env "+EnvNetwork+")")
+	if err := fs.Parse(args); err != nil {
+		return nil, nil, err
+	}
+
+	env := fromEnv()
+
+	// 配置文件的位置本身也可以由参数、环境变量或数据目录决定
+	explicit := true
+	switch {
+	case configFile != "":
+	case os.Getenv(EnvConfigFile) != "":
+		configFile = os.Getenv(EnvConfigFile)
+	default:
+		explicit = false
+		dataDir := cfg.DataDir
+		if env.DataDir != "" {
+			dataDir = env.DataDir
+		}
+		if flags.DataDir != "" {
+			dataDir = flags.DataDir
+		}
+		configFile = filepath.Join(dataDir, defaultConfigFile)
+	}
+
+	file, err := readFile(configFile)
+	if err != nil && (explicit || !os.IsNotExist(err)) {
+		return nil, nil, err
+	}
+
+	cfg.merge(file)
+	cfg.merge(env)
+	cfg.merge(&flags)
+
+	if err := cfg.Validate(); err != nil {
+		return nil, nil, err
+	}
+
+	return cfg, fs.Args(), nil
+}
+
+// Validate 检查配置是否合法
+func (c *Config) Validate() error {
+	switch c.Network {
+	case Mainnet, Testnet, Regtest:
+	default:
+		return fmt.Errorf("%w: %q", ErrUnknownNetwork, c.Network)
+	}
+
+	if c.DataDir == "" {
+		return errors.New("data directory must not be empty")
+	}
+
+	return nil
+}
+
+// NetworkDir 返回当前网络的数据目录 主网直接使用DataDir 其余网络使用其下的子目录
+func (c *Config) NetworkDir() string {
+	if c.Network == Mainnet {
+		return c.DataDir
+	}
+
+	return filepath.Join(c.DataDir, c.Network)
+}
+
+// DBPath 返回区块链数据库的路径
+func (c *Config) DBPath() string {
+	if c.DBFile != "" {
+		return c.DBFile
+	}
+
+	return filepath.Join(c.NetworkDir(), defaultDBFile)
+}
+
+// WalletPath 返回钱包文件的路径
+func (c *Config) WalletPath() string {
+	if c.WalletFile != "" {
+		return c.WalletFile
+	}
+
+	return filepath.Join(c.NetworkDir(), defaultWalletFile)
+}
+
+// BanListPath 返回节点封禁列表的路径
+func (c *Config) BanListPath() string {
+	return filepath.Join(c.NetworkDir(), defaultBanFile)
+}
+
+// PeersPath 返回节点地址簿的路径
+func (c *Config) PeersPath() string {
+	return filepath.Join(c.NetworkDir(), defaultPeersFile)
+}
+
+// EnsureDirs 创建数据库与钱包文件所在的目录
+func (c *Config) EnsureDirs() error {
+	for _, path := range []string{c.DBPath(), c.WalletPath()} {
+		if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// 使用other中非空的字段覆盖当前配置
+func (c *Config) merge(other *Config) {
+	if other == nil {
+		return
+	}
+	if other.DataDir != "" {
+		c.DataDir = other.DataDir
+	}
+	if other.DBFile != "" {
+		c.DBFile = other.DBFile
+	}
+	if other.WalletFile != "" {
+		c.WalletFile = other.WalletFile
+	}
+	if other.Network != "" {
+		c.Network = other.Network
+	}
+	if len(other.Seeds) > 0 {
+		c.Seeds = other.Seeds
+	}
+	if len(other.AddNodes) > 0 {
+		c.AddNodes = other.AddNodes
+	}
+}
+
+func fromEnv() *Config {
+	return &Config{
+		DataDir:    os.Getenv(EnvDataDir),
+		DBFile:     os.Getenv(EnvDBFile),
+		WalletFile: os.Getenv(EnvWalletFile),
+		Network:    os.Getenv(EnvNetwork),
+	}
+}
+
+// 读取JSON格式的配置文件
+func readFile(path string) (*Config, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var cfg Config
+	if err := json.Unmarshal(content, &cfg); err != nil {
+		return nil, fmt.Errorf("config file %s: %v", path, err)
+	}
+
+	return &cfg, nil
+}
@@ -0,0 +1,108 @@
+// Package core 定义了区块与交易等链上的核心数据结构及其序列化方式
+package core
+
+import (
+	"bytes"
+	"encoding/gob"
+	"time"
+
+	"blockchain/merkle"
+)
+
+// MaxBlockSize 区块序列化后允许的最大字节数
+const MaxBlockSize = 1000000
+
+// Block 仅包含公链的核心结构
+type Block struct {
+	Timestamp     int64
+	Transactions  []*Transaction
+	PrevBlockHash []byte
+	Hash          []byte
+	Nonce         int
+	// 区块的难度目标 使用比特币的compact格式编码
+	Bits uint32
+	// 交易的默克尔树根 工作量证明只覆盖区块头 因此需要单独保存
+	MerkleRoot []byte
+	// 区块在链中的高度 创世块的高度为0
+	Height int64
+}
+
+// BlockHeader 区块头 即区块中除交易之外的字段 工作量证明只覆盖区块头
+type BlockHeader struct {
+	Timestamp     int64
+	PrevBlockHash []byte
+	Hash          []byte
+	Nonce         int
+	Bits          uint32
+	MerkleRoot    []byte
+	Height        int64
+}
+
+// Header 返回区块的区块头
+func (b *Block) Header() *BlockHeader {
+	return &BlockHeader{
+		Timestamp:     b.Timestamp,
+		PrevBlockHash: b.PrevBlockHash,
+		Hash:          b.Hash,
+		Nonce:         b.Nonce,
+		Bits:          b.Bits,
+		MerkleRoot:    b.MerkleRoot,
+		Height:        b.Height,
+	}
+}
+
+// Block 返回只有区块头而没有交易的区块 用于单独验证区块头的工作量证明
+func (h *BlockHeader) Block() *Block {
+	return &Block{
+		Timestamp:     h.Timestamp,
+		PrevBlockHash: h.PrevBlockHash,
+		Hash:          h.Hash,
+		Nonce:         h.Nonce,
+		Bits:          h.Bits,
+		MerkleRoot:    h.MerkleRoot,
+		Height:        h.Height,
+	}
+}
+
+// HashTransactions 将hash的计算方法改为默克尔树
+func (b *Block) HashTransactions() []byte {
+	var transactions [][]byte
+
+	for _, tx := range b.Transactions {
+		transactions = append(transactions, tx.Serialize())
+	}
+	mTree := merkle.NewMerkleTree(transactions)
+
+	return mTree.RootNode.Data
+}
+
+// NewBlock 构造一个高度为height 难度为bits 尚未进行工作量证明的区块 Nonce与Hash需要由pow包填充
+func NewBlock(transcations []*Transaction, prevBlockHash []byte, height int64, bits uint32) *Block {
+	block := &Block{time.Now().Unix(), transcations, prevBlockHash, []byte{}, 0, bits, nil, height}
+	block.MerkleRoot = block.HashTransactions()
+
+	return block
+}
+
+// Serialize serialize the block
+func (b *Block) Serialize() []byte {
+	return gobEncode(b)
+}
+
+// Size 返回区块序列化后的字节数
+func (b *Block) Size() int {
+	return len(b.Serialize())
+}
+
+// DeserializeBlock deserialize the block
+func DeserializeBlock(d []byte) (*Block, error) {
+	var block Block
+	decoder := gob.NewDecoder(bytes.NewReader(d))
+	err := decoder.Decode(&block)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &block, nil
+}
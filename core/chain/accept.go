@@ -0,0 +1,422 @@
+package chain
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+
+	"blockchain/core"
+	"blockchain/pow"
+	"blockchain/storage"
+)
+
+// 孤块池中最多保存的区块数
+const maxOrphanBlocks = 100
+
+// BlockStatus AddBlock接受一个区块后该区块所处的位置
+type BlockStatus int
+
+const (
+	// BlockMainChain 区块成为了主链的最新区块 可能引发了链重组
+	BlockMainChain BlockStatus = iota
+	// BlockSideChain 区块被保存在累计工作量不超过主链的侧链上
+	BlockSideChain
+	// BlockOrphan 区块的父区块未知 暂存在孤块池中等待父区块到达
+	BlockOrphan
+)
+
+func (s BlockStatus) String() string {
+	switch s {
+	case BlockMainChain:
+		return "main chain"
+	case BlockSideChain:
+		return "side chain"
+	case BlockOrphan:
+		return "orphan"
+	}
+
+	return fmt.Sprintf("BlockStatus(%d)", int(s))
+}
+
+// AddBlock 接受一个已经完成工作量证明的区块
+//
+// 父区块未知的区块暂存在孤块池中 父区块到达后会被自动处理
+// 其余区块在验证区块头后被保存 若其所在链的累计工作量超过主链 则切换到该链:
+// 断开旧链上分叉点之后的区块 依次验证并连接新链上的区块
+// 保存区块 更新UTXO集与链尾在同一个存储事务中完成 任意一步失败都不会留下修改
+// 连接时违反共识规则的区块及其后代被标记为无效 之后以它们为父区块的区块直接被拒绝
+func (bc *Blockchain) AddBlock(block *core.Block) (BlockStatus, error) {
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+
+	status, err := bc.acceptBlock(block)
+	if err != nil {
+		return status, err
+	}
+
+	if status == BlockOrphan {
+		bc.addOrphan(block)
+		return status, nil
+	}
+
+	bc.processOrphans(block.Hash)
+
+	return status, nil
+}
+
+// 保存区块并在需要时切换主链 调用者需持有bc.mu
+func (bc *Blockchain) acceptBlock(block *core.Block) (BlockStatus, error) {
+	var status BlockStatus
+	// 连接失败时需要标记为无效的区块 按从链尾到分叉点的顺序排列
+	var invalid []*core.Block
+
+	if bc.orphans[hex.EncodeToString(block.Hash)] != nil {
+		return BlockOrphan, fmt.Errorf("%w: %x", ErrDuplicateBlock, block.Hash)
+	}
+
+	err := bc.db.Update(func(tx storage.Tx) error {
+		known, err := getBlockMeta(tx, block.Hash)
+		if err != nil {
+			return err
+		}
+		if known != nil && known.Invalid {
+			return blockError(block, nil, "block is known to be invalid")
+		}
+		if known != nil {
+			return fmt.Errorf("%w: %x", ErrDuplicateBlock, block.Hash)
+		}
+
+		parent, err := getBlockMeta(tx, block.PrevBlockHash)
+		if err != nil {
+			return err
+		}
+		if parent == nil {
+			// 无法验证难度与时间戳 只检查区块自身的工作量证明 避免孤块池被随意填满
+			if err := checkOrphanBlock(block, bc.params); err != nil {
+				return err
+			}
+			status = BlockOrphan
+			return nil
+		}
+		if parent.Invalid {
+			return blockError(block, nil, "previous block %x is invalid", block.PrevBlockHash)
+		}
+
+		state := txState{tx, bc.params}
+		if err := checkBlockHeader(block, state); err != nil {
+			return err
+		}
+
+		blocks := tx.Bucket(storage.BlocksBucket)
+		meta := newBlockMeta(block, parent)
+		if err := blocks.Put(block.Hash, block.Serialize()); err != nil {
+			return err
+		}
+		if err := putBlockMeta(tx, block.Hash, meta); err != nil {
+			return err
+		}
+
+		tip := blocks.Get([]byte("l"))
+		tipMeta, err := getBlockMeta(tx, tip)
+		if err != nil {
+			return err
+		}
+		if tipMeta == nil {
+			return fmt.Errorf("chain tip %x is missing from the block index", tip)
+		}
+
+		// 累计工作量相同时保留先收到的链
+		if meta.work().Cmp(tipMeta.work()) <= 0 {
+			status = BlockSideChain
+			return nil
+		}
+
+		if bytes.Equal(block.PrevBlockHash, tip) {
+			err = connectBlock(tx, block, state)
+			if errors.Is(err, ErrInvalidBlock) {
+				invalid = []*core.Block{block}
+			}
+		} else {
+			invalid, err = reorganize(tx, tip, block, state)
+		}
+		if err != nil {
+			return err
+		}
+
+		status = BlockMainChain
+		return blocks.Put([]byte("l"), block.Hash)
+	})
+	if err != nil {
+		// 失败的事务已经回滚 在新的事务中记录无效的区块
+		if len(invalid) > 0 {
+			if markErr := bc.db.Update(func(tx storage.Tx) error {
+				return markInvalid(tx, invalid)
+			}); markErr != nil {
+				return status, fmt.Errorf("%v (cannot mark the blocks invalid: %v)", err, markErr)
+			}
+		}
+		return status, err
+	}
+
+	if status == BlockMainChain {
+		bc.tip = block.Hash
+	}
+
+	return status, nil
+}
+
+// 将区块标记为无效 blocks按从后代到祖先的顺序排列
+// 回滚的事务中刚保存的区块没有区块信息 根据其父区块重新计算
+func markInvalid(tx storage.Tx, blocks []*core.Block) error {
+	for i := len(blocks) - 1; i >= 0; i-- {
+		block := blocks[i]
+
+		meta, err := getBlockMeta(tx, block.Hash)
+		if err != nil {
+			return err
+		}
+		if meta == nil {
+			parent, err := getBlockMeta(tx, block.PrevBlockHash)
+			if err != nil {
+				return err
+			}
+			meta = newBlockMeta(block, parent)
+		}
+
+		meta.Invalid = true
+		if err := putBlockMeta(tx, block.Hash, meta); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// 验证区块中的交易 将其连接到UTXO集与交易索引并记录为主链上的区块
+func connectBlock(tx storage.Tx, block *core.Block, state txState) error {
+	if err := checkBlockTransactions(block, state); err != nil {
+		return err
+	}
+	if err := connectUTXO(tx, block); err != nil {
+		return err
+	}
+	if err := indexTransactions(tx, block); err != nil {
+		return err
+	}
+
+	return putMainChainBlock(tx, block)
+}
+
+// 将主链的最后一个区块从UTXO集、交易索引与高度索引中断开
+func disconnectBlock(tx storage.Tx, block *core.Block) error {
+	if err := disconnectUTXO(tx, block); err != nil {
+		return fmt.Errorf("cannot disconnect block %x: %w", block.Hash, err)
+	}
+	if err := unindexTransactions(tx, block); err != nil {
+		return err
+	}
+
+	return deleteMainChainBlock(tx, block)
+}
+
+// 将主链从oldTip切换到以newTip结尾的链
+// 新链上的区块违反共识规则时返回该区块及其在新链上的所有后代
+func reorganize(tx storage.Tx, oldTip []byte, newTip *core.Block, state txState) ([]*core.Block, error) {
+	detach, attach, err := findFork(tx, oldTip, newTip)
+	if err != nil {
+		return nil, err
+	}
+
+	// 从旧链尾开始断开 直到分叉点
+	for _, block := range detach {
+		if err := disconnectBlock(tx, block); err != nil {
+			return nil, err
+		}
+	}
+
+	// 从分叉点开始连接新链上的区块 区块头在保存时已经验证过
+	for i := len(attach) - 1; i >= 0; i-- {
+		meta, err := getBlockMeta(tx, attach[i].Hash)
+		if err != nil {
+			return nil, err
+		}
+		if meta != nil && meta.Invalid {
+			return attach[:i+1], blockError(attach[i], nil, "block is known to be invalid")
+		}
+
+		err = connectBlock(tx, attach[i], state)
+		if errors.Is(err, ErrInvalidBlock) {
+			return attach[:i+1], err
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return nil, nil
+}
+
+// DisconnectBlocks 从主链尾部断开n个区块 按撤销记录恢复UTXO集并将链尾回退n个区块
+// 被断开的区块仍保存在存储中 成为侧链上的区块
+// 返回被断开的区块 按从原链尾开始的顺序排列 创世块不能被断开
+func (bc *Blockchain) DisconnectBlocks(n int) ([]*core.Block, error) {
+	return bc.disconnectBlocks(n, nil)
+}
+
+// 从主链尾部断开n个区块 expectedTip不为空时主链的链尾必须是该区块
+func (bc *Blockchain) disconnectBlocks(n int, expectedTip []byte) ([]*core.Block, error) {
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+
+	var detached []*core.Block
+	err := bc.db.Update(func(tx storage.Tx) error {
+		blocks := tx.Bucket(storage.BlocksBucket)
+		tip := blocks.Get([]byte("l"))
+		if expectedTip != nil && !bytes.Equal(tip, expectedTip) {
+			return fmt.Errorf("block %x is not the chain tip %x", expectedTip, tip)
+		}
+
+		for i := 0; i < n; i++ {
+			block, err := getBlock(blocks, tip)
+			if err != nil {
+				return err
+			}
+			if len(block.PrevBlockHash) == 0 {
+				return ErrDisconnectGenesis
+			}
+
+			if err := disconnectBlock(tx, block); err != nil {
+				return err
+			}
+			detached = append(detached, block)
+			tip = block.PrevBlockHash
+		}
+
+		return blocks.Put([]byte("l"), tip)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if len(detached) > 0 {
+		bc.tip = detached[len(detached)-1].PrevBlockHash
+	}
+
+	return detached, nil
+}
+
+// 找到两条链的分叉点
+// detach为旧链上需要断开的区块 attach为新链上需要连接的区块 均按从链尾到分叉点的顺序排列
+func findFork(tx storage.Tx, oldTip []byte, newTip *core.Block) (detach, attach []*core.Block, err error) {
+	blocks := tx.Bucket(storage.BlocksBucket)
+
+	oldBlock, err := getBlock(blocks, oldTip)
+	if err != nil {
+		return nil, nil, err
+	}
+	newBlock := newTip
+
+	for !bytes.Equal(oldBlock.Hash, newBlock.Hash) {
+		// 每次回退较高的一侧 高度相同时两侧同时回退
+		if oldBlock.Height >= newBlock.Height {
+			detach = append(detach, oldBlock)
+			oldBlock, err = getBlock(blocks, oldBlock.PrevBlockHash)
+			if err != nil {
+				return nil, nil, err
+			}
+		}
+		if newBlock.Height > oldBlock.Height {
+			attach = append(attach, newBlock)
+			newBlock, err = getBlock(blocks, newBlock.PrevBlockHash)
+			if err != nil {
+				return nil, nil, err
+			}
+		}
+	}
+
+	return detach, attach, nil
+}
+
+// 孤块只能做不依赖链状态的检查
+func checkOrphanBlock(block *core.Block, params *ConsensusParams) error {
+	if len(block.Transactions) == 0 {
+		return blockError(block, nil, "block has no transactions")
+	}
+	if size := block.Size(); size > core.MaxBlockSize {
+		return blockError(block, nil, "block size %d exceeds the maximum %d", size, core.MaxBlockSize)
+	}
+
+	return CheckHeaderProof(block.Header(), params)
+}
+
+// CheckHeaderProof 验证区块头自身的工作量证明 不依赖链状态
+// 难度不能低于params允许的最小难度 hash需要与区块头一致且小于Bits对应的目标值
+func CheckHeaderProof(header *core.BlockHeader, params *ConsensusParams) error {
+	block := header.Block()
+	if pow.CompactToBig(header.Bits).Cmp(pow.CompactToBig(params.PowLimitBits)) > 0 {
+		return blockError(block, nil, "bits %08x are below the minimum difficulty", header.Bits)
+	}
+
+	proof := pow.NewProofOfWork(block)
+	if !proof.Validate(header.Bits) || !bytes.Equal(header.Hash, proof.Hash()) {
+		return blockError(block, nil, "proof of work is invalid")
+	}
+
+	return nil
+}
+
+// 将孤块加入孤块池 池满时随机移除一个
+func (bc *Blockchain) addOrphan(block *core.Block) {
+	if len(bc.orphans) >= maxOrphanBlocks {
+		for hash := range bc.orphans {
+			bc.removeOrphan(bc.orphans[hash])
+			break
+		}
+	}
+
+	if bc.orphans == nil {
+		bc.orphans = make(map[string]*core.Block)
+		bc.orphansByPrev = make(map[string][]*core.Block)
+	}
+
+	bc.orphans[hex.EncodeToString(block.Hash)] = block
+	prev := hex.EncodeToString(block.PrevBlockHash)
+	bc.orphansByPrev[prev] = append(bc.orphansByPrev[prev], block)
+}
+
+func (bc *Blockchain) removeOrphan(block *core.Block) {
+	delete(bc.orphans, hex.EncodeToString(block.Hash))
+
+	prev := hex.EncodeToString(block.PrevBlockHash)
+	siblings := bc.orphansByPrev[prev]
+	for i, orphan := range siblings {
+		if bytes.Equal(orphan.Hash, block.Hash) {
+			siblings = append(siblings[:i], siblings[i+1:]...)
+			break
+		}
+	}
+	if len(siblings) == 0 {
+		delete(bc.orphansByPrev, prev)
+	} else {
+		bc.orphansByPrev[prev] = siblings
+	}
+}
+
+// 依次处理所有以hash为祖先的孤块 不合法的孤块被直接丢弃
+func (bc *Blockchain) processOrphans(hash []byte) {
+	queue := [][]byte{hash}
+
+	for len(queue) > 0 {
+		prev := hex.EncodeToString(queue[0])
+		queue = queue[1:]
+
+		for _, orphan := range append([]*core.Block{}, bc.orphansByPrev[prev]...) {
+			bc.removeOrphan(orphan)
+
+			if _, err := bc.acceptBlock(orphan); err == nil {
+				queue = append(queue, orphan.Hash)
+			}
+		}
+	}
+}
@@ -0,0 +1,268 @@
+package chain_test
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"blockchain/core"
+	"blockchain/core/chain"
+	"blockchain/internal/chaintest"
+	"blockchain/wallet"
+)
+
+// 在src上构造接在prev之后、铸币交易向pubKeyHash支付value的区块
+// 区块同时被加入src 以便继续构造它的后代 加入失败时忽略错误
+func buildBlock(t *testing.T, src *chain.Blockchain, prev, pubKeyHash []byte, value int) *core.Block {
+	t.Helper()
+
+	height, err := src.BlockHeight(prev)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block := chaintest.NewBlockOn(t, src, prev, chaintest.Coinbase(t, height+1, pubKeyHash, value))
+	src.AddBlock(block)
+
+	return block
+}
+
+func TestAddBlock(t *testing.T) {
+	subsidy := chain.RegTestParams.Consensus.BlockSubsidy(1)
+	mainKey := wallet.HashPubKey(chaintest.NewWallet(t).PublicKey)
+	forkKey := wallet.HashPubKey(chaintest.NewWallet(t).PublicKey)
+
+	type result struct {
+		status chain.BlockStatus
+		err    error
+	}
+	tests := []struct {
+		name string
+		// 依次构造的区块 genesis为创世块的hash
+		build func(t *testing.T, src *chain.Blockchain, genesis []byte) []*core.Block
+		// 按顺序加入区块 元素为build返回的区块的下标
+		order []int
+		want  []result
+		// 最终的链尾 为build返回的区块的下标
+		tip int
+	}{
+		{
+			name: "extend the tip",
+			build: func(t *testing.T, src *chain.Blockchain, genesis []byte) []*core.Block {
+				a1 := buildBlock(t, src, genesis, mainKey, subsidy)
+				a2 := buildBlock(t, src, a1.Hash, mainKey, subsidy)
+				return []*core.Block{a1, a2}
+			},
+			order: []int{0, 1},
+			want:  []result{{chain.BlockMainChain, nil}, {chain.BlockMainChain, nil}},
+			tip:   1,
+		},
+		{
+			name: "equal work fork keeps the first block",
+			build: func(t *testing.T, src *chain.Blockchain, genesis []byte) []*core.Block {
+				a1 := buildBlock(t, src, genesis, mainKey, subsidy)
+				b1 := buildBlock(t, src, genesis, forkKey, subsidy)
+				return []*core.Block{a1, b1}
+			},
+			order: []int{0, 1},
+			want:  []result{{chain.BlockMainChain, nil}, {chain.BlockSideChain, nil}},
+			tip:   0,
+		},
+		{
+			name: "fork with more work wins",
+			build: func(t *testing.T, src *chain.Blockchain, genesis []byte) []*core.Block {
+				a1 := buildBlock(t, src, genesis, mainKey, subsidy)
+				b1 := buildBlock(t, src, genesis, forkKey, subsidy)
+				b2 := buildBlock(t, src, b1.Hash, forkKey, subsidy)
+				return []*core.Block{a1, b1, b2}
+			},
+			order: []int{0, 1, 2},
+			want:  []result{{chain.BlockMainChain, nil}, {chain.BlockSideChain, nil}, {chain.BlockMainChain, nil}},
+			tip:   2,
+		},
+		{
+			name: "reorganization to an invalid block fails",
+			build: func(t *testing.T, src *chain.Blockchain, genesis []byte) []*core.Block {
+				a1 := buildBlock(t, src, genesis, mainKey, subsidy)
+				b1 := buildBlock(t, src, genesis, forkKey, subsidy+1)
+				b2 := buildBlock(t, src, b1.Hash, forkKey, subsidy)
+				return []*core.Block{a1, b1, b2}
+			},
+			order: []int{0, 1, 2},
+			want:  []result{{chain.BlockMainChain, nil}, {chain.BlockSideChain, nil}, {err: chain.ErrInvalidBlock}},
+			tip:   0,
+		},
+		{
+			name: "orphan connects when its parent arrives",
+			build: func(t *testing.T, src *chain.Blockchain, genesis []byte) []*core.Block {
+				a1 := buildBlock(t, src, genesis, mainKey, subsidy)
+				a2 := buildBlock(t, src, a1.Hash, mainKey, subsidy)
+				a3 := buildBlock(t, src, a2.Hash, mainKey, subsidy)
+				return []*core.Block{a1, a2, a3}
+			},
+			order: []int{2, 1, 0},
+			want:  []result{{chain.BlockOrphan, nil}, {chain.BlockOrphan, nil}, {chain.BlockMainChain, nil}},
+			tip:   2,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// 区块在另一条链上构造 两条链的创世块相同
+			src := chaintest.NewChain(t, nil)
+			bc := chaintest.NewChain(t, nil)
+			blocks := tt.build(t, src, bc.Tip())
+
+			for i, index := range tt.order {
+				status, err := bc.AddBlock(blocks[index])
+				if !errors.Is(err, tt.want[i].err) {
+					t.Fatalf("AddBlock(block %d) error = %v, want %v", index, err, tt.want[i].err)
+				}
+				if err == nil && status != tt.want[i].status {
+					t.Fatalf("AddBlock(block %d) = %s, want %s", index, status, tt.want[i].status)
+				}
+			}
+
+			tip := blocks[tt.tip]
+			if !bytes.Equal(bc.Tip(), tip.Hash) {
+				t.Fatalf("tip = %x, want block %d %x", bc.Tip(), tt.tip, tip.Hash)
+			}
+
+			// 主链上的区块与高度索引一致 铸币交易的输出只在区块位于主链上时存在
+			mainChain := make(map[string]bool)
+			for block := tip; len(block.PrevBlockHash) != 0; {
+				mainChain[string(block.Hash)] = true
+				byHeight, err := bc.GetBlockByHeight(block.Height)
+				if err != nil || !bytes.Equal(byHeight.Hash, block.Hash) {
+					t.Fatalf("GetBlockByHeight(%d) = %v, want %x", block.Height, err, block.Hash)
+				}
+				if block, err = bc.GetBlockByHash(block.PrevBlockHash); err != nil {
+					t.Fatal(err)
+				}
+			}
+			for i, block := range blocks {
+				out, err := bc.FetchUTXO(block.Transactions[0].ID, 0)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if (out != nil) != mainChain[string(block.Hash)] {
+					t.Errorf("coinbase output of block %d in the UTXO set = %v, block on the main chain = %v", i, out != nil, mainChain[string(block.Hash)])
+				}
+			}
+		})
+	}
+}
+
+func TestAddBlockRejectsDescendantsOfInvalidBlocks(t *testing.T) {
+	bc := chaintest.NewChain(t, nil)
+	mainKey := wallet.HashPubKey(chaintest.NewWallet(t).PublicKey)
+	forkKey := wallet.HashPubKey(chaintest.NewWallet(t).PublicKey)
+	genesis := bc.Tip()
+	subsidy := bc.Params().BlockSubsidy(1)
+
+	chaintest.MineBlocks(t, bc, 1, mainKey)
+	tip := bc.Tip()
+
+	// 工作量与主链相同的侧链区块只验证区块头 铸币交易多领的奖励要到切换主链时才被发现
+	bad := chaintest.NewBlockOn(t, bc, genesis, chaintest.Coinbase(t, 1, forkKey, subsidy+1))
+	if status, err := bc.AddBlock(bad); err != nil || status != chain.BlockSideChain {
+		t.Fatalf("AddBlock(bad) = %s, %v, want %s", status, err, chain.BlockSideChain)
+	}
+
+	child := chaintest.NewBlockOn(t, bc, bad.Hash, chaintest.Coinbase(t, 2, forkKey, subsidy))
+	var blockErr *chain.BlockError
+	if _, err := bc.AddBlock(child); !errors.As(err, &blockErr) || !bytes.Equal(blockErr.Hash, bad.Hash) {
+		t.Fatalf("AddBlock(child) error = %v, want the reorganization to fail at %x", err, bad.Hash)
+	}
+	if !bytes.Equal(bc.Tip(), tip) {
+		t.Fatalf("tip moved to %x after a failed reorganization", bc.Tip())
+	}
+
+	// 无效区块的其他后代直接被拒绝 不再尝试切换主链
+	sibling := chaintest.NewBlockOn(t, bc, bad.Hash, chaintest.Coinbase(t, 2, forkKey, subsidy-1))
+	if _, err := bc.AddBlock(sibling); !errors.As(err, &blockErr) || !bytes.Equal(blockErr.Hash, sibling.Hash) {
+		t.Fatalf("AddBlock(sibling) error = %v, want the block itself to be rejected", err)
+	}
+	if _, err := bc.AddBlock(child); !errors.Is(err, chain.ErrInvalidBlock) {
+		t.Fatalf("AddBlock(child) again error = %v, want %v", err, chain.ErrInvalidBlock)
+	}
+	if !bytes.Equal(bc.Tip(), tip) {
+		t.Fatalf("tip moved to %x after rejecting descendants of an invalid block", bc.Tip())
+	}
+
+	// 主链不受影响
+	chaintest.MineBlocks(t, bc, 1, mainKey)
+	if height, err := bc.GetBestHeight(); err != nil || height != 2 {
+		t.Fatalf("GetBestHeight() = %d, %v, want 2", height, err)
+	}
+}
+
+func TestReorganizationUpdatesIndexes(t *testing.T) {
+	subsidy := chain.RegTestParams.Consensus.BlockSubsidy(1)
+	mainKey := wallet.HashPubKey(chaintest.NewWallet(t).PublicKey)
+	forkKey := wallet.HashPubKey(chaintest.NewWallet(t).PublicKey)
+
+	// 没有交易索引时FindTransaction遍历主链 两种方式的结果应当相同
+	for _, txindex := range []bool{false, true} {
+		name := "without txindex"
+		if txindex {
+			name = "with txindex"
+		}
+		t.Run(name, func(t *testing.T) {
+			src := chaintest.NewChain(t, nil)
+			bc := chaintest.NewChain(t, nil)
+			if txindex {
+				if err := bc.ReindexTransactions(); err != nil {
+					t.Fatal(err)
+				}
+			}
+			genesis := bc.Tip()
+
+			a1 := buildBlock(t, src, genesis, mainKey, subsidy)
+			b1 := buildBlock(t, src, genesis, forkKey, subsidy)
+			b2 := buildBlock(t, src, b1.Hash, forkKey, subsidy)
+			a2 := buildBlock(t, src, a1.Hash, mainKey, subsidy)
+			a3 := buildBlock(t, src, a2.Hash, mainKey, subsidy)
+
+			// 每一步之后主链上的区块 以及不在主链上的区块
+			steps := []struct {
+				add       *core.Block
+				mainChain []*core.Block
+				detached  []*core.Block
+			}{
+				{a1, []*core.Block{a1}, nil},
+				{b1, []*core.Block{a1}, []*core.Block{b1}},
+				{b2, []*core.Block{b1, b2}, []*core.Block{a1}},
+				{a2, []*core.Block{b1, b2}, []*core.Block{a1, a2}},
+				{a3, []*core.Block{a1, a2, a3}, []*core.Block{b1, b2}},
+			}
+			for i, step := range steps {
+				if _, err := bc.AddBlock(step.add); err != nil {
+					t.Fatalf("step %d: %v", i, err)
+				}
+
+				for _, block := range step.mainChain {
+					byHeight, err := bc.GetBlockByHeight(block.Height)
+					if err != nil || !bytes.Equal(byHeight.Hash, block.Hash) {
+						t.Fatalf("step %d: GetBlockByHeight(%d) = %v, want %x", i, block.Height, err, block.Hash)
+					}
+					coinbase := block.Transactions[0]
+					found, err := bc.FindTransaction(coinbase.ID)
+					if err != nil || !bytes.Equal(found.ID, coinbase.ID) {
+						t.Fatalf("step %d: FindTransaction(%x) = %v, want the coinbase of block %x", i, coinbase.ID, err, block.Hash)
+					}
+				}
+				tip := step.mainChain[len(step.mainChain)-1]
+				if _, err := bc.GetBlockByHeight(tip.Height + 1); !errors.Is(err, chain.ErrBlockNotFound) {
+					t.Fatalf("step %d: GetBlockByHeight(%d) above the tip error = %v, want %v", i, tip.Height+1, err, chain.ErrBlockNotFound)
+				}
+
+				for _, block := range step.detached {
+					coinbase := block.Transactions[0]
+					if _, err := bc.FindTransaction(coinbase.ID); !errors.Is(err, chain.ErrTransactionNotFound) {
+						t.Fatalf("step %d: FindTransaction(%x) of side chain block %x error = %v, want %v", i, coinbase.ID, block.Hash, err, chain.ErrTransactionNotFound)
+					}
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,279 @@
+package chain
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+
+	"blockchain/core"
+	"blockchain/storage"
+)
+
+// AddressTx 地址历史中的一笔交易
+type AddressTx struct {
+	Height int64
+	TxID   []byte
+	// 交易支付给该地址的金额
+	Received int
+	// 交易花费的属于该地址的输出的金额
+	Sent int
+}
+
+// 地址索引中属于同一地址的key的共同前缀: pubKeyHash的长度 | pubKeyHash
+// 长度在前 一个公钥hash不会成为另一个较长的公钥hash的前缀
+func addrKeyPrefix(pubKeyHash []byte) []byte {
+	prefix := make([]byte, binary.MaxVarintLen64, binary.MaxVarintLen64+len(pubKeyHash))
+	n := binary.PutUvarint(prefix, uint64(len(pubKeyHash)))
+
+	return append(prefix[:n], pubKeyHash...)
+}
+
+// 地址未花费输出的key: addrKeyPrefix | txid | vout
+func addrUTXOKey(pubKeyHash, txid []byte, vout int) []byte {
+	key := addrKeyPrefix(pubKeyHash)
+	key = append(key, txid...)
+
+	index := make([]byte, 4)
+	binary.BigEndian.PutUint32(index, uint32(vout))
+	return append(key, index...)
+}
+
+// 地址历史的key: addrKeyPrefix | height | 交易在区块中的下标 | txid
+// 游标按交易在链上的顺序遍历同一地址的交易
+func addrHistoryKey(pubKeyHash []byte, height int64, index int, txid []byte) []byte {
+	key := addrKeyPrefix(pubKeyHash)
+	key = append(key, heightKey(height)...)
+
+	position := make([]byte, 4)
+	binary.BigEndian.PutUint32(position, uint32(index))
+	key = append(key, position...)
+
+	return append(key, txid...)
+}
+
+func encodeAmount(value int) []byte {
+	data := make([]byte, 8)
+	binary.BigEndian.PutUint64(data, uint64(value))
+
+	return data
+}
+
+func decodeAmount(data []byte) int {
+	return int(binary.BigEndian.Uint64(data))
+}
+
+// 将区块加入地址索引 spent为区块按顺序花费的所有输出 即区块的撤销记录
+func indexAddresses(tx storage.Tx, block *core.Block, spent []spentOutput) error {
+	utxos, err := tx.CreateBucketIfNotExists(storage.AddrUTXOBucket)
+	if err != nil {
+		return err
+	}
+	history, err := tx.CreateBucketIfNotExists(storage.AddrHistoryBucket)
+	if err != nil {
+		return err
+	}
+
+	next := 0
+	for i, t := range block.Transactions {
+		received := make(map[string]int)
+		sent := make(map[string]int)
+
+		if !t.IsCoinbase() {
+			for range t.Vin {
+				if next >= len(spent) {
+					return fmt.Errorf("undo data of block %x does not match its inputs", block.Hash)
+				}
+				s := spent[next]
+				next++
+
+				if err := utxos.Delete(addrUTXOKey(s.Output.PubKeyHash, s.Txid, s.Vout)); err != nil {
+					return err
+				}
+				sent[string(s.Output.PubKeyHash)] += s.Output.Value
+			}
+		}
+
+		for j, out := range t.Vout {
+			if err := utxos.Put(addrUTXOKey(out.PubKeyHash, t.ID, j), encodeAmount(out.Value)); err != nil {
+				return err
+			}
+			received[string(out.PubKeyHash)] += out.Value
+		}
+
+		for _, pubKeyHash := range touchedAddresses(received, sent) {
+			value := append(encodeAmount(received[pubKeyHash]), encodeAmount(sent[pubKeyHash])...)
+			if err := history.Put(addrHistoryKey([]byte(pubKeyHash), block.Height, i, t.ID), value); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// 将区块从地址索引中移除 是indexAddresses的逆操作
+func unindexAddresses(tx storage.Tx, block *core.Block, spent []spentOutput) error {
+	utxos := tx.Bucket(storage.AddrUTXOBucket)
+	history := tx.Bucket(storage.AddrHistoryBucket)
+	if utxos == nil || history == nil {
+		return nil
+	}
+
+	next := len(spent)
+	for i := len(block.Transactions) - 1; i >= 0; i-- {
+		t := block.Transactions[i]
+		touched := make(map[string]int)
+
+		for j, out := range t.Vout {
+			if err := utxos.Delete(addrUTXOKey(out.PubKeyHash, t.ID, j)); err != nil {
+				return err
+			}
+			touched[string(out.PubKeyHash)]++
+		}
+
+		if !t.IsCoinbase() {
+			for range t.Vin {
+				next--
+				if next < 0 {
+					return fmt.Errorf("undo data of block %x does not match its inputs", block.Hash)
+				}
+				s := spent[next]
+
+				if err := utxos.Put(addrUTXOKey(s.Output.PubKeyHash, s.Txid, s.Vout), encodeAmount(s.Output.Value)); err != nil {
+					return err
+				}
+				touched[string(s.Output.PubKeyHash)]++
+			}
+		}
+
+		for pubKeyHash := range touched {
+			if err := history.Delete(addrHistoryKey([]byte(pubKeyHash), block.Height, i, t.ID)); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// 收款或付款的所有地址
+func touchedAddresses(received, sent map[string]int) []string {
+	var addresses []string
+	for pubKeyHash := range received {
+		addresses = append(addresses, pubKeyHash)
+	}
+	for pubKeyHash := range sent {
+		if _, ok := received[pubKeyHash]; !ok {
+			addresses = append(addresses, pubKeyHash)
+		}
+	}
+
+	return addresses
+}
+
+// 根据主链上的区块及其撤销记录重新建立整个地址索引
+func rebuildAddressIndex(tx storage.Tx) error {
+	for _, name := range []string{storage.AddrUTXOBucket, storage.AddrHistoryBucket} {
+		err := tx.DeleteBucket(name)
+		if err != nil && err != storage.ErrBucketNotFound {
+			return err
+		}
+	}
+
+	blocks := tx.Bucket(storage.BlocksBucket)
+	var chain []*core.Block
+	for hash := blocks.Get([]byte("l")); len(hash) != 0; {
+		block, err := getBlock(blocks, hash)
+		if err != nil {
+			return err
+		}
+		chain = append(chain, block)
+		hash = block.PrevBlockHash
+	}
+
+	// 从创世块开始依次加入索引
+	for i := len(chain) - 1; i >= 0; i-- {
+		block := chain[i]
+
+		undo, err := getBlockUndo(tx, block.Hash)
+		if err != nil {
+			return err
+		}
+		if undo == nil {
+			return fmt.Errorf("block %x has no undo data, the address index cannot be built", block.Hash)
+		}
+
+		if err := indexAddresses(tx, block, undo.Spent); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// 遍历地址索引中属于pubKeyHash的所有未花费输出
+// 输出以UTXO集中的记录为准 UTXO集中不存在或不属于pubKeyHash的索引项被跳过
+func forEachAddressUTXO(tx storage.Tx, pubKeyHash []byte, fn func(txid []byte, vout int, entry *UTXOEntry) error) error {
+	b := tx.Bucket(storage.AddrUTXOBucket)
+	if b == nil {
+		return nil
+	}
+	chainstate := tx.Bucket(storage.ChainstateBucket)
+
+	prefix := addrKeyPrefix(pubKeyHash)
+	c := b.Cursor()
+	for k, _ := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, _ = c.Next() {
+		outpoint := k[len(prefix):]
+		if len(outpoint) < 4 {
+			return fmt.Errorf("malformed address index key %x", k)
+		}
+		txid := append([]byte{}, outpoint[:len(outpoint)-4]...)
+		vout := int(binary.BigEndian.Uint32(outpoint[len(outpoint)-4:]))
+
+		entry, err := fetchUTXOEntry(chainstate, txid, vout)
+		if err != nil {
+			return err
+		}
+		if entry == nil || !entry.Output.IsLockedWithKey(pubKeyHash) {
+			continue
+		}
+
+		if err := fn(txid, vout, entry); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// AddressHistory 按高度从小到大返回主链上与pubKeyHash相关的所有交易
+func (bc *Blockchain) AddressHistory(pubKeyHash []byte) ([]AddressTx, error) {
+	var txs []AddressTx
+
+	err := bc.db.View(func(tx storage.Tx) error {
+		b := tx.Bucket(storage.AddrHistoryBucket)
+		if b == nil {
+			return nil
+		}
+
+		prefix := addrKeyPrefix(pubKeyHash)
+		c := b.Cursor()
+		for k, v := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, v = c.Next() {
+			rest := k[len(prefix):]
+			if len(rest) < 12 || len(v) != 16 {
+				return fmt.Errorf("malformed address history entry %x", k)
+			}
+
+			txs = append(txs, AddressTx{
+				Height:   int64(binary.BigEndian.Uint64(rest[:8])),
+				TxID:     append([]byte{}, rest[12:]...),
+				Received: decodeAmount(v[:8]),
+				Sent:     decodeAmount(v[8:]),
+			})
+		}
+
+		return nil
+	})
+
+	return txs, err
+}
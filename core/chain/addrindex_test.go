@@ -0,0 +1,59 @@
+package chain_test
+
+import (
+	"bytes"
+	"testing"
+
+	"blockchain/core/chain"
+	"blockchain/internal/chaintest"
+	"blockchain/wallet"
+)
+
+func TestAddressIndexPrefix(t *testing.T) {
+	bc := chaintest.NewChain(t, nil)
+	victim := wallet.HashPubKey(chaintest.NewWallet(t).PublicKey)
+	// 以victim的公钥hash开头的更长的公钥hash
+	attacker := append(append([]byte{}, victim...), 0x01, 0x02)
+
+	// 挖出足够的区块使victim的铸币交易成熟
+	chaintest.MineBlocks(t, bc, 1, victim)
+	chaintest.MineBlocks(t, bc, int(bc.Params().CoinbaseMaturity), attacker)
+	utxo := chain.UTXOset{Blockchain: bc}
+
+	outs, err := utxo.FindUTXO(victim)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(outs) != 1 {
+		t.Fatalf("FindUTXO(victim) returned %d outputs, want 1", len(outs))
+	}
+	for _, out := range outs {
+		if !bytes.Equal(out.PubKeyHash, victim) {
+			t.Fatalf("FindUTXO(victim) returned an output locked with %x", out.PubKeyHash)
+		}
+	}
+
+	spendable, err := utxo.SpendableOutputs(victim)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(spendable) != 1 || !bytes.Equal(spendable[0].Output.PubKeyHash, victim) {
+		t.Fatalf("SpendableOutputs(victim) = %+v, want only the victim's coinbase", spendable)
+	}
+
+	history, err := bc.AddressHistory(victim)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(history) != 1 || history[0].Height != 1 {
+		t.Fatalf("AddressHistory(victim) = %+v, want the coinbase at height 1", history)
+	}
+
+	outs, err = utxo.FindUTXO(attacker)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := int(bc.Params().CoinbaseMaturity); len(outs) != want {
+		t.Fatalf("FindUTXO(attacker) returned %d outputs, want %d", len(outs), want)
+	}
+}
@@ -0,0 +1,583 @@
+// Package chain 负责区块链的持久化、遍历、挖矿以及UTXO集的维护
+package chain
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sync"
+
+	"blockchain/core"
+	"blockchain/pow"
+	"blockchain/storage"
+)
+
+// Blockchain 保存区块链的最新区块hash及其存储
+type Blockchain struct {
+	// mu 保护tip与孤块池 同一时刻只有一个区块被AddBlock处理
+	mu          sync.Mutex
+	tip         []byte
+	db          storage.Store
+	chainParams *ChainParams
+	params      *ConsensusParams
+
+	// 父区块未知的区块 以hash与父区块hash为索引
+	orphans       map[string]*core.Block
+	orphansByPrev map[string][]*core.Block
+}
+
+// NewBlockChain 打开dbFile中已有的BoltDB区块链 若数据库不存在则返回ErrChainNotFound
+// 数据库的创世块不属于params对应的网络时返回ErrGenesisMismatch 不是由当前版本创建时返回ErrUnsupportedChain
+func NewBlockChain(dbFile string, params *ChainParams) (*Blockchain, error) {
+	if storage.BoltExists(dbFile) == false {
+		return nil, ErrChainNotFound
+	}
+
+	db, err := storage.OpenBolt(dbFile)
+	if err != nil {
+		return nil, err
+	}
+
+	bc, err := OpenBlockChain(db, params)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return bc, nil
+}
+
+// OpenBlockChain 从任意存储中打开已有的区块链 存储中没有区块时返回ErrChainNotFound
+// 返回的Blockchain关闭时会一并关闭db 共识参数不合法时返回ErrInvalidParams
+func OpenBlockChain(db storage.Store, params *ChainParams) (*Blockchain, error) {
+	if err := params.Consensus.Validate(); err != nil {
+		return nil, err
+	}
+
+	var tip []byte
+
+	// 只读地检查数据库 创世块不一致或格式不受支持时不修改数据库
+	err := db.View(func(tx storage.Tx) error {
+		b := tx.Bucket(storage.BlocksBucket)
+		if b == nil {
+			return ErrChainNotFound
+		}
+		tip = append([]byte{}, b.Get([]byte("l"))...)
+
+		heights := tx.Bucket(storage.HeightIndexBucket)
+		if heights == nil || tx.Bucket(storage.BlockIndexBucket) == nil {
+			return fmt.Errorf("%w: the database has no block index", ErrUnsupportedChain)
+		}
+
+		genesis := heights.Get(heightKey(0))
+		if !params.isGenesis(genesis) {
+			return fmt.Errorf("%w: genesis block %x is not the %s genesis block %s", ErrGenesisMismatch, genesis, params.Name, params.GenesisHash)
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	bc := Blockchain{tip: tip, db: db, chainParams: params, params: &params.Consensus}
+	return &bc, nil
+}
+
+// Close 关闭区块链对应的数据库
+func (bc *Blockchain) Close() error {
+	return bc.db.Close()
+}
+
+// Store 返回区块链使用的存储
+func (bc *Blockchain) Store() storage.Store {
+	return bc.db
+}
+
+// FindUTXO 遍历整条区块链 找到所有未花费的输出 以交易ID为索引
+func (bc *Blockchain) FindUTXO() (map[string]core.TXOutputs, error) {
+	UTXO := make(map[string]core.TXOutputs)
+	spentTXOs := make(map[string][]int)
+	bci := bc.Iterator()
+
+	for {
+		// 遍历整条区块链
+		block, err := bci.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		// 遍历区块链的每一条交易
+		for _, tx := range block.Transactions {
+			txID := hex.EncodeToString(tx.ID)
+			// 由于整个是从后向前遍历的 所以扫描的顺序是先判断输出 再判断输入
+			// 并且根据UTXO的特性，一个transaction中不会出现指向同一个地址的多个输出,故能够通过以下方式找到
+		outputs:
+			// 遍历交易的输出,检查输出有没有作为另一笔交易的输入即可
+			// 遍历区块的输出
+			for outIdx, out := range tx.Vout {
+				if spentTXOs[txID] != nil {
+					// 该交易已存在输入集合中 则证明其以用于交易
+					for _, spentOut := range spentTXOs[txID] {
+						// 匹配到一个使用过的输出则跳过
+						if spentOut == outIdx {
+							continue outputs
+						}
+					}
+				}
+				outs := UTXO[txID]
+				if outs.Outputs == nil {
+					outs.Outputs = make(map[int]core.TXOutput)
+					outs.Coinbase = tx.IsCoinbase()
+					outs.Height = block.Height
+				}
+				// 保留输出在交易中的原始索引
+				outs.Outputs[outIdx] = out
+				// 若匹配到一个未使用过的输出 则记录下当前交易 证明当前交易中存在未使用的输出
+				UTXO[txID] = outs
+			}
+
+			if tx.IsCoinbase() == false {
+				// 将所有交易的输入TranscationID,vout放入
+				for _, in := range tx.Vin {
+					// 遍历所有input
+					inTxID := hex.EncodeToString(in.Txid)
+					// 将当前用户input的Vout存储起来
+					// 存储input的Transcation ID及Vout
+					// 存放在spentTXOs集合中的输出是一定花费过的
+					spentTXOs[inTxID] = append(spentTXOs[inTxID], in.Vout)
+
+				}
+			}
+		}
+		// 区块链到头 则终止循环
+		if len(block.PrevBlockHash) == 0 {
+			break
+		}
+	}
+	return UTXO, nil
+}
+
+// 完成区块的工作量证明
+func solveBlock(block *core.Block) {
+	proof := pow.NewProofOfWork(block)
+
+	nonce, hash := proof.Run()
+
+	block.Nonce = nonce
+	block.Hash = hash
+}
+
+// CreateBlockChain 创建区块链,写入params对应网络的创世块,区块链的持久化
+// 区块链存储在BoltDB数据库dbFile中 若数据库已经存在则返回ErrChainExists
+func CreateBlockChain(dbFile string, params *ChainParams) (*Blockchain, error) {
+	if storage.BoltExists(dbFile) {
+		return nil, ErrChainExists
+	}
+
+	db, err := storage.OpenBolt(dbFile)
+	if err != nil {
+		return nil, err
+	}
+
+	bc, err := InitBlockChain(db, params)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return bc, nil
+}
+
+// InitBlockChain 在任意存储中创建区块链 存储中已经存在区块时返回ErrChainExists
+// 按参数构造的创世块与GenesisHash不一致时返回ErrGenesisMismatch 共识参数不合法时返回ErrInvalidParams
+func InitBlockChain(db storage.Store, params *ChainParams) (*Blockchain, error) {
+	genesis, err := params.GenesisBlock()
+	if err != nil {
+		return nil, err
+	}
+	if !params.isGenesis(genesis.Hash) {
+		return nil, fmt.Errorf("%w: %s parameters produce genesis block %x instead of %s", ErrGenesisMismatch, params.Name, genesis.Hash, params.GenesisHash)
+	}
+	if err := ValidateBlock(genesis, &Blockchain{db: db, params: &params.Consensus}); err != nil {
+		return nil, err
+	}
+
+	err = db.Update(func(tx storage.Tx) error {
+		// 首先创建bucket
+		b, err := tx.CreateBucket(storage.BlocksBucket)
+		if err == storage.ErrBucketExists {
+			return ErrChainExists
+		}
+		if err != nil {
+			return err
+		}
+
+		err = b.Put(genesis.Hash, genesis.Serialize())
+		if err != nil {
+			return err
+		}
+
+		// 将标记最后一个区块链的标记放入桶中
+		err = b.Put([]byte("l"), genesis.Hash)
+		if err != nil {
+			return err
+		}
+
+		err = putBlockMeta(tx, genesis.Hash, newBlockMeta(genesis, nil))
+		if err != nil {
+			return err
+		}
+		err = putMainChainBlock(tx, genesis)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.CreateBucketIfNotExists(storage.ChainstateBucket)
+		if err != nil {
+			return err
+		}
+
+		return connectUTXO(tx, genesis)
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	bc := Blockchain{tip: genesis.Hash, db: db, chainParams: params, params: &params.Consensus}
+	return &bc, nil
+}
+
+// BlockchainIntertor 创建迭代器 用于遍历区块链的数据
+type BlockchainIntertor struct {
+	currentHash []byte
+	db          storage.Store
+}
+
+// Iterator 根据传入的区块链对象 构建区块链的迭代器
+func (bc *Blockchain) Iterator() *BlockchainIntertor {
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+
+	bci := &BlockchainIntertor{bc.tip, bc.db}
+
+	return bci
+}
+
+// Next 通过当前区块的数据 实现区块链的反向遍历
+func (i *BlockchainIntertor) Next() (*core.Block, error) {
+	var block *core.Block
+
+	err := i.db.View(func(tx storage.Tx) error {
+		var err error
+		block, err = getBlock(tx.Bucket(storage.BlocksBucket), i.currentHash)
+
+		return err
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	// 将区块链当前区块的前一个区块的hash传入
+	i.currentHash = block.PrevBlockHash
+
+	return block, nil
+}
+
+// GetBlockByHash 返回hash对应的区块 区块可以不在主链上 不存在时返回ErrBlockNotFound
+func (bc *Blockchain) GetBlockByHash(hash []byte) (*core.Block, error) {
+	var block *core.Block
+
+	err := bc.db.View(func(tx storage.Tx) error {
+		var err error
+		block, err = getBlock(tx.Bucket(storage.BlocksBucket), hash)
+
+		return err
+	})
+
+	return block, err
+}
+
+// GetBlockByHeight 返回主链上高度为height的区块 不存在时返回ErrBlockNotFound
+func (bc *Blockchain) GetBlockByHeight(height int64) (*core.Block, error) {
+	var block *core.Block
+
+	err := bc.db.View(func(tx storage.Tx) error {
+		hash := tx.Bucket(storage.HeightIndexBucket).Get(heightKey(height))
+		if hash == nil {
+			return fmt.Errorf("%w: height %d", ErrBlockNotFound, height)
+		}
+
+		var err error
+		block, err = getBlock(tx.Bucket(storage.BlocksBucket), hash)
+		return err
+	})
+
+	return block, err
+}
+
+// GetBestHeight 返回主链最后一个区块的高度
+func (bc *Blockchain) GetBestHeight() (int64, error) {
+	var height int64
+
+	err := bc.db.View(func(tx storage.Tx) error {
+		tip := tx.Bucket(storage.BlocksBucket).Get([]byte("l"))
+
+		var err error
+		height, err = txState{tx, bc.params}.BlockHeight(tip)
+		return err
+	})
+
+	return height, err
+}
+
+// MineBlock 实现交易区块的挖矿 挖出的区块通过AddBlock加入区块链并更新UTXO集
+// 区块在工作量证明前后都会按照共识规则进行验证 不合法时返回*BlockError
+func (bc *Blockchain) MineBlock(transcations []*core.Transaction) (*core.Block, error) {
+	newBlock, err := bc.NewBlockTemplate(transcations)
+	if err != nil {
+		return nil, err
+	}
+
+	solveBlock(newBlock)
+
+	status, err := bc.AddBlock(newBlock)
+	if err != nil {
+		return nil, err
+	}
+	// 挖矿期间主链被其他区块延长时 挖出的区块只能留在侧链上
+	if status != BlockMainChain {
+		return nil, fmt.Errorf("mined block %x ended up on the %s", newBlock.Hash, status)
+	}
+
+	return newBlock, nil
+}
+
+// NewBlockTemplate 构造一个接在当前主链末端 包含transcations的区块
+// 交易已经通过验证 区块尚未进行工作量证明
+func (bc *Blockchain) NewBlockTemplate(transcations []*core.Transaction) (*core.Block, error) {
+	var lastHash []byte
+	var lastHeight int64
+
+	// 查找当前区块链中最后一个块的hash与高度
+	err := bc.db.View(func(tx storage.Tx) error {
+		b := tx.Bucket(storage.BlocksBucket)
+		lastHash = append([]byte{}, b.Get([]byte("l"))...)
+
+		var err error
+		lastHeight, err = txState{tx, bc.params}.BlockHeight(lastHash)
+		return err
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	bits, err := bc.RequiredBits(lastHash)
+	if err != nil {
+		return nil, err
+	}
+
+	newBlock := core.NewBlock(transcations, lastHash, lastHeight+1, bits)
+
+	// 时间戳必须大于最近区块时间戳的中位数 出块过快时需要将其向后调整
+	medianTime, err := bc.MedianTimePast(lastHash)
+	if err != nil {
+		return nil, err
+	}
+	if newBlock.Timestamp <= medianTime {
+		newBlock.Timestamp = medianTime + 1
+	}
+
+	// 在进行耗时的工作量证明之前先验证交易
+	if err := checkBlockTransactions(newBlock, bc); err != nil {
+		return nil, err
+	}
+
+	return newBlock, nil
+}
+
+// Tip 返回当前主链最后一个区块的hash
+func (bc *Blockchain) Tip() []byte {
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+
+	return append([]byte{}, bc.tip...)
+}
+
+// FindUnspentTransactions 查找包含未使用输出的交易
+func (bc *Blockchain) FindUnspentTransactions(pubKeyHash []byte) ([]core.Transaction, error) {
+	var unspetTXs []core.Transaction
+	// 存储的是交易ID-
+	spentTXOs := make(map[string][]int)
+	bci := bc.Iterator()
+
+	for {
+		// 遍历整条区块链
+		block, err := bci.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		// 遍历区块链的每一条交易
+		for _, tx := range block.Transactions {
+			txID := hex.EncodeToString(tx.ID)
+			// 由于整个是从后向前遍历的 所以扫描的顺序是先判断输出 再判断输入
+			// 并且根据UTXO的特性，一个transaction中不会出现指向同一个地址的多个输出,故能够通过以下方式找到
+		outputs:
+			// 遍历交易的输出,检查输出有没有作为另一笔交易的输入即可
+			// 遍历区块的输出
+			for outIdx, out := range tx.Vout {
+				if spentTXOs[txID] != nil {
+					// 该交易已存在输入集合中 则证明其以用于交易
+					for _, spentOut := range spentTXOs[txID] {
+						// 匹配到一个使用过的输出则跳过
+						if spentOut == outIdx {
+							continue outputs
+						}
+					}
+				}
+
+				// 若匹配到一个未使用过的输出 则记录下当前交易 证明当前交易中存在未使用的输出
+				if out.IsLockedWithKey(pubKeyHash) {
+					unspetTXs = append(unspetTXs, *tx)
+				}
+			}
+
+			if tx.IsCoinbase() == false {
+				// 将所有交易的输入TranscationID,vout放入
+				for _, in := range tx.Vin {
+					// 遍历所有input
+					if in.UseKey(pubKeyHash) {
+						// 将Txid编码
+						inTxID := hex.EncodeToString(in.Txid)
+						// 将当前用户input的Vout存储起来
+						// 存储input的Transcation ID及Vout
+						// 存放在spentTXOs集合中的输出是一定花费过的
+						spentTXOs[inTxID] = append(spentTXOs[inTxID], in.Vout)
+					}
+				}
+			}
+		}
+		// 区块链到头 则终止循环
+		if len(block.PrevBlockHash) == 0 {
+			break
+		}
+	}
+	return unspetTXs, nil
+}
+
+// FindSpendableOutputs 先定义验证当前交易是否合法的函数
+func (bc *Blockchain) FindSpendableOutputs(pubKeyHash []byte, amount int) (int, map[string][]int, error) {
+	unspentOutputs := make(map[string][]int)
+	// 得到包含未使用Outputs的交易
+	unspentTXs, err := bc.FindUnspentTransactions(pubKeyHash)
+	if err != nil {
+		return 0, nil, err
+	}
+	accumulated := 0
+Work:
+	for _, tx := range unspentTXs {
+		txID := hex.EncodeToString(tx.ID)
+
+		for outIdx, out := range tx.Vout {
+			if out.IsLockedWithKey(pubKeyHash) && accumulated < amount {
+				accumulated += out.Value
+				unspentOutputs[txID] = append(unspentOutputs[txID], outIdx)
+
+				if accumulated >= amount {
+					break Work
+				}
+			}
+		}
+	}
+	return accumulated, unspentOutputs, nil
+}
+
+// FindTransaction 找到对应ID的交易 未找到时返回ErrTransactionNotFound
+// 启用了交易索引时直接通过索引查找 否则从链尾开始遍历整条主链
+func (bc *Blockchain) FindTransaction(ID []byte) (core.Transaction, error) {
+	var indexed *core.Transaction
+	found := false
+
+	err := bc.db.View(func(tx storage.Tx) error {
+		var err error
+		indexed, found, err = lookupTransaction(tx, ID)
+
+		return err
+	})
+	if err != nil {
+		return core.Transaction{}, err
+	}
+	if found {
+		return *indexed, nil
+	}
+
+	bci := bc.Iterator()
+
+	for {
+		block, err := bci.Next()
+		if err != nil {
+			return core.Transaction{}, err
+		}
+
+		for _, tx := range block.Transactions {
+			if bytes.Compare(tx.ID, ID) == 0 {
+				return *tx, nil
+			}
+		}
+
+		if len(block.PrevBlockHash) == 0 {
+			break
+		}
+	}
+
+	return core.Transaction{}, ErrTransactionNotFound
+}
+
+// 找到交易所有输入引用的交易 任意一个不存在时返回core.ErrUnknownInput
+func (bc *Blockchain) findPrevTransactions(tx *core.Transaction) (map[string]core.Transaction, error) {
+	prevTXs := make(map[string]core.Transaction)
+
+	for _, vin := range tx.Vin {
+		prevTX, err := bc.FindTransaction(vin.Txid)
+		if errors.Is(err, ErrTransactionNotFound) {
+			return nil, fmt.Errorf("%w: %x", core.ErrUnknownInput, vin.Txid)
+		}
+		if err != nil {
+			return nil, err
+		}
+		prevTXs[hex.EncodeToString(prevTX.ID)] = prevTX
+	}
+
+	return prevTXs, nil
+}
+
+// SignTransaction 对交易进行签名的方法
+func (bc *Blockchain) SignTransaction(tx *core.Transaction, privKey ecdsa.PrivateKey) error {
+	prevTXs, err := bc.findPrevTransactions(tx)
+	if err != nil {
+		return err
+	}
+
+	return tx.Sign(privKey, prevTXs)
+}
+
+// VerifyTransaction 对交易进行验证 签名不合法时返回ErrInvalidSignature 输入不存在时返回core.ErrUnknownInput
+func (bc *Blockchain) VerifyTransaction(tx *core.Transaction) error {
+	if tx.IsCoinbase() {
+		return nil
+	}
+
+	prevTXs, err := bc.findPrevTransactions(tx)
+	if err != nil {
+		return err
+	}
+
+	return tx.Verify(prevTXs)
+}
@@ -0,0 +1,129 @@
+package chain_test
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"blockchain/core/chain"
+	"blockchain/internal/chaintest"
+	"blockchain/storage"
+)
+
+// 存储中names对应的bucket的内容 用于比较存储是否被修改 names为空时包含所有bucket
+func dumpStore(t *testing.T, db storage.Store, names ...string) map[string]map[string]string {
+	t.Helper()
+
+	if len(names) == 0 {
+		names = []string{
+			storage.BlocksBucket, storage.ChainstateBucket, storage.BlockIndexBucket, storage.UndoBucket,
+			storage.HeightIndexBucket, storage.TxIndexBucket, storage.AddrUTXOBucket, storage.AddrHistoryBucket,
+			storage.MempoolBucket,
+		}
+	}
+	dump := make(map[string]map[string]string)
+	err := db.View(func(tx storage.Tx) error {
+		for _, name := range names {
+			b := tx.Bucket(name)
+			if b == nil {
+				continue
+			}
+			dump[name] = make(map[string]string)
+			err := b.ForEach(func(k, v []byte) error {
+				dump[name][string(k)] = string(v)
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return dump
+}
+
+func TestOpenBlockChainDoesNotWrite(t *testing.T) {
+	// 其他网络的区块链
+	regtest := chaintest.NewChain(t, nil).Store()
+
+	// 只有区块而没有索引的数据库 由不受支持的版本创建
+	unindexed := storage.NewMemory()
+	err := unindexed.Update(func(tx storage.Tx) error {
+		b, err := tx.CreateBucket(storage.BlocksBucket)
+		if err != nil {
+			return err
+		}
+		return b.Put([]byte("l"), []byte("tip"))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		db   storage.Store
+		want error
+	}{
+		{"genesis of another network", regtest, chain.ErrGenesisMismatch},
+		{"database without block index", unindexed, chain.ErrUnsupportedChain},
+		{"empty database", storage.NewMemory(), chain.ErrChainNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			before := dumpStore(t, tt.db)
+			if _, err := chain.OpenBlockChain(tt.db, &chain.MainNetParams); !errors.Is(err, tt.want) {
+				t.Fatalf("OpenBlockChain() error = %v, want %v", err, tt.want)
+			}
+			if after := dumpStore(t, tt.db); !reflect.DeepEqual(after, before) {
+				t.Fatal("OpenBlockChain() modified the database")
+			}
+		})
+	}
+
+	if _, err := chain.OpenBlockChain(regtest, &chain.RegTestParams); err != nil {
+		t.Fatalf("OpenBlockChain() of a regtest chain with the regtest parameters error = %v", err)
+	}
+}
+
+func TestInvalidConsensusParams(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(p *chain.ConsensusParams)
+	}{
+		{"zero halving interval", func(p *chain.ConsensusParams) { p.SubsidyHalvingInterval = 0 }},
+		{"negative halving interval", func(p *chain.ConsensusParams) { p.SubsidyHalvingInterval = -1 }},
+		{"negative subsidy", func(p *chain.ConsensusParams) { p.InitialSubsidy = -1 }},
+		{"negative coinbase maturity", func(p *chain.ConsensusParams) { p.CoinbaseMaturity = -1 }},
+		{"retarget interval of one block", func(p *chain.ConsensusParams) { p.NoRetargeting, p.RetargetInterval = false, 1 }},
+		{"zero target block time", func(p *chain.ConsensusParams) { p.NoRetargeting, p.TargetBlockTime = false, 0 }},
+	}
+
+	db := chaintest.NewChain(t, nil).Store()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			params := chain.RegTestParams
+			tt.modify(&params.Consensus)
+
+			if err := params.Consensus.Validate(); !errors.Is(err, chain.ErrInvalidParams) {
+				t.Fatalf("Validate() error = %v, want %v", err, chain.ErrInvalidParams)
+			}
+			if _, err := chain.InitBlockChain(storage.NewMemory(), &params); !errors.Is(err, chain.ErrInvalidParams) {
+				t.Fatalf("InitBlockChain() error = %v, want %v", err, chain.ErrInvalidParams)
+			}
+			if _, err := chain.OpenBlockChain(db, &params); !errors.Is(err, chain.ErrInvalidParams) {
+				t.Fatalf("OpenBlockChain() error = %v, want %v", err, chain.ErrInvalidParams)
+			}
+		})
+	}
+
+	for _, params := range []*chain.ChainParams{&chain.MainNetParams, &chain.TestNetParams, &chain.RegTestParams} {
+		if err := params.Consensus.Validate(); err != nil {
+			t.Errorf("Validate() of the %s parameters error = %v", params.Name, err)
+		}
+	}
+}
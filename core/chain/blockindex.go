@@ -0,0 +1,127 @@
+package chain
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"fmt"
+	"math/big"
+
+	"blockchain/core"
+	"blockchain/pow"
+	"blockchain/storage"
+)
+
+// 区块索引中记录的区块信息 主链与侧链上的区块都会被记录
+type blockMeta struct {
+	// 从创世块到该区块(包含)的累计工作量
+	ChainWork []byte
+	// 区块或其祖先在连接时违反了共识规则 以其为祖先的区块都会被拒绝
+	Invalid bool
+}
+
+func (m *blockMeta) work() *big.Int {
+	return new(big.Int).SetBytes(m.ChainWork)
+}
+
+func (m *blockMeta) serialize() ([]byte, error) {
+	var result bytes.Buffer
+
+	if err := gob.NewEncoder(&result).Encode(m); err != nil {
+		return nil, err
+	}
+
+	return result.Bytes(), nil
+}
+
+// 根据父区块的信息计算区块的累计工作量 parent为nil时表示创世块
+func newBlockMeta(block *core.Block, parent *blockMeta) *blockMeta {
+	work := pow.CalcWork(block.Bits)
+	if parent != nil {
+		work.Add(work, parent.work())
+	}
+
+	return &blockMeta{ChainWork: work.Bytes()}
+}
+
+// ChainWork 返回从创世块到hash对应区块(包含)的累计工作量 区块可以不在主链上
+func (bc *Blockchain) ChainWork(hash []byte) (*big.Int, error) {
+	var work *big.Int
+
+	err := bc.db.View(func(tx storage.Tx) error {
+		meta, err := getBlockMeta(tx, hash)
+		if err != nil {
+			return err
+		}
+		if meta == nil {
+			return fmt.Errorf("%w: %x", ErrBlockNotFound, hash)
+		}
+
+		work = meta.work()
+		return nil
+	})
+
+	return work, err
+}
+
+// 从区块索引中取出hash对应的区块信息 不存在时返回nil
+func getBlockMeta(tx storage.Tx, hash []byte) (*blockMeta, error) {
+	b := tx.Bucket(storage.BlockIndexBucket)
+	if b == nil || len(hash) == 0 {
+		return nil, nil
+	}
+
+	data := b.Get(hash)
+	if data == nil {
+		return nil, nil
+	}
+
+	var meta blockMeta
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&meta); err != nil {
+		return nil, err
+	}
+
+	return &meta, nil
+}
+
+func putBlockMeta(tx storage.Tx, hash []byte, meta *blockMeta) error {
+	b, err := tx.CreateBucketIfNotExists(storage.BlockIndexBucket)
+	if err != nil {
+		return err
+	}
+
+	data, err := meta.serialize()
+	if err != nil {
+		return err
+	}
+
+	return b.Put(hash, data)
+}
+
+// 高度索引的key 使用大端序使游标按高度从小到大遍历
+func heightKey(height int64) []byte {
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, uint64(height))
+
+	return key
+}
+
+// 将区块记录为主链上对应高度的区块
+func putMainChainBlock(tx storage.Tx, block *core.Block) error {
+	b, err := tx.CreateBucketIfNotExists(storage.HeightIndexBucket)
+	if err != nil {
+		return err
+	}
+
+	return b.Put(heightKey(block.Height), block.Hash)
+}
+
+// 区块离开主链时移除其高度索引
+func deleteMainChainBlock(tx storage.Tx, block *core.Block) error {
+	b := tx.Bucket(storage.HeightIndexBucket)
+	if b == nil {
+		return nil
+	}
+
+	return b.Delete(heightKey(block.Height))
+}
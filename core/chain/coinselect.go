@@ -0,0 +1,241 @@
+package chain
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+	"time"
+)
+
+const (
+	// 一个签名后的输入序列化后大约占用的字节数 用于按费率估算增加输入的手续费
+	estimatedInputSize = 170
+	// 一个输出序列化后大约占用的字节数
+	estimatedOutputSize = 30
+	// 分支定界搜索最多尝试的次数
+	bnbMaxTries = 100000
+)
+
+// SelectionCosts 选币时需要考虑的手续费
+type SelectionCosts struct {
+	// Input 每增加一个输入需要额外支付的手续费
+	Input int
+	// Change 增加一个找零输出并在将来花费它需要支付的手续费
+	// 所选输出超出目标金额的部分不多于该值时不值得找零 直接作为手续费
+	Change int
+}
+
+// FeeRateCosts 返回手续费率为每字节feeRate时的选币成本
+func FeeRateCosts(feeRate int) SelectionCosts {
+	return SelectionCosts{
+		Input:  feeRate * estimatedInputSize,
+		Change: feeRate * (estimatedOutputSize + estimatedInputSize),
+	}
+}
+
+// CoinSelector 从候选的未花费输出中选出用于支付的输入
+type CoinSelector interface {
+	// Select 从candidates中选出一组输出 其金额之和不小于target加上这些输入本身的手续费
+	// 金额不高于单个输入手续费的输出不会被选中 无法满足时返回ErrInsufficientFunds
+	Select(candidates []SpendableOutput, target int, costs SelectionCosts) ([]SpendableOutput, error)
+}
+
+// NewCoinSelector 根据名称返回选币策略: largest, smallest, bnb, random
+func NewCoinSelector(name string) (CoinSelector, error) {
+	switch name {
+	case "largest":
+		return LargestFirst{}, nil
+	case "smallest":
+		return SmallestFirst{}, nil
+	case "bnb":
+		return BranchAndBound{}, nil
+	case "random":
+		return RandomImprove{}, nil
+	}
+
+	return nil, fmt.Errorf("unknown coin selection strategy %q", name)
+}
+
+// DefaultCoinSelector UTXOset没有指定Selector时使用的选币策略
+var DefaultCoinSelector CoinSelector = BranchAndBound{}
+
+// 候选输出及扣除其作为输入的手续费后的有效金额
+type candidate struct {
+	output SpendableOutput
+	value  int
+}
+
+// 计算候选输出的有效金额 并丢弃有效金额不为正的输出
+func effectiveCandidates(outputs []SpendableOutput, costs SelectionCosts) []candidate {
+	var pool []candidate
+	for _, out := range outputs {
+		if value := out.Output.Value - costs.Input; value > 0 {
+			pool = append(pool, candidate{out, value})
+		}
+	}
+
+	return pool
+}
+
+// 按顺序选取候选输出直到有效金额之和达到target
+func accumulate(pool []candidate, target int) ([]SpendableOutput, error) {
+	var selected []SpendableOutput
+	sum := 0
+	for _, c := range pool {
+		if sum >= target {
+			break
+		}
+		selected = append(selected, c.output)
+		sum += c.value
+	}
+
+	if sum < target {
+		return nil, fmt.Errorf("%w: spendable %d, needs %d", ErrInsufficientFunds, sum, target)
+	}
+
+	return selected, nil
+}
+
+// LargestFirst 优先选择金额最大的输出 使用的输入最少
+type LargestFirst struct{}
+
+// Select 实现CoinSelector
+func (LargestFirst) Select(candidates []SpendableOutput, target int, costs SelectionCosts) ([]SpendableOutput, error) {
+	pool := effectiveCandidates(candidates, costs)
+	sort.SliceStable(pool, func(i, j int) bool { return pool[i].value > pool[j].value })
+
+	return accumulate(pool, target)
+}
+
+// SmallestFirst 优先选择金额最小的输出 可以顺便合并零散的小额输出
+type SmallestFirst struct{}
+
+// Select 实现CoinSelector
+func (SmallestFirst) Select(candidates []SpendableOutput, target int, costs SelectionCosts) ([]SpendableOutput, error) {
+	pool := effectiveCandidates(candidates, costs)
+	sort.SliceStable(pool, func(i, j int) bool { return pool[i].value < pool[j].value })
+
+	return accumulate(pool, target)
+}
+
+// BranchAndBound 通过深度优先搜索寻找金额恰好满足目标的输出组合
+// 超出目标的部分不多于找零成本时不需要找零 在所有这样的组合中选择超出最少的一个
+// 找不到时使用Fallback 为nil时使用LargestFirst
+type BranchAndBound struct {
+	Fallback CoinSelector
+}
+
+// Select 实现CoinSelector
+func (s BranchAndBound) Select(candidates []SpendableOutput, target int, costs SelectionCosts) ([]SpendableOutput, error) {
+	pool := effectiveCandidates(candidates, costs)
+	// 从大到小搜索可以更早地超出上界并剪枝
+	sort.SliceStable(pool, func(i, j int) bool { return pool[i].value > pool[j].value })
+
+	available := 0
+	for _, c := range pool {
+		available += c.value
+	}
+	if available < target {
+		return nil, fmt.Errorf("%w: spendable %d, needs %d", ErrInsufficientFunds, available, target)
+	}
+
+	upper := target + costs.Change
+	tries := 0
+	var path, best []int
+	bestWaste := 0
+
+	var search func(i, sum, remaining int)
+	search = func(i, sum, remaining int) {
+		if tries >= bnbMaxTries || best != nil && bestWaste == 0 {
+			return
+		}
+		tries++
+
+		if sum > upper {
+			return
+		}
+		if sum >= target {
+			// 继续加入输出只会增加超出的部分
+			if waste := sum - target; best == nil || waste < bestWaste {
+				best = append([]int{}, path...)
+				bestWaste = waste
+			}
+			return
+		}
+		if i == len(pool) || sum+remaining < target {
+			return
+		}
+
+		path = append(path, i)
+		search(i+1, sum+pool[i].value, remaining-pool[i].value)
+		path = path[:len(path)-1]
+		search(i+1, sum, remaining-pool[i].value)
+	}
+	search(0, 0, available)
+
+	if best == nil {
+		fallback := s.Fallback
+		if fallback == nil {
+			fallback = LargestFirst{}
+		}
+		return fallback.Select(candidates, target, costs)
+	}
+
+	selected := make([]SpendableOutput, len(best))
+	for i, index := range best {
+		selected[i] = pool[index].output
+	}
+
+	return selected, nil
+}
+
+// RandomImprove 先随机选取输出直到满足目标 再继续随机加入能使金额更接近目标两倍的输出
+// 找零的金额因此与支付的金额相近 避免产生零散的小额找零
+// 每次选币使用各自的随机数生成器 可以被多个goroutine同时使用
+type RandomImprove struct {
+	// Seed 随机数的种子 为0时使用当前时间 固定的种子使选择结果可以重现
+	Seed int64
+}
+
+// Select 实现CoinSelector
+func (s RandomImprove) Select(candidates []SpendableOutput, target int, costs SelectionCosts) ([]SpendableOutput, error) {
+	seed := s.Seed
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	rng := rand.New(rand.NewSource(seed))
+
+	pool := effectiveCandidates(candidates, costs)
+	rng.Shuffle(len(pool), func(i, j int) { pool[i], pool[j] = pool[j], pool[i] })
+
+	var selected []SpendableOutput
+	sum := 0
+	next := 0
+	for ; next < len(pool) && sum < target; next++ {
+		selected = append(selected, pool[next].output)
+		sum += pool[next].value
+	}
+	if sum < target {
+		return nil, fmt.Errorf("%w: spendable %d, needs %d", ErrInsufficientFunds, sum, target)
+	}
+
+	// 只接受使金额更接近2*target且不超过3*target的输出
+	ideal, limit := 2*target, 3*target
+	for ; next < len(pool); next++ {
+		improved := sum + pool[next].value
+		if improved <= limit && abs(ideal-improved) < abs(ideal-sum) {
+			selected = append(selected, pool[next].output)
+			sum = improved
+		}
+	}
+
+	return selected, nil
+}
+
+func abs(x int) int {
+	if x < 0 {
+		return -x
+	}
+
+	return x
+}
@@ -0,0 +1,147 @@
+package chain_test
+
+import (
+	"errors"
+	"reflect"
+	"sort"
+	"sync"
+	"testing"
+
+	"blockchain/core"
+	"blockchain/core/chain"
+)
+
+// 金额为values的候选输出
+func spendableOutputs(values ...int) []chain.SpendableOutput {
+	outputs := make([]chain.SpendableOutput, len(values))
+	for i, value := range values {
+		outputs[i] = chain.SpendableOutput{Txid: []byte{byte(i)}, Vout: 0, Output: core.TXOutput{Value: value}}
+	}
+
+	return outputs
+}
+
+// 选中的输出的金额 从小到大排列
+func selectedValues(selected []chain.SpendableOutput) []int {
+	values := make([]int, len(selected))
+	for i, out := range selected {
+		values[i] = out.Output.Value
+	}
+	sort.Ints(values)
+
+	return values
+}
+
+func TestCoinSelectors(t *testing.T) {
+	// 扣除输入的手续费后有效金额为0 10 20 30 50 金额为1的输出永远不会被选中
+	candidates := spendableOutputs(31, 1, 51, 11, 21)
+	costs := chain.SelectionCosts{Input: 1, Change: 5}
+
+	tests := []struct {
+		name   string
+		target int
+		// 各策略选中的输出金额 没有列出的策略只检查金额是否足够
+		want map[string][]int
+	}{
+		{
+			name:   "exact match",
+			target: 30,
+			want: map[string][]int{
+				"largest":  {51},
+				"smallest": {11, 21},
+				"bnb":      {31},
+			},
+		},
+		{
+			// 没有超出部分不多于找零成本的组合 分支定界退回到优先选择大额输出
+			name:   "change",
+			target: 42,
+			want: map[string][]int{
+				"largest":  {51},
+				"smallest": {11, 21, 31},
+				"bnb":      {51},
+			},
+		},
+		{
+			// 只有选中全部有效的输出才能恰好满足目标
+			name:   "all outputs",
+			target: 110,
+			want: map[string][]int{
+				"largest":  {11, 21, 31, 51},
+				"smallest": {11, 21, 31, 51},
+				"bnb":      {11, 21, 31, 51},
+				"random":   {11, 21, 31, 51},
+			},
+		},
+	}
+
+	selectors := map[string]chain.CoinSelector{
+		"largest":  chain.LargestFirst{},
+		"smallest": chain.SmallestFirst{},
+		"bnb":      chain.BranchAndBound{},
+		"random":   chain.RandomImprove{Seed: 1},
+	}
+	for name, selector := range selectors {
+		for _, tt := range tests {
+			t.Run(name+"/"+tt.name, func(t *testing.T) {
+				selected, err := selector.Select(candidates, tt.target, costs)
+				if err != nil {
+					t.Fatal(err)
+				}
+				got := selectedValues(selected)
+				if want, ok := tt.want[name]; ok && !reflect.DeepEqual(got, want) {
+					t.Fatalf("Select(%d) = %v, want %v", tt.target, got, want)
+				}
+				sum := 0
+				for _, value := range got {
+					if value <= costs.Input {
+						t.Fatalf("Select(%d) = %v selected an output not worth its input fee", tt.target, got)
+					}
+					sum += value - costs.Input
+				}
+				if sum < tt.target {
+					t.Fatalf("Select(%d) = %v is worth only %d", tt.target, got, sum)
+				}
+			})
+		}
+
+		t.Run(name+"/insufficient funds", func(t *testing.T) {
+			if _, err := selector.Select(candidates, 111, costs); !errors.Is(err, chain.ErrInsufficientFunds) {
+				t.Fatalf("Select(111) error = %v, want %v", err, chain.ErrInsufficientFunds)
+			}
+		})
+	}
+}
+
+func TestRandomImproveSeed(t *testing.T) {
+	candidates := spendableOutputs(5, 8, 13, 21, 34, 55, 89, 144)
+	costs := chain.SelectionCosts{Input: 1, Change: 5}
+
+	first, err := chain.RandomImprove{Seed: 42}.Select(candidates, 60, costs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 10; i++ {
+		selected, err := chain.RandomImprove{Seed: 42}.Select(candidates, 60, costs)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(selected, first) {
+			t.Fatalf("Select() with the same seed = %v, want %v", selectedValues(selected), selectedValues(first))
+		}
+	}
+
+	// 同一个选币策略可以被并发使用
+	var selector chain.CoinSelector = chain.RandomImprove{}
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := selector.Select(candidates, 60, costs); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+}
@@ -0,0 +1,86 @@
+package chain
+
+import (
+	"fmt"
+
+	"blockchain/core"
+	"blockchain/pow"
+	"blockchain/storage"
+)
+
+// RequiredBits 返回按照链的规则 紧接在prevHash之后的区块需要使用的难度
+// prevHash为空时表示创世块 使用最低难度
+func (bc *Blockchain) RequiredBits(prevHash []byte) (uint32, error) {
+	var bits uint32
+
+	err := bc.db.View(func(tx storage.Tx) error {
+		var err error
+		bits, err = txState{tx, bc.params}.RequiredBits(prevHash)
+
+		return err
+	})
+
+	return bits, err
+}
+
+// RequiredBits 每RetargetInterval个区块调整一次难度: 取上一个调整周期内第一个与最后一个区块的时间差
+// 与期望的耗时进行比较 其余高度沿用前一个区块的难度
+func (s txState) RequiredBits(prevHash []byte) (uint32, error) {
+	if len(prevHash) == 0 || s.params.NoRetargeting {
+		return s.params.PowLimitBits, nil
+	}
+
+	b := s.tx.Bucket(storage.BlocksBucket)
+	fetch := func(hash []byte) (*core.BlockHeader, error) {
+		block, err := getBlock(b, hash)
+		if err != nil {
+			return nil, err
+		}
+		return block.Header(), nil
+	}
+
+	prev, err := fetch(prevHash)
+	if err != nil {
+		return 0, err
+	}
+
+	return NextRequiredBits(prev, fetch, s.params)
+}
+
+// NextRequiredBits 返回按照链的规则 紧接在区块头prev之后的区块需要使用的难度
+// fetch按hash取得调整周期内更早的区块头 区块头可以来自尚未下载区块的区块头链
+func NextRequiredBits(prev *core.BlockHeader, fetch func(hash []byte) (*core.BlockHeader, error), params *ConsensusParams) (uint32, error) {
+	if params.NoRetargeting {
+		return params.PowLimitBits, nil
+	}
+
+	// 新区块的高度不是调整周期的整数倍时沿用前一个区块的难度
+	if (prev.Height+1)%params.RetargetInterval != 0 {
+		return prev.Bits, nil
+	}
+
+	// 找到本调整周期内的第一个区块
+	first := prev
+	for i := int64(1); i < params.RetargetInterval; i++ {
+		var err error
+		first, err = fetch(first.PrevBlockHash)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	actualTimespan := prev.Timestamp - first.Timestamp
+	targetTimespan := int64(params.TargetBlockTime.Seconds()) * (params.RetargetInterval - 1)
+
+	return pow.CalcNextBits(prev.Bits, actualTimespan, targetTimespan, params.PowLimitBits), nil
+}
+
+// 从blocks bucket中取出hash对应的区块
+func getBlock(b storage.Bucket, hash []byte) (*core.Block, error) {
+	encodedBlock := b.Get(hash)
+	if encodedBlock == nil {
+		return nil, fmt.Errorf("%w: %x", ErrBlockNotFound, hash)
+	}
+
+	return core.DeserializeBlock(encodedBlock)
+}
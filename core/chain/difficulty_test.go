@@ -0,0 +1,78 @@
+package chain_test
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"blockchain/core"
+	"blockchain/core/chain"
+)
+
+func TestNextRequiredBits(t *testing.T) {
+	params := chain.ConsensusParams{
+		PowLimitBits:     0x1f010000,
+		TargetBlockTime:  10 * time.Second,
+		RetargetInterval: 10,
+	}
+	const prevBits = 0x1e010000
+
+	// 出块间隔为期望的一半 调整周期内第一个与最后一个区块相差45秒 期望90秒
+	headers := make(map[string]*core.BlockHeader)
+	var chainHeaders []*core.BlockHeader
+	prevHash := []byte{}
+	for height := int64(0); height < 10; height++ {
+		header := &core.BlockHeader{
+			Timestamp:     1000 + 5*height,
+			PrevBlockHash: prevHash,
+			Hash:          []byte(fmt.Sprintf("block %d", height)),
+			Bits:          prevBits,
+			Height:        height,
+		}
+		headers[string(header.Hash)] = header
+		chainHeaders = append(chainHeaders, header)
+		prevHash = header.Hash
+	}
+	fetch := func(hash []byte) (*core.BlockHeader, error) {
+		if header, ok := headers[string(hash)]; ok {
+			return header, nil
+		}
+		return nil, chain.ErrBlockNotFound
+	}
+
+	noRetargeting := params
+	noRetargeting.NoRetargeting = true
+
+	tests := []struct {
+		name   string
+		prev   *core.BlockHeader
+		params *chain.ConsensusParams
+		want   uint32
+	}{
+		{"within the retarget interval", chainHeaders[8], &params, prevBits},
+		{"at the retarget height", chainHeaders[9], &params, 0x1e008000},
+		{"no retargeting within the interval", chainHeaders[8], &noRetargeting, params.PowLimitBits},
+		{"no retargeting at the retarget height", chainHeaders[9], &noRetargeting, params.PowLimitBits},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bits, err := chain.NextRequiredBits(tt.prev, fetch, tt.params)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if bits != tt.want {
+				t.Fatalf("NextRequiredBits(height %d) = %#08x, want %#08x", tt.prev.Height, bits, tt.want)
+			}
+		})
+	}
+
+	// 调整周期内的区块头缺失时无法计算难度
+	delete(headers, string(chainHeaders[0].Hash))
+	if _, err := chain.NextRequiredBits(chainHeaders[9], fetch, &params); err == nil {
+		t.Fatal("NextRequiredBits() succeeded without the first block of the interval")
+	}
+	if bits, err := chain.NextRequiredBits(chainHeaders[9], fetch, &noRetargeting); err != nil || bits != params.PowLimitBits {
+		t.Fatalf("NextRequiredBits() without retargeting = %#08x, %v, want %#08x", bits, err, params.PowLimitBits)
+	}
+}
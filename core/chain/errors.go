@@ -0,0 +1,33 @@
+package chain
+
+import "errors"
+
+// 对外暴露的错误类型 调用方可以通过errors.Is对其进行判断
+var (
+	// ErrChainNotFound 区块链数据库不存在
+	ErrChainNotFound = errors.New("no existing blockchain found, create one first")
+	// ErrChainExists 区块链数据库已存在
+	ErrChainExists = errors.New("blockchain already exists")
+	// ErrGenesisMismatch 区块链的创世块与所选网络的创世块不一致
+	ErrGenesisMismatch = errors.New("genesis block does not match the network")
+	// ErrUnsupportedChain 区块链数据库不是由当前版本创建 缺少需要的索引
+	ErrUnsupportedChain = errors.New("blockchain database format is not supported")
+	// ErrInvalidParams 网络参数的取值不合法
+	ErrInvalidParams = errors.New("invalid chain parameters")
+	// ErrInsufficientFunds 余额不足以支付交易
+	ErrInsufficientFunds = errors.New("not enough funds")
+	// ErrTransactionNotFound 区块链中不存在对应ID的交易
+	ErrTransactionNotFound = errors.New("transaction is not found")
+	// ErrInvalidTransaction 交易不合法
+	ErrInvalidTransaction = errors.New("invalid transaction")
+	// ErrBlockNotFound 区块链中不存在对应的区块
+	ErrBlockNotFound = errors.New("block is not found")
+	// ErrDuplicateBlock 区块已经存在于区块链或孤块池中
+	ErrDuplicateBlock = errors.New("block already exists")
+	// ErrDisconnectGenesis 试图断开创世块
+	ErrDisconnectGenesis = errors.New("cannot disconnect the genesis block")
+	// ErrFutureBlock 区块的时间戳超前于本地时间太多 本地时钟前进之后区块可能变为有效
+	ErrFutureBlock = errors.New("block timestamp is too far in the future")
+	// ErrImmatureCoinbase 交易花费了尚未成熟的铸币交易输出
+	ErrImmatureCoinbase = errors.New("coinbase output is not mature")
+)
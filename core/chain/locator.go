@@ -0,0 +1,130 @@
+package chain
+
+import (
+	"bytes"
+	"encoding/hex"
+
+	"blockchain/core"
+	"blockchain/storage"
+)
+
+// BlockLocator 返回描述当前主链的区块hash列表 用于向其他节点请求区块
+// 从链尾开始的前10个区块逐个列出 之后间隔成倍增加 最后一个总是创世块
+func (bc *Blockchain) BlockLocator() ([][]byte, error) {
+	var locator [][]byte
+
+	err := bc.db.View(func(tx storage.Tx) error {
+		blocks := tx.Bucket(storage.BlocksBucket)
+		heights := tx.Bucket(storage.HeightIndexBucket)
+
+		tip, err := getBlock(blocks, blocks.Get([]byte("l")))
+		if err != nil {
+			return err
+		}
+
+		step := int64(1)
+		for height := tip.Height; height > 0; height -= step {
+			locator = append(locator, append([]byte{}, heights.Get(heightKey(height))...))
+			if len(locator) >= 10 {
+				step *= 2
+			}
+		}
+		locator = append(locator, append([]byte{}, heights.Get(heightKey(0))...))
+
+		return nil
+	})
+
+	return locator, err
+}
+
+// LocateBlocks 找到locator中第一个位于主链上的区块 返回主链上其后的至多max个区块的hash
+// 遇到stop时停止(包含stop) locator中没有主链上的区块时从创世块之后开始
+func (bc *Blockchain) LocateBlocks(locator [][]byte, stop []byte, max int) ([][]byte, error) {
+	var hashes [][]byte
+
+	err := bc.db.View(func(tx storage.Tx) error {
+		start := int64(0)
+		for _, hash := range locator {
+			height, ok, err := mainChainHeight(tx, hash)
+			if err != nil {
+				return err
+			}
+			if ok {
+				start = height
+				break
+			}
+		}
+
+		heights := tx.Bucket(storage.HeightIndexBucket)
+		for height := start + 1; len(hashes) < max; height++ {
+			hash := heights.Get(heightKey(height))
+			if hash == nil {
+				break
+			}
+			hashes = append(hashes, append([]byte{}, hash...))
+			if bytes.Equal(hash, stop) {
+				break
+			}
+		}
+
+		return nil
+	})
+
+	return hashes, err
+}
+
+// LocateHeaders 与LocateBlocks相同 但返回区块头
+func (bc *Blockchain) LocateHeaders(locator [][]byte, stop []byte, max int) ([]*core.BlockHeader, error) {
+	hashes, err := bc.LocateBlocks(locator, stop, max)
+	if err != nil {
+		return nil, err
+	}
+
+	headers := make([]*core.BlockHeader, 0, len(hashes))
+	err = bc.db.View(func(tx storage.Tx) error {
+		blocks := tx.Bucket(storage.BlocksBucket)
+		for _, hash := range hashes {
+			block, err := getBlock(blocks, hash)
+			if err != nil {
+				return err
+			}
+			headers = append(headers, block.Header())
+		}
+
+		return nil
+	})
+
+	return headers, err
+}
+
+// HasBlock 区块是否已经保存在区块链(包括侧链)或孤块池中
+func (bc *Blockchain) HasBlock(hash []byte) bool {
+	bc.mu.Lock()
+	orphan := bc.orphans[hex.EncodeToString(hash)] != nil
+	bc.mu.Unlock()
+	if orphan {
+		return true
+	}
+
+	found := false
+	bc.db.View(func(tx storage.Tx) error {
+		found = tx.Bucket(storage.BlocksBucket).Get(hash) != nil
+		return nil
+	})
+
+	return found
+}
+
+// 返回主链上区块的高度 区块不存在或不在主链上时ok为false
+func mainChainHeight(tx storage.Tx, hash []byte) (height int64, ok bool, err error) {
+	if len(hash) == 0 || tx.Bucket(storage.BlocksBucket).Get(hash) == nil {
+		return 0, false, nil
+	}
+
+	block, err := getBlock(tx.Bucket(storage.BlocksBucket), hash)
+	if err != nil {
+		return 0, false, err
+	}
+
+	return block.Height, bytes.Equal(tx.Bucket(storage.HeightIndexBucket).Get(heightKey(block.Height)), hash), nil
+}
@@ -0,0 +1,221 @@
+package chain
+
+import (
+	"encoding/hex"
+	"fmt"
+	"time"
+
+	"blockchain/core"
+	"blockchain/pow"
+)
+
+// ConsensusParams 共识相关的参数 同一条链上的所有节点必须使用相同的参数
+type ConsensusParams struct {
+	// PowLimitBits 允许的最低难度 同时也是创世块的难度
+	PowLimitBits uint32
+	// TargetBlockTime 期望的出块间隔
+	TargetBlockTime time.Duration
+	// RetargetInterval 每隔多少个区块调整一次难度 至少为2
+	RetargetInterval int64
+	// NoRetargeting 不调整难度 所有区块都使用PowLimitBits 用于本地测试
+	NoRetargeting bool
+	// InitialSubsidy 创世块开始的出块奖励
+	InitialSubsidy int
+	// SubsidyHalvingInterval 出块奖励每隔多少个区块减半
+	SubsidyHalvingInterval int64
+	// CoinbaseMaturity 铸币交易的输出需要经过多少个区块才能被花费
+	CoinbaseMaturity int64
+}
+
+// DefaultConsensusParams 默认的共识参数 最低难度与原先固定的targetBits = 16相同
+var DefaultConsensusParams = ConsensusParams{
+	PowLimitBits:     0x1f010000,
+	TargetBlockTime:  10 * time.Second,
+	RetargetInterval: 10,
+
+	InitialSubsidy:         10,
+	SubsidyHalvingInterval: 210000,
+	CoinbaseMaturity:       10,
+}
+
+// Validate 检查参数的取值范围 其他方法假定参数已经通过检查
+// 例如SubsidyHalvingInterval为0时计算出块奖励会除以0 计算货币供应量不会结束
+func (p *ConsensusParams) Validate() error {
+	switch {
+	case p.SubsidyHalvingInterval <= 0:
+		return fmt.Errorf("%w: subsidy halving interval %d is not positive", ErrInvalidParams, p.SubsidyHalvingInterval)
+	case p.InitialSubsidy < 0:
+		return fmt.Errorf("%w: initial subsidy %d is negative", ErrInvalidParams, p.InitialSubsidy)
+	case p.CoinbaseMaturity < 0:
+		return fmt.Errorf("%w: coinbase maturity %d is negative", ErrInvalidParams, p.CoinbaseMaturity)
+	case !p.NoRetargeting && p.RetargetInterval < 2:
+		return fmt.Errorf("%w: retarget interval %d is less than 2", ErrInvalidParams, p.RetargetInterval)
+	case !p.NoRetargeting && p.TargetBlockTime < time.Second:
+		return fmt.Errorf("%w: target block time %s is less than a second", ErrInvalidParams, p.TargetBlockTime)
+	}
+
+	return nil
+}
+
+// BlockSubsidy 返回高度为height的区块的出块奖励 每经过SubsidyHalvingInterval个区块减半 直到为0
+func (p *ConsensusParams) BlockSubsidy(height int64) int {
+	halvings := height / p.SubsidyHalvingInterval
+	// 右移超过int的位数时结果不确定
+	if halvings >= 63 {
+		return 0
+	}
+
+	return p.InitialSubsidy >> uint(halvings)
+}
+
+// IssuedSupply 返回高度从0到height的所有区块的出块奖励之和
+func (p *ConsensusParams) IssuedSupply(height int64) int {
+	supply := 0
+	for start := int64(0); start <= height; start += p.SubsidyHalvingInterval {
+		subsidy := p.BlockSubsidy(start)
+		if subsidy == 0 {
+			break
+		}
+
+		blocks := p.SubsidyHalvingInterval
+		if start+blocks > height {
+			blocks = height - start + 1
+		}
+		supply += subsidy * int(blocks)
+	}
+
+	return supply
+}
+
+// MaxSupply 返回所有区块的出块奖励之和 即货币供应量的上限
+func (p *ConsensusParams) MaxSupply() int {
+	supply := 0
+	for start := int64(0); ; start += p.SubsidyHalvingInterval {
+		subsidy := p.BlockSubsidy(start)
+		if subsidy == 0 {
+			return supply
+		}
+		supply += subsidy * int(p.SubsidyHalvingInterval)
+	}
+}
+
+// ChainParams 一个网络的参数 同一网络的所有节点使用相同的创世块与共识参数
+type ChainParams struct {
+	// Name 网络名称 与配置中的network一致
+	Name      string
+	Consensus ConsensusParams
+
+	// 创世块由以下字段完全确定 Consensus.PowLimitBits为其难度 Consensus.BlockSubsidy(0)为其奖励
+	GenesisTimestamp    int64
+	GenesisNonce        int
+	GenesisCoinbaseData string
+	// GenesisPubKeyHash 创世块铸币交易输出的公钥hash 全零的hash没有对应的私钥 该输出无法被花费
+	GenesisPubKeyHash []byte
+	// GenesisHash 按以上字段构造的创世块的hash 打开区块链时用于识别数据库所属的网络
+	GenesisHash string
+
+	// AddressVersion 地址的版本字节
+	AddressVersion byte
+	// DefaultPort 节点默认接受连接的端口
+	DefaultPort int
+	// Magic 网络消息开头的标识 不同网络的节点无法互相通信
+	Magic [4]byte
+}
+
+// MainNetParams 主网的参数
+var MainNetParams = ChainParams{
+	Name:      "mainnet",
+	Consensus: DefaultConsensusParams,
+
+	GenesisTimestamp:    1653696000,
+	GenesisNonce:        9032,
+	GenesisCoinbaseData: "Xiao Yang Coin will be issued on May 28, 2022",
+	GenesisPubKeyHash:   make([]byte, 20),
+	GenesisHash:         "0000f8ff3e509e08f7a0db2219aec92955fa7d858c80507eca1a6697a508d438",
+
+	AddressVersion: 0x00,
+	DefaultPort:    3000,
+	Magic:          [4]byte{0x78, 0x79, 0x63, 0x01},
+}
+
+// TestNetParams 测试网的参数 共识规则与主网相同
+var TestNetParams = ChainParams{
+	Name:      "testnet",
+	Consensus: DefaultConsensusParams,
+
+	GenesisTimestamp:    1653696000,
+	GenesisNonce:        5038,
+	GenesisCoinbaseData: "Xiao Yang Coin testnet",
+	GenesisPubKeyHash:   make([]byte, 20),
+	GenesisHash:         "0000d5519dc9a01c4abc86e6cfade30eb6fdc1e67fbd00bdb0e5b10c928cba3e",
+
+	AddressVersion: 0x6f,
+	DefaultPort:    13000,
+	Magic:          [4]byte{0x78, 0x79, 0x63, 0x02},
+}
+
+// RegTestParams 本地测试用的网络 几乎没有难度且不调整难度 可以立即挖出区块
+var RegTestParams = ChainParams{
+	Name: "regtest",
+	Consensus: ConsensusParams{
+		PowLimitBits:     0x207fffff,
+		TargetBlockTime:  10 * time.Second,
+		RetargetInterval: 10,
+		NoRetargeting:    true,
+
+		InitialSubsidy:         10,
+		SubsidyHalvingInterval: 150,
+		CoinbaseMaturity:       10,
+	},
+
+	GenesisTimestamp:    1653696000,
+	GenesisNonce:        1,
+	GenesisCoinbaseData: "Xiao Yang Coin regtest",
+	GenesisPubKeyHash:   make([]byte, 20),
+	GenesisHash:         "239968be85c15970fa4102c2fc6456ee797d49480387bd56d4885a99ec2b4531",
+
+	AddressVersion: 0x6f,
+	DefaultPort:    23000,
+	Magic:          [4]byte{0x78, 0x79, 0x63, 0x03},
+}
+
+// ParamsForNetwork 返回名称为name的网络的参数
+func ParamsForNetwork(name string) (*ChainParams, error) {
+	for _, params := range []*ChainParams{&MainNetParams, &TestNetParams, &RegTestParams} {
+		if params.Name == name {
+			return params, nil
+		}
+	}
+
+	return nil, fmt.Errorf("unknown network %q", name)
+}
+
+// GenesisBlock 按参数构造创世块 不进行工作量证明 Nonce直接取自GenesisNonce
+func (p *ChainParams) GenesisBlock() (*core.Block, error) {
+	if err := p.Consensus.Validate(); err != nil {
+		return nil, err
+	}
+
+	coinbase, err := core.NewCoinbaseTXToPubKeyHash(p.GenesisPubKeyHash, p.GenesisCoinbaseData, 0, p.Consensus.BlockSubsidy(0))
+	if err != nil {
+		return nil, err
+	}
+
+	block := &core.Block{
+		Timestamp:     p.GenesisTimestamp,
+		Transactions:  []*core.Transaction{coinbase},
+		PrevBlockHash: []byte{},
+		Nonce:         p.GenesisNonce,
+		Bits:          p.Consensus.PowLimitBits,
+		Height:        0,
+	}
+	block.MerkleRoot = block.HashTransactions()
+	block.Hash = pow.NewProofOfWork(block).Hash()
+
+	return block, nil
+}
+
+// 检查hash是否为该网络的创世块
+func (p *ChainParams) isGenesis(hash []byte) bool {
+	return hex.EncodeToString(hash) == p.GenesisHash
+}
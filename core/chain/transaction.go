@@ -0,0 +1,187 @@
+package chain
+
+import (
+	"crypto/ecdsa"
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	"blockchain/core"
+	"blockchain/wallet"
+)
+
+// NewUTXOTransaction 构造一笔从from到to的转账交易 并使用wallets中from对应的私钥签名
+// 输入与输出的差额fee即为交易的手续费 由打包该交易的矿工领取
+// 余额不足以支付amount与fee时返回ErrInsufficientFunds
+func NewUTXOTransaction(wallets *wallet.Wallets, from, to string, amount, fee int, UTXOSet *UTXOset) (*core.Transaction, error) {
+	return NewMultiOutputTransaction(wallets, from, []Payment{{Address: to, Amount: amount}}, fee, UTXOSet)
+}
+
+// NewUTXOTransactionWithFeeRate 与NewUTXOTransaction相同 但手续费按交易大小计算: 每字节feeRate
+// 选币时计入每个输入的手续费 手续费增加可能需要更多的输入 因此反复构造交易直到手续费足以覆盖交易的大小
+func NewUTXOTransactionWithFeeRate(wallets *wallet.Wallets, from, to string, amount, feeRate int, UTXOSet *UTXOset) (*core.Transaction, error) {
+	return NewMultiOutputTransactionWithFeeRate(wallets, from, []Payment{{Address: to, Amount: amount}}, feeRate, UTXOSet)
+}
+
+// Payment 交易中的一笔支付
+type Payment struct {
+	Address string
+	Amount  int
+}
+
+// NewMultiOutputTransaction 构造一笔从from向多个地址付款的交易 每笔支付对应一个输出 另外最多有一个找零输出
+// 输入与输出的差额fee即为交易的手续费 余额不足时返回ErrInsufficientFunds
+func NewMultiOutputTransaction(wallets *wallet.Wallets, from string, payments []Payment, fee int, UTXOSet *UTXOset) (*core.Transaction, error) {
+	return NewMultiSourceTransaction(wallets, []string{from}, payments, from, fee, UTXOSet)
+}
+
+// NewMultiOutputTransactionWithFeeRate 与NewMultiOutputTransaction相同 但手续费按交易大小计算: 每字节feeRate
+func NewMultiOutputTransactionWithFeeRate(wallets *wallet.Wallets, from string, payments []Payment, feeRate int, UTXOSet *UTXOset) (*core.Transaction, error) {
+	return NewMultiSourceTransactionWithFeeRate(wallets, []string{from}, payments, from, feeRate, UTXOSet)
+}
+
+// NewMultiSourceTransaction 与NewMultiOutputTransaction相同 但从from中的所有地址选择输入
+// 每个输入使用wallets中对应地址的私钥签名 找零支付给change 为空时支付给from中的第一个地址
+func NewMultiSourceTransaction(wallets *wallet.Wallets, from []string, payments []Payment, change string, fee int, UTXOSet *UTXOset) (*core.Transaction, error) {
+	return newUTXOTransaction(wallets, from, payments, change, fee, SelectionCosts{}, UTXOSet)
+}
+
+// NewMultiSourceTransactionWithFeeRate 与NewMultiSourceTransaction相同 但手续费按交易大小计算: 每字节feeRate
+// 选币时计入每个输入的手续费 手续费增加可能需要更多的输入 因此反复构造交易直到手续费足以覆盖交易的大小
+func NewMultiSourceTransactionWithFeeRate(wallets *wallet.Wallets, from []string, payments []Payment, change string, feeRate int, UTXOSet *UTXOset) (*core.Transaction, error) {
+	if feeRate < 0 {
+		return nil, fmt.Errorf("%w: negative fee rate %d", ErrInvalidTransaction, feeRate)
+	}
+
+	costs := FeeRateCosts(feeRate)
+	fee := 0
+	for {
+		tx, err := newUTXOTransaction(wallets, from, payments, change, fee, costs, UTXOSet)
+		if err != nil {
+			return nil, err
+		}
+
+		required := feeRate * tx.Size()
+		if fee >= required {
+			return tx, nil
+		}
+		fee = required
+	}
+}
+
+// 构造并签名交易 输入由UTXOSet的Selector按照costs从from的所有可花费输出中选择
+// 除手续费外剩余的金额不超过costs.Change时不找零 剩余部分一并作为手续费
+func newUTXOTransaction(wallets *wallet.Wallets, from []string, payments []Payment, change string, fee int, costs SelectionCosts, UTXOSet *UTXOset) (*core.Transaction, error) {
+	var inputs []core.TXInput
+	var outputs []core.TXOutput
+
+	if len(from) == 0 {
+		return nil, fmt.Errorf("%w: no source addresses", ErrInvalidTransaction)
+	}
+	if change == "" {
+		change = from[0]
+	}
+	version := UTXOSet.Blockchain.ChainParams().AddressVersion
+	if !wallet.ValidateAddress(change, version) {
+		return nil, fmt.Errorf("%w: %s", wallet.ErrInvalidAddress, change)
+	}
+	if len(payments) == 0 {
+		return nil, fmt.Errorf("%w: no recipients", ErrInvalidTransaction)
+	}
+	maxSupply := UTXOSet.Blockchain.Params().MaxSupply()
+	amount := 0
+	for _, payment := range payments {
+		if !wallet.ValidateAddress(payment.Address, version) {
+			return nil, fmt.Errorf("%w: %s", wallet.ErrInvalidAddress, payment.Address)
+		}
+		if payment.Amount <= 0 {
+			return nil, fmt.Errorf("%w: non-positive amount %d to %s", ErrInvalidTransaction, payment.Amount, payment.Address)
+		}
+		var err error
+		if amount, err = core.AddValue(amount, payment.Amount, maxSupply); err != nil {
+			return nil, fmt.Errorf("total amount of the payments: %w", err)
+		}
+	}
+	if fee < 0 {
+		return nil, fmt.Errorf("%w: negative fee %d", ErrInvalidTransaction, fee)
+	}
+	required, err := core.AddValue(amount, fee, maxSupply)
+	if err != nil {
+		return nil, fmt.Errorf("amount plus fee: %w", err)
+	}
+
+	// 以公钥hash查找输入需要携带的公钥 以公钥查找签名使用的私钥
+	pubKeys := make(map[string][]byte)
+	keys := make(map[string]ecdsa.PrivateKey)
+	var candidates []SpendableOutput
+	for _, address := range from {
+		w, err := wallets.GetWallet(address)
+		if err != nil {
+			return nil, err
+		}
+		pubKeyHash := wallet.HashPubKey(w.PublicKey)
+		if pubKeys[hex.EncodeToString(pubKeyHash)] != nil {
+			continue
+		}
+		pubKeys[hex.EncodeToString(pubKeyHash)] = w.PublicKey
+		keys[hex.EncodeToString(w.PublicKey)] = w.PrivateKey
+
+		spendable, err := UTXOSet.SpendableOutputs(pubKeyHash)
+		if err != nil {
+			return nil, err
+		}
+		candidates = append(candidates, spendable...)
+	}
+
+	// 验证输入的币是否足够支付输出与手续费
+	selected, err := UTXOSet.selector().Select(candidates, required, costs)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", strings.Join(from, ","), err)
+	}
+
+	// 构造输入的list
+	acc := 0
+	for _, out := range selected {
+		pubKey := pubKeys[hex.EncodeToString(out.Output.PubKeyHash)]
+		inputs = append(inputs, core.TXInput{Txid: out.Txid, Vout: out.Vout, PubKey: pubKey})
+		acc += out.Output.Value
+	}
+
+	// 构造输出的list
+	for _, payment := range payments {
+		outputs = append(outputs, *core.NewTXOutput(payment.Amount, payment.Address))
+	}
+	// 当支付的UTXO 大于其需要使用的UTXO时
+	if rest := acc - required; rest > costs.Change {
+		// 增加一个找零输出 手续费不计入找零
+		outputs = append(outputs, *core.NewTXOutput(rest, change)) // a change
+	}
+
+	tx := core.Transaction{Vin: inputs, Vout: outputs}
+	tx.ID = tx.Hash()
+
+	// 输入可能引用未确认的交易 因此通过UTXO集而不是区块链查找被花费的输出
+	var fetchErr error
+	err = tx.SignInputsWithKeys(keys, UTXOSet.spendableFetcher(&fetchErr))
+	if fetchErr != nil {
+		return nil, fetchErr
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &tx, nil
+}
+
+// TransactionFee 根据UTXO集中交易输入引用的输出计算交易的手续费
+// 设置了Pending时输入也可以引用未确认的交易
+func (u UTXOset) TransactionFee(tx *core.Transaction) (int, error) {
+	var fetchErr error
+
+	fee, err := tx.Fee(u.spendableFetcher(&fetchErr), u.Blockchain.Params().MaxSupply())
+	if fetchErr != nil {
+		return 0, fetchErr
+	}
+
+	return fee, err
+}
@@ -0,0 +1,173 @@
+package chain_test
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"blockchain/core"
+	"blockchain/core/chain"
+	"blockchain/internal/chaintest"
+	"blockchain/wallet"
+)
+
+// 构造交易的测试环境: first与second各有一个成熟的铸币交易输出 other没有余额
+type txFixture struct {
+	bc                   *chain.Blockchain
+	wallets              *wallet.Wallets
+	first, second, other string
+	// 每个铸币交易输出的金额
+	value int
+}
+
+func newTxFixture(t *testing.T) *txFixture {
+	t.Helper()
+
+	bc := chaintest.NewChain(t, nil)
+	version := bc.ChainParams().AddressVersion
+	ws, err := wallet.NewWallets(filepath.Join(t.TempDir(), "wallet.dat"), version)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := &txFixture{bc: bc, wallets: ws, value: bc.Params().BlockSubsidy(1)}
+	for _, address := range []*string{&f.first, &f.second, &f.other} {
+		if *address, err = ws.CreateWallet(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, address := range []string{f.first, f.second} {
+		pubKeyHash, err := wallet.PubKeyHashFromAddress(address, version)
+		if err != nil {
+			t.Fatal(err)
+		}
+		chaintest.MineBlocks(t, bc, 1, pubKeyHash)
+	}
+	// 挖出足够的区块使两个铸币交易都成熟
+	chaintest.MineBlocks(t, bc, int(bc.Params().CoinbaseMaturity), make([]byte, 20))
+
+	return f
+}
+
+// 交易可以放入下一个区块 返回其手续费
+func (f *txFixture) check(t *testing.T, tx *core.Transaction) int {
+	t.Helper()
+
+	checker, err := f.bc.NewTemplateChecker(f.bc.Tip())
+	if err != nil {
+		t.Fatal(err)
+	}
+	fee, err := checker.Check(tx)
+	if err != nil {
+		t.Fatalf("transaction cannot be mined: %v", err)
+	}
+
+	return fee
+}
+
+// 交易的第i个输出向address支付value
+func assertOutput(t *testing.T, tx *core.Transaction, i int, address string, value int) {
+	t.Helper()
+
+	if i >= len(tx.Vout) {
+		t.Fatalf("transaction has %d outputs, want output %d to %s", len(tx.Vout), i, address)
+	}
+	pubKeyHash, err := wallet.PubKeyHashFromAddress(address, chain.RegTestParams.AddressVersion)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out := tx.Vout[i]; out.Value != value || !out.IsLockedWithKey(pubKeyHash) {
+		t.Fatalf("output %d = %d to %x, want %d to %s", i, out.Value, out.PubKeyHash, value, address)
+	}
+}
+
+func TestMultiOutputTransactionWithChange(t *testing.T) {
+	f := newTxFixture(t)
+	utxo := &chain.UTXOset{Blockchain: f.bc}
+
+	payments := []chain.Payment{{Address: f.other, Amount: 3}, {Address: f.second, Amount: 4}}
+	tx, err := chain.NewMultiOutputTransaction(f.wallets, f.first, payments, 1, utxo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tx.Vin) != 1 || len(tx.Vout) != 3 {
+		t.Fatalf("transaction has %d inputs and %d outputs, want 1 and 3", len(tx.Vin), len(tx.Vout))
+	}
+	assertOutput(t, tx, 0, f.other, 3)
+	assertOutput(t, tx, 1, f.second, 4)
+	assertOutput(t, tx, 2, f.first, f.value-3-4-1)
+	if fee := f.check(t, tx); fee != 1 {
+		t.Fatalf("fee = %d, want 1", fee)
+	}
+
+	// 恰好花费全部余额时没有找零
+	tx, err = chain.NewUTXOTransaction(f.wallets, f.first, f.other, f.value-1, 1, utxo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tx.Vout) != 1 {
+		t.Fatalf("transaction spending the whole balance has %d outputs, want 1", len(tx.Vout))
+	}
+	assertOutput(t, tx, 0, f.other, f.value-1)
+	f.check(t, tx)
+}
+
+func TestMultiSourceTransaction(t *testing.T) {
+	f := newTxFixture(t)
+	utxo := &chain.UTXOset{Blockchain: f.bc}
+
+	// 需要两个地址的余额 找零默认支付给第一个地址
+	amount := f.value + 2
+	payments := []chain.Payment{{Address: f.other, Amount: amount}}
+	tx, err := chain.NewMultiSourceTransaction(f.wallets, []string{f.first, f.second}, payments, "", 1, utxo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tx.Vin) != 2 {
+		t.Fatalf("transaction has %d inputs, want 2", len(tx.Vin))
+	}
+	assertOutput(t, tx, 0, f.other, amount)
+	assertOutput(t, tx, 1, f.first, 2*f.value-amount-1)
+	f.check(t, tx)
+
+	// 指定的找零地址
+	tx, err = chain.NewMultiSourceTransaction(f.wallets, []string{f.first, f.second}, payments, f.other, 1, utxo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertOutput(t, tx, 1, f.other, 2*f.value-amount-1)
+}
+
+func TestTransactionErrors(t *testing.T) {
+	f := newTxFixture(t)
+	utxo := &chain.UTXOset{Blockchain: f.bc}
+	maxInt := int(^uint(0) >> 1)
+	half := f.bc.Params().MaxSupply()/2 + 1
+
+	tests := []struct {
+		name     string
+		from     []string
+		payments []chain.Payment
+		fee      int
+		want     error
+	}{
+		{"amount above the balance", []string{f.first}, []chain.Payment{{Address: f.other, Amount: f.value}}, 1, chain.ErrInsufficientFunds},
+		{"fee above the balance", []string{f.first}, []chain.Payment{{Address: f.other, Amount: 1}}, f.value, chain.ErrInsufficientFunds},
+		{"address without outputs", []string{f.other}, []chain.Payment{{Address: f.first, Amount: 1}}, 0, chain.ErrInsufficientFunds},
+		{"amounts overflow", []string{f.first}, []chain.Payment{{Address: f.other, Amount: maxInt}, {Address: f.second, Amount: maxInt}}, 0, core.ErrValueOutOfRange},
+		{"amounts above the supply", []string{f.first}, []chain.Payment{{Address: f.other, Amount: half}, {Address: f.second, Amount: half}}, 0, core.ErrValueOutOfRange},
+		{"amount plus fee overflows", []string{f.first}, []chain.Payment{{Address: f.other, Amount: 1}}, maxInt, core.ErrValueOutOfRange},
+		{"amount above the supply", []string{f.first}, []chain.Payment{{Address: f.other, Amount: f.bc.Params().MaxSupply() + 1}}, 0, core.ErrValueOutOfRange},
+		{"no payments", []string{f.first}, nil, 0, chain.ErrInvalidTransaction},
+		{"negative fee", []string{f.first}, []chain.Payment{{Address: f.other, Amount: 1}}, -1, chain.ErrInvalidTransaction},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := chain.NewMultiSourceTransaction(f.wallets, tt.from, tt.payments, "", tt.fee, utxo)
+			if !errors.Is(err, tt.want) {
+				t.Fatalf("NewMultiSourceTransaction() error = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,135 @@
+package chain
+
+import (
+	"bytes"
+	"encoding/gob"
+	"fmt"
+
+	"blockchain/core"
+	"blockchain/storage"
+)
+
+// 交易在主链中的位置
+type txLocation struct {
+	BlockHash []byte
+	// 交易在区块中的下标
+	Index int
+}
+
+func (l *txLocation) serialize() ([]byte, error) {
+	var result bytes.Buffer
+
+	if err := gob.NewEncoder(&result).Encode(l); err != nil {
+		return nil, err
+	}
+
+	return result.Bytes(), nil
+}
+
+// 将区块中的交易加入交易索引 未启用交易索引时什么也不做
+func indexTransactions(tx storage.Tx, block *core.Block) error {
+	b := tx.Bucket(storage.TxIndexBucket)
+	if b == nil {
+		return nil
+	}
+
+	for i, t := range block.Transactions {
+		loc := txLocation{BlockHash: block.Hash, Index: i}
+		data, err := loc.serialize()
+		if err != nil {
+			return err
+		}
+		if err := b.Put(t.ID, data); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// 将区块中的交易从交易索引中移除 未启用交易索引时什么也不做
+func unindexTransactions(tx storage.Tx, block *core.Block) error {
+	b := tx.Bucket(storage.TxIndexBucket)
+	if b == nil {
+		return nil
+	}
+
+	for _, t := range block.Transactions {
+		if err := b.Delete(t.ID); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// 通过交易索引查找交易 found为false表示未启用交易索引
+func lookupTransaction(tx storage.Tx, txid []byte) (t *core.Transaction, found bool, err error) {
+	b := tx.Bucket(storage.TxIndexBucket)
+	if b == nil {
+		return nil, false, nil
+	}
+
+	data := b.Get(txid)
+	if data == nil {
+		return nil, true, fmt.Errorf("%w: %x", ErrTransactionNotFound, txid)
+	}
+
+	var loc txLocation
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&loc); err != nil {
+		return nil, true, err
+	}
+
+	block, err := getBlock(tx.Bucket(storage.BlocksBucket), loc.BlockHash)
+	if err != nil {
+		return nil, true, err
+	}
+	if loc.Index < 0 || loc.Index >= len(block.Transactions) || !bytes.Equal(block.Transactions[loc.Index].ID, txid) {
+		return nil, true, fmt.Errorf("transaction index entry of %x does not match block %x", txid, loc.BlockHash)
+	}
+
+	return block.Transactions[loc.Index], true, nil
+}
+
+// HasTxIndex 判断是否启用了交易索引
+func (bc *Blockchain) HasTxIndex() (bool, error) {
+	enabled := false
+
+	err := bc.db.View(func(tx storage.Tx) error {
+		enabled = tx.Bucket(storage.TxIndexBucket) != nil
+		return nil
+	})
+
+	return enabled, err
+}
+
+// ReindexTransactions 启用交易索引 并根据主链上的所有区块重新建立索引
+// 启用之后交易索引会在区块连接与断开时自动维护 FindTransaction将直接通过索引查找
+func (bc *Blockchain) ReindexTransactions() error {
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+
+	return bc.db.Update(func(tx storage.Tx) error {
+		err := tx.DeleteBucket(storage.TxIndexBucket)
+		if err != nil && err != storage.ErrBucketNotFound {
+			return err
+		}
+		if _, err := tx.CreateBucket(storage.TxIndexBucket); err != nil {
+			return err
+		}
+
+		blocks := tx.Bucket(storage.BlocksBucket)
+		for hash := blocks.Get([]byte("l")); len(hash) != 0; {
+			block, err := getBlock(blocks, hash)
+			if err != nil {
+				return err
+			}
+			if err := indexTransactions(tx, block); err != nil {
+				return err
+			}
+			hash = block.PrevBlockHash
+		}
+
+		return nil
+	})
+}
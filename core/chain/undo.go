@@ -0,0 +1,77 @@
+package chain
+
+import (
+	"bytes"
+	"encoding/gob"
+
+	"blockchain/core"
+	"blockchain/storage"
+)
+
+// 区块连接到UTXO集时被花费的一个输出
+type spentOutput struct {
+	Txid   []byte
+	Vout   int
+	Output core.TXOutput
+	// 恢复输出时需要还原其来源
+	Coinbase bool
+	Height   int64
+}
+
+// 区块的撤销记录 按区块中交易与输入的顺序记录所有被花费的输出
+type blockUndo struct {
+	Spent []spentOutput
+}
+
+func (u *blockUndo) serialize() ([]byte, error) {
+	var result bytes.Buffer
+
+	if err := gob.NewEncoder(&result).Encode(u); err != nil {
+		return nil, err
+	}
+
+	return result.Bytes(), nil
+}
+
+func putBlockUndo(tx storage.Tx, hash []byte, undo *blockUndo) error {
+	b, err := tx.CreateBucketIfNotExists(storage.UndoBucket)
+	if err != nil {
+		return err
+	}
+
+	data, err := undo.serialize()
+	if err != nil {
+		return err
+	}
+
+	return b.Put(hash, data)
+}
+
+// 取出区块的撤销记录 不存在时返回nil
+func getBlockUndo(tx storage.Tx, hash []byte) (*blockUndo, error) {
+	b := tx.Bucket(storage.UndoBucket)
+	if b == nil {
+		return nil, nil
+	}
+
+	data := b.Get(hash)
+	if data == nil {
+		return nil, nil
+	}
+
+	var undo blockUndo
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&undo); err != nil {
+		return nil, err
+	}
+
+	return &undo, nil
+}
+
+func deleteBlockUndo(tx storage.Tx, hash []byte) error {
+	b := tx.Bucket(storage.UndoBucket)
+	if b == nil {
+		return nil
+	}
+
+	return b.Delete(hash)
+}
@@ -0,0 +1,436 @@
+package chain
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+
+	"blockchain/core"
+	"blockchain/storage"
+)
+
+// UTXOset 实现UTXO缓存
+type UTXOset struct {
+	Blockchain *Blockchain
+	// Pending 可选的尚未确认的交易 设置后构造交易时会跳过已被其花费的输出 并可以花费其创建的输出
+	Pending PendingSet
+	// Selector 构造交易时选择输入的策略 为nil时使用DefaultCoinSelector
+	Selector CoinSelector
+}
+
+// PendingSet 尚未确认的交易对UTXO集的修改 由交易池实现
+// 交易池在持有自己的锁时会访问存储 因此不能在存储事务中调用PendingSet的方法
+type PendingSet interface {
+	// IsSpent 输出是否已被某笔未确认的交易花费
+	IsSpent(txid []byte, vout int) bool
+	// FetchOutput 返回未确认交易创建的输出 不存在时返回nil
+	FetchOutput(txid []byte, vout int) *core.TXOutput
+	// UnspentOutputs 返回未确认交易创建的 属于pubKeyHash且未被花费的输出
+	UnspentOutputs(pubKeyHash []byte) []SpendableOutput
+}
+
+// SpendableOutput 一个可以被花费的输出及其位置
+type SpendableOutput struct {
+	Txid   []byte
+	Vout   int
+	Output core.TXOutput
+}
+
+// CountTransactions 统计所有UTXO的总数并返回
+func (u UTXOset) CountTransactions() (int, error) {
+	db := u.Blockchain.db
+	counter := 0
+
+	err := db.View(func(tx storage.Tx) error {
+		b := tx.Bucket(storage.ChainstateBucket)
+		c := b.Cursor()
+
+		for k, _ := c.First(); k != nil; k, _ = c.Next() {
+			counter++
+		}
+
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return counter, nil
+}
+
+// Reindex UTXO集合的初始化方法 若其存在则先将其删除
+func (u UTXOset) Reindex() error {
+	db := u.Blockchain.db
+	bucketName := storage.ChainstateBucket
+
+	UTXO, err := u.Blockchain.FindUTXO()
+	if err != nil {
+		return err
+	}
+
+	// 删除旧的集合与写入新的集合在同一个事务中完成 避免中途失败留下空的UTXO集
+	return db.Update(func(tx storage.Tx) error {
+		err := tx.DeleteBucket(bucketName)
+		if err != nil && err != storage.ErrBucketNotFound {
+			return err
+		}
+		b, err := tx.CreateBucket(bucketName)
+		if err != nil {
+			return err
+		}
+
+		// 将所有未花费的UTXO都放入set中
+		for txID, outs := range UTXO {
+			key, err := hex.DecodeString(txID)
+			if err != nil {
+				return err
+			}
+
+			err = b.Put(key, outs.Serialize())
+			if err != nil {
+				return err
+			}
+		}
+
+		// 地址索引是UTXO集的一部分 一并重建
+		return rebuildAddressIndex(tx)
+	})
+}
+
+// FindSpendableOutputs 找到UTXO中未花费的输出,统计金额总数，并且返回ID及output中对应的索引集合
+// 使用Selector从SpendableOutputs中选择 可花费的输出不足amount时返回全部可花费的输出
+func (u UTXOset) FindSpendableOutputs(pubKeyHash []byte, amount int) (int, map[string][]int, error) {
+	candidates, err := u.SpendableOutputs(pubKeyHash)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	selected, err := u.selector().Select(candidates, amount, SelectionCosts{})
+	if errors.Is(err, ErrInsufficientFunds) {
+		selected, err = candidates, nil
+	}
+	if err != nil {
+		return 0, nil, err
+	}
+
+	accumulated := 0
+	unspentOutputs := make(map[string][]int)
+	for _, out := range selected {
+		txID := hex.EncodeToString(out.Txid)
+		accumulated += out.Output.Value
+		unspentOutputs[txID] = append(unspentOutputs[txID], out.Vout)
+	}
+
+	return accumulated, unspentOutputs, nil
+}
+
+// SpendableOutputs 通过地址索引返回属于pubKeyHash且能够被下一个区块中的交易花费的所有输出
+// 耗时只与该地址的未花费输出数量有关 尚未成熟的铸币交易输出被跳过
+// 设置了Pending时跳过已被未确认交易花费的输出 并加入未确认交易创建的输出
+func (u UTXOset) SpendableOutputs(pubKeyHash []byte) ([]SpendableOutput, error) {
+	var outputs []SpendableOutput
+
+	db := u.Blockchain.db
+	maturity := u.Blockchain.params.CoinbaseMaturity
+
+	err := db.View(func(tx storage.Tx) error {
+		tipHeight, err := txState{tx, u.Blockchain.params}.BlockHeight(tx.Bucket(storage.BlocksBucket).Get([]byte("l")))
+		if err != nil {
+			return err
+		}
+
+		return forEachAddressUTXO(tx, pubKeyHash, func(txid []byte, vout int, entry *UTXOEntry) error {
+			if !entry.IsMature(tipHeight+1, maturity) {
+				return nil
+			}
+
+			outputs = append(outputs, SpendableOutput{Txid: txid, Vout: vout, Output: entry.Output})
+			return nil
+		})
+	})
+
+	if err != nil {
+		return nil, err
+	}
+	if u.Pending == nil {
+		return outputs, nil
+	}
+
+	// 交易池在持有自己的锁时会写入存储 因此只能在存储事务结束之后查询交易池 否则两把锁可能互相等待
+	unspent := outputs[:0]
+	for _, out := range outputs {
+		if !u.Pending.IsSpent(out.Txid, out.Vout) {
+			unspent = append(unspent, out)
+		}
+	}
+
+	return append(unspent, u.Pending.UnspentOutputs(pubKeyHash)...), nil
+}
+
+func (u UTXOset) selector() CoinSelector {
+	if u.Selector != nil {
+		return u.Selector
+	}
+
+	return DefaultCoinSelector
+}
+
+// 返回查找交易输入引用的输出的PrevOutputFetcher 设置了Pending时同样查找未确认交易创建的输出
+// 查找过程中遇到的错误通过err返回 需要在使用fetcher之后检查
+func (u UTXOset) spendableFetcher(err *error) core.PrevOutputFetcher {
+	return func(in core.TXInput) *core.TXOutput {
+		if u.Pending != nil {
+			if out := u.Pending.FetchOutput(in.Txid, in.Vout); out != nil {
+				return out
+			}
+		}
+
+		out, fetchErr := u.FetchUTXO(in.Txid, in.Vout)
+		if fetchErr != nil && *err == nil {
+			*err = fetchErr
+		}
+		return out
+	}
+}
+
+// FindUTXO 通过地址索引查找属于pubKeyHash的所有未花费的UTXO
+func (u UTXOset) FindUTXO(pubKeyHash []byte) ([]core.TXOutput, error) {
+	var UTXOs []core.TXOutput
+	db := u.Blockchain.db
+
+	err := db.View(func(tx storage.Tx) error {
+		return forEachAddressUTXO(tx, pubKeyHash, func(txid []byte, vout int, entry *UTXOEntry) error {
+			UTXOs = append(UTXOs, entry.Output)
+			return nil
+		})
+	})
+
+	if err != nil {
+		return nil, err
+	}
+	return UTXOs, nil
+}
+
+// Rollback 撤销区块对UTXO集的修改 恢复其花费的输出并移除其创建的输出
+// block必须是主链的最后一个区块 与DisconnectBlocks(1)相同 链尾与各个索引一并回退
+func (u UTXOset) Rollback(block *core.Block) error {
+	_, err := u.Blockchain.disconnectBlocks(1, block.Hash)
+	return err
+}
+
+// 将区块连接到UTXO集: 移除区块花费的输出 加入区块创建的输出 并同步更新地址索引
+// 被花费的输出记录在区块的撤销记录中
+func connectUTXO(tx storage.Tx, block *core.Block) error {
+	b := tx.Bucket(storage.ChainstateBucket)
+	undo := &blockUndo{}
+
+	for _, tx := range block.Transactions {
+		if tx.IsCoinbase() == false {
+			// 处理输入
+			// 从bucket中取出所有本次交易输入对应的输出
+			for _, vin := range tx.Vin {
+				// 从set中取出所有输入对应的UTXO
+				outsBytes := b.Get(vin.Txid)
+				if outsBytes == nil {
+					return fmt.Errorf("%w: %x:%d", core.ErrUnknownInput, vin.Txid, vin.Vout)
+				}
+				// 反序列化
+				outs, err := core.DeserializeOutputs(outsBytes)
+				if err != nil {
+					return err
+				}
+
+				// 移除当前输入使用的UTXO
+				out, ok := outs.Outputs[vin.Vout]
+				if !ok {
+					return fmt.Errorf("%w: %x:%d", core.ErrUnknownInput, vin.Txid, vin.Vout)
+				}
+				undo.Spent = append(undo.Spent, spentOutput{
+					Txid:     vin.Txid,
+					Vout:     vin.Vout,
+					Output:   out,
+					Coinbase: outs.Coinbase,
+					Height:   outs.Height,
+				})
+				delete(outs.Outputs, vin.Vout)
+
+				// 如果恰好使用完了 直接从UTXO中移除这个输出对应的所有即可
+				if len(outs.Outputs) == 0 {
+					err := b.Delete(vin.Txid)
+					if err != nil {
+						return err
+					}
+				} else {
+					// 否则更新为仅包含当前未使用的UTXO
+					err := b.Put(vin.Txid, outs.Serialize())
+					if err != nil {
+						return err
+					}
+				}
+			}
+		}
+
+		// 处理输出 铸币交易的输出同样需要加入UTXO集
+		// 将新的输出放入UTXO集即可
+		newOutputs := core.TXOutputs{
+			Outputs:  make(map[int]core.TXOutput),
+			Coinbase: tx.IsCoinbase(),
+			Height:   block.Height,
+		}
+		for outIndex, out := range tx.Vout {
+			newOutputs.Outputs[outIndex] = out
+		}
+		err := b.Put(tx.ID, newOutputs.Serialize())
+		if err != nil {
+			return err
+		}
+	}
+
+	if err := indexAddresses(tx, block, undo.Spent); err != nil {
+		return err
+	}
+
+	return putBlockUndo(tx, block.Hash, undo)
+}
+
+// 将区块从UTXO集与地址索引中断开 是connectUTXO的逆操作
+// 区块必须是UTXO集当前对应的最后一个区块 被花费的输出从撤销记录中恢复
+func disconnectUTXO(tx storage.Tx, block *core.Block) error {
+	b := tx.Bucket(storage.ChainstateBucket)
+
+	undo, err := getBlockUndo(tx, block.Hash)
+	if err != nil {
+		return err
+	}
+	if undo == nil {
+		return fmt.Errorf("block %x has no undo data", block.Hash)
+	}
+	// 撤销记录按连接的顺序保存 断开时从后向前使用
+	next := len(undo.Spent)
+	// 按断开的顺序记录恢复的输出 用于更新地址索引
+	var restored []spentOutput
+
+	// 按相反的顺序处理交易 区块内后面的交易可能花费了前面交易的输出
+	for i := len(block.Transactions) - 1; i >= 0; i-- {
+		t := block.Transactions[i]
+
+		// 之后的区块都已断开 交易创建的输出此时一定都未被花费
+		if err := b.Delete(t.ID); err != nil {
+			return err
+		}
+
+		if t.IsCoinbase() {
+			continue
+		}
+
+		for j := len(t.Vin) - 1; j >= 0; j-- {
+			vin := t.Vin[j]
+
+			next--
+			if next < 0 || !bytes.Equal(undo.Spent[next].Txid, vin.Txid) || undo.Spent[next].Vout != vin.Vout {
+				return fmt.Errorf("undo data of block %x does not match its inputs", block.Hash)
+			}
+			spent := undo.Spent[next]
+
+			outs := core.TXOutputs{
+				Outputs:  make(map[int]core.TXOutput),
+				Coinbase: spent.Coinbase,
+				Height:   spent.Height,
+			}
+			if outsBytes := b.Get(vin.Txid); outsBytes != nil {
+				outs, err = core.DeserializeOutputs(outsBytes)
+				if err != nil {
+					return err
+				}
+			}
+			outs.Outputs[vin.Vout] = spent.Output
+			restored = append(restored, spent)
+
+			if err := b.Put(vin.Txid, outs.Serialize()); err != nil {
+				return err
+			}
+		}
+	}
+
+	if next != 0 {
+		return fmt.Errorf("undo data of block %x has %d entries more than its inputs", block.Hash, next)
+	}
+
+	// 地址索引需要按连接时的顺序提供被花费的输出
+	for i, j := 0, len(restored)-1; i < j; i, j = i+1, j-1 {
+		restored[i], restored[j] = restored[j], restored[i]
+	}
+	if err := unindexAddresses(tx, block, restored); err != nil {
+		return err
+	}
+
+	return deleteBlockUndo(tx, block.Hash)
+}
+
+// FetchUTXO 查询UTXO集中txid的第vout个输出 不存在或已被花费时返回nil
+func (u UTXOset) FetchUTXO(txid []byte, vout int) (*core.TXOutput, error) {
+	var output *core.TXOutput
+
+	err := u.Blockchain.db.View(func(tx storage.Tx) error {
+		var err error
+		output, err = fetchUTXO(tx.Bucket(storage.ChainstateBucket), txid, vout)
+
+		return err
+	})
+
+	return output, err
+}
+
+func fetchUTXO(b storage.Bucket, txid []byte, vout int) (*core.TXOutput, error) {
+	entry, err := fetchUTXOEntry(b, txid, vout)
+	if entry == nil || err != nil {
+		return nil, err
+	}
+
+	return &entry.Output, nil
+}
+
+// UTXOEntry UTXO集中的一个未花费输出 以及创建它的交易是否为铸币交易和所在区块的高度
+type UTXOEntry struct {
+	Output   core.TXOutput
+	Coinbase bool
+	Height   int64
+}
+
+// IsMature 判断输出能否被高度为spendHeight的区块中的交易花费
+// 铸币交易的输出需要经过maturity个区块才能被花费
+func (e *UTXOEntry) IsMature(spendHeight, maturity int64) bool {
+	return !e.Coinbase || spendHeight-e.Height >= maturity
+}
+
+// FetchUTXOEntry 与FetchUTXO相同 但同时返回输出的来源
+func (u UTXOset) FetchUTXOEntry(txid []byte, vout int) (*UTXOEntry, error) {
+	var entry *UTXOEntry
+
+	err := u.Blockchain.db.View(func(tx storage.Tx) error {
+		var err error
+		entry, err = fetchUTXOEntry(tx.Bucket(storage.ChainstateBucket), txid, vout)
+
+		return err
+	})
+
+	return entry, err
+}
+
+func fetchUTXOEntry(b storage.Bucket, txid []byte, vout int) (*UTXOEntry, error) {
+	outsBytes := b.Get(txid)
+	if outsBytes == nil {
+		return nil, nil
+	}
+
+	outs, err := core.DeserializeOutputs(outsBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	if out, ok := outs.Outputs[vout]; ok {
+		return &UTXOEntry{Output: out, Coinbase: outs.Coinbase, Height: outs.Height}, nil
+	}
+
+	return nil, nil
+}
@@ -0,0 +1,135 @@
+package chain_test
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+
+	"blockchain/core"
+	"blockchain/core/chain"
+	"blockchain/internal/chaintest"
+	"blockchain/storage"
+	"blockchain/wallet"
+)
+
+// 查询时写入存储的PendingSet 模拟持有锁时持久化交易的交易池
+type writingPendingSet struct {
+	db    storage.Store
+	spent map[string]bool
+}
+
+func (p *writingPendingSet) IsSpent(txid []byte, vout int) bool {
+	// 在存储事务中被调用时 写事务需要等待读事务结束 两者互相等待
+	err := p.db.Update(func(tx storage.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(storage.MempoolBucket)
+		return err
+	})
+	if err != nil {
+		panic(err)
+	}
+
+	return p.spent[chain.OutpointKey(txid, vout)]
+}
+
+func (p *writingPendingSet) FetchOutput(txid []byte, vout int) *core.TXOutput {
+	return nil
+}
+
+func (p *writingPendingSet) UnspentOutputs(pubKeyHash []byte) []chain.SpendableOutput {
+	return nil
+}
+
+func TestSpendableOutputsQueriesPendingOutsideTransaction(t *testing.T) {
+	bc := chaintest.NewChain(t, nil)
+	pubKeyHash := wallet.HashPubKey(chaintest.NewWallet(t).PublicKey)
+	coinbases := chaintest.MineBlocks(t, bc, int(bc.Params().CoinbaseMaturity)+1, pubKeyHash)
+
+	pending := &writingPendingSet{db: bc.Store(), spent: map[string]bool{chain.OutpointKey(coinbases[0].ID, 0): true}}
+	outputs, err := chain.UTXOset{Blockchain: bc, Pending: pending}.SpendableOutputs(pubKeyHash)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 第二个铸币交易成熟 第一个已被未确认的交易花费
+	if len(outputs) != 1 || !bytes.Equal(outputs[0].Txid, coinbases[1].ID) {
+		t.Fatalf("SpendableOutputs() = %+v, want only the output of %x", outputs, coinbases[1].ID)
+	}
+}
+
+// 断开区块后UTXO集与各个索引与连接之前逐字节相同
+func TestRollbackRoundTrip(t *testing.T) {
+	bc := chaintest.NewChain(t, nil)
+	if err := bc.ReindexTransactions(); err != nil {
+		t.Fatal(err)
+	}
+	w := chaintest.NewWallet(t)
+	pubKeyHash := wallet.HashPubKey(w.PublicKey)
+	params := bc.Params()
+	coinbases := chaintest.MineBlocks(t, bc, int(params.CoinbaseMaturity)+1, pubKeyHash)
+	height := params.CoinbaseMaturity + 2
+	value := coinbases[0].Vout[0].Value
+	buckets := []string{
+		storage.ChainstateBucket, storage.UndoBucket, storage.HeightIndexBucket,
+		storage.TxIndexBucket, storage.AddrUTXOBucket, storage.AddrHistoryBucket,
+	}
+	utxo := chain.UTXOset{Blockchain: bc}
+	tip := bc.Tip()
+	before := dumpStore(t, bc.Store(), buckets...)
+
+	// 区块内后面的交易花费前面交易的输出 被花费的输出包括部分花费的交易
+	spend := chaintest.Spend(t, w, coinbases[0], 0, value/2, value-value/2)
+	chained := chaintest.Spend(t, w, spend, 1, value-value/2)
+	other := chaintest.Spend(t, w, coinbases[1], 0, value)
+	block := chaintest.NewBlock(t, bc, chaintest.Coinbase(t, height, pubKeyHash, params.BlockSubsidy(height)), spend, chained, other)
+	if _, err := bc.AddBlock(block); err != nil {
+		t.Fatal(err)
+	}
+	connected := dumpStore(t, bc.Store(), buckets...)
+	undo := connected[storage.UndoBucket][string(block.Hash)]
+
+	if err := utxo.Rollback(block); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(bc.Tip(), tip) {
+		t.Fatalf("tip after Rollback() = %x, want %x", bc.Tip(), tip)
+	}
+	if after := dumpStore(t, bc.Store(), buckets...); !reflect.DeepEqual(after, before) {
+		t.Fatal("UTXO set and indexes after Rollback() differ from before the block was connected")
+	}
+
+	// 连接两个区块后一次断开 断开的区块保留在侧链上 使用奖励不同的区块重新连接
+	block = chaintest.NewBlock(t, bc, chaintest.Coinbase(t, height, pubKeyHash, params.BlockSubsidy(height)-1), spend, chained, other)
+	if _, err := bc.AddBlock(block); err != nil {
+		t.Fatal(err)
+	}
+	chaintest.MineBlocks(t, bc, 1, pubKeyHash)
+	if err := utxo.Rollback(block); err == nil {
+		t.Fatal("Rollback() of a block below the tip succeeded")
+	}
+	if _, err := bc.DisconnectBlocks(2); err != nil {
+		t.Fatal(err)
+	}
+	if after := dumpStore(t, bc.Store(), buckets...); !reflect.DeepEqual(after, before) {
+		t.Fatal("UTXO set and indexes after DisconnectBlocks(2) differ from before the blocks were connected")
+	}
+
+	// 撤销记录比区块的输入多出的项不能被忽略
+	spendOther := chaintest.Spend(t, w, coinbases[1], 0, value-1)
+	short := chaintest.NewBlock(t, bc, chaintest.Coinbase(t, height, pubKeyHash, params.BlockSubsidy(height)+1), spendOther)
+	if _, err := bc.AddBlock(short); err != nil {
+		t.Fatal(err)
+	}
+	err := bc.Store().Update(func(tx storage.Tx) error {
+		return tx.Bucket(storage.UndoBucket).Put(short.Hash, []byte(undo))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	corrupted := dumpStore(t, bc.Store(), buckets...)
+	if err := utxo.Rollback(short); err == nil {
+		t.Fatal("Rollback() with unused undo entries succeeded")
+	}
+	if after := dumpStore(t, bc.Store(), buckets...); !reflect.DeepEqual(after, corrupted) {
+		t.Fatal("failed Rollback() modified the UTXO set")
+	}
+}
@@ -0,0 +1,435 @@
+package chain
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"blockchain/core"
+	"blockchain/pow"
+	"blockchain/storage"
+)
+
+const (
+	// 计算中位时间时使用的区块数
+	medianTimeBlocks = 11
+	// 区块时间戳允许超前于本地时间的最大值
+	maxFutureBlockTime = 2 * time.Hour
+)
+
+// ErrInvalidBlock 区块违反了共识规则 具体原因见BlockError
+var ErrInvalidBlock = errors.New("invalid block")
+
+// BlockError 描述区块违反的某一条共识规则
+// errors.Is(err, ErrInvalidBlock)对所有的BlockError成立 Err为导致该错误的底层错误
+type BlockError struct {
+	Hash   []byte
+	Reason string
+	Err    error
+}
+
+func (e *BlockError) Error() string {
+	msg := fmt.Sprintf("%v: %s", ErrInvalidBlock, e.Reason)
+	// 尚未完成工作量证明的区块没有hash
+	if len(e.Hash) != 0 {
+		msg = fmt.Sprintf("%v %x: %s", ErrInvalidBlock, e.Hash, e.Reason)
+	}
+	if e.Err != nil {
+		msg += ": " + e.Err.Error()
+	}
+
+	return msg
+}
+
+func (e *BlockError) Unwrap() error {
+	return e.Err
+}
+
+func (e *BlockError) Is(target error) bool {
+	return target == ErrInvalidBlock
+}
+
+func blockError(block *core.Block, err error, format string, args ...interface{}) error {
+	return &BlockError{block.Hash, fmt.Sprintf(format, args...), err}
+}
+
+// ChainState 验证区块时需要查询的链状态 由Blockchain实现
+type ChainState interface {
+	// RequiredBits 紧接在prevHash之后的区块需要使用的难度
+	RequiredBits(prevHash []byte) (uint32, error)
+	// MedianTimePast 以prevHash为最后一个区块的最近若干个区块时间戳的中位数
+	MedianTimePast(prevHash []byte) (int64, error)
+	// FetchUTXOEntry 查询一个未花费的输出及其来源 不存在或已被花费时返回nil
+	FetchUTXOEntry(txid []byte, vout int) (*UTXOEntry, error)
+	// BlockHeight 已保存的区块的高度
+	BlockHeight(hash []byte) (int64, error)
+	// Params 链使用的共识参数
+	Params() *ConsensusParams
+}
+
+// ValidateBlock 按照所有的共识规则验证一个将要接在其PrevBlockHash之后的区块
+// 返回的错误为*BlockError 描述了区块违反的第一条规则
+func ValidateBlock(block *core.Block, state ChainState) error {
+	if err := checkBlockHeader(block, state); err != nil {
+		return err
+	}
+
+	return checkBlockTransactions(block, state)
+}
+
+// 验证区块头: 默克尔树根 工作量证明 区块hash与时间戳
+func checkBlockHeader(block *core.Block, state ChainState) error {
+	if len(block.Transactions) == 0 {
+		return blockError(block, nil, "block has no transactions")
+	}
+	if size := block.Size(); size > core.MaxBlockSize {
+		return blockError(block, nil, "block size %d exceeds the maximum %d", size, core.MaxBlockSize)
+	}
+
+	if !bytes.Equal(block.MerkleRoot, block.HashTransactions()) {
+		return blockError(block, nil, "merkle root %x does not match the transactions", block.MerkleRoot)
+	}
+
+	bits, err := state.RequiredBits(block.PrevBlockHash)
+	if err != nil {
+		return blockError(block, err, "unknown previous block %x", block.PrevBlockHash)
+	}
+	proof := pow.NewProofOfWork(block)
+	if !proof.Validate(bits) {
+		return blockError(block, nil, "proof of work is invalid (bits %08x, required %08x)", block.Bits, bits)
+	}
+	if !bytes.Equal(block.Hash, proof.Hash()) {
+		return blockError(block, nil, "block hash does not match its header")
+	}
+
+	var height int64
+	if len(block.PrevBlockHash) != 0 {
+		prevHeight, err := state.BlockHeight(block.PrevBlockHash)
+		if err != nil {
+			return blockError(block, err, "unknown previous block %x", block.PrevBlockHash)
+		}
+		height = prevHeight + 1
+	}
+	if block.Height != height {
+		return blockError(block, nil, "height %d does not follow the previous block (expected %d)", block.Height, height)
+	}
+
+	if block.Timestamp > time.Now().Add(maxFutureBlockTime).Unix() {
+		return blockError(block, ErrFutureBlock, "timestamp %d is more than %s ahead of the local clock", block.Timestamp, maxFutureBlockTime)
+	}
+	if len(block.PrevBlockHash) != 0 {
+		medianTime, err := state.MedianTimePast(block.PrevBlockHash)
+		if err != nil {
+			return blockError(block, err, "cannot compute median time past")
+		}
+		if block.Timestamp <= medianTime {
+			return blockError(block, nil, "timestamp %d is not after median time past %d", block.Timestamp, medianTime)
+		}
+	}
+
+	return nil
+}
+
+// 验证区块中的交易: 铸币交易的位置与金额 交易本身的格式 输入是否存在且未被花费 签名是否合法
+func checkBlockTransactions(block *core.Block, state ChainState) error {
+	if len(block.Transactions) == 0 {
+		return blockError(block, nil, "block has no transactions")
+	}
+
+	coinbase := block.Transactions[0]
+	if err := CheckTransactionSanity(coinbase, state.Params()); err != nil {
+		return blockError(block, err, "transaction 0 (%x) is malformed", coinbase.ID)
+	}
+	if !coinbase.IsCoinbase() {
+		return blockError(block, nil, "first transaction is not a coinbase")
+	}
+	if height, ok := coinbase.CoinbaseHeight(); !ok || height != block.Height {
+		return blockError(block, nil, "coinbase does not commit to the block height %d", block.Height)
+	}
+
+	checker := newBlockTxChecker(state, block.Height)
+	checker.created[hex.EncodeToString(coinbase.ID)] = coinbase
+	fees := 0
+	for i, tx := range block.Transactions[1:] {
+		fee, err := checker.check(tx)
+		if err != nil {
+			return blockError(block, err, "transaction %d (%x) is invalid", i+1, tx.ID)
+		}
+		if fees, err = core.AddValue(fees, fee, checker.maxSupply); err != nil {
+			return blockError(block, err, "fees of the block are out of range")
+		}
+	}
+
+	coinbaseValue, err := coinbase.OutputValue(checker.maxSupply)
+	if err != nil {
+		return blockError(block, err, "coinbase outputs are out of range")
+	}
+	subsidy := state.Params().BlockSubsidy(block.Height)
+	if coinbaseValue > subsidy+fees {
+		return blockError(block, nil, "coinbase pays %d, more than subsidy %d plus fees %d", coinbaseValue, subsidy, fees)
+	}
+
+	return nil
+}
+
+// 依次检查同一个区块中铸币交易之后的交易 记录区块内已经花费与创建的输出
+type blockTxChecker struct {
+	state     ChainState
+	height    int64
+	maxSupply int
+	// 区块中已经被花费的输出 用于检测区块内部的双花
+	spent map[string]bool
+	// 区块中之前的交易创建的输出 后面的交易可以直接使用
+	created map[string]*core.Transaction
+}
+
+func newBlockTxChecker(state ChainState, height int64) *blockTxChecker {
+	return &blockTxChecker{
+		state:     state,
+		height:    height,
+		maxSupply: state.Params().MaxSupply(),
+		spent:     make(map[string]bool),
+		created:   make(map[string]*core.Transaction),
+	}
+}
+
+// 检查tx能否放在之前检查过的交易之后 通过时记录其花费与创建的输出并返回手续费 不通过时不做任何记录
+func (c *blockTxChecker) check(tx *core.Transaction) (int, error) {
+	txID := hex.EncodeToString(tx.ID)
+
+	if err := CheckTransactionSanity(tx, c.state.Params()); err != nil {
+		return 0, fmt.Errorf("malformed transaction: %w", err)
+	}
+	if c.created[txID] != nil {
+		return 0, errors.New("transaction appears more than once")
+	}
+	if tx.IsCoinbase() {
+		return 0, errors.New("extra coinbase")
+	}
+
+	prevOuts := make(map[string]*core.TXOutput)
+	inputValue := 0
+	for _, vin := range tx.Vin {
+		key := OutpointKey(vin.Txid, vin.Vout)
+		if c.spent[key] || prevOuts[key] != nil {
+			return 0, fmt.Errorf("double spends %s inside the block", key)
+		}
+
+		entry, err := fetchBlockOutput(c.state, c.created, c.height, vin)
+		if err != nil {
+			return 0, fmt.Errorf("cannot look up input %s: %w", key, err)
+		}
+		if entry == nil {
+			return 0, fmt.Errorf("%w: spends missing or already spent output %s", core.ErrUnknownInput, key)
+		}
+		if maturity := c.state.Params().CoinbaseMaturity; !entry.IsMature(c.height, maturity) {
+			return 0, fmt.Errorf("%w: spends coinbase output %s from height %d before %d confirmations", ErrImmatureCoinbase, key, entry.Height, maturity)
+		}
+
+		prevOuts[key] = &entry.Output
+		if inputValue, err = core.AddValue(inputValue, entry.Output.Value, c.maxSupply); err != nil {
+			return 0, fmt.Errorf("inputs: %w", err)
+		}
+	}
+
+	err := tx.VerifyInputs(func(in core.TXInput) *core.TXOutput {
+		return prevOuts[OutpointKey(in.Txid, in.Vout)]
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	outputValue, err := tx.OutputValue(c.maxSupply)
+	if err != nil {
+		return 0, fmt.Errorf("outputs: %w", err)
+	}
+	if inputValue < outputValue {
+		return 0, fmt.Errorf("spends %d but its inputs only hold %d", outputValue, inputValue)
+	}
+
+	for key := range prevOuts {
+		c.spent[key] = true
+	}
+	c.created[txID] = tx
+
+	return inputValue - outputValue, nil
+}
+
+// TemplateChecker 组装区块模板时依次检查候选交易 使区块只包含能够通过验证的交易
+type TemplateChecker struct {
+	checker *blockTxChecker
+}
+
+// NewTemplateChecker 返回检查接在主链上prevHash之后的区块中交易的TemplateChecker
+func (bc *Blockchain) NewTemplateChecker(prevHash []byte) (*TemplateChecker, error) {
+	prevHeight, err := bc.BlockHeight(prevHash)
+	if err != nil {
+		return nil, err
+	}
+
+	return &TemplateChecker{checker: newBlockTxChecker(bc, prevHeight+1)}, nil
+}
+
+// Check 检查tx能否放在之前通过检查的交易之后 通过时返回交易的手续费
+// 不通过时返回错误 之后的检查不受该交易的影响
+func (c *TemplateChecker) Check(tx *core.Transaction) (int, error) {
+	fee, err := c.checker.check(tx)
+	if err != nil {
+		return 0, fmt.Errorf("%w: %x: %v", ErrInvalidTransaction, tx.ID, err)
+	}
+
+	return fee, nil
+}
+
+// CheckTransactionSanity 检查交易本身的格式 不依赖链状态
+// 每个输出的金额以及所有输出的金额之和都不能超过params的货币供应量上限 避免金额之和溢出
+func CheckTransactionSanity(tx *core.Transaction, params *ConsensusParams) error {
+	if len(tx.Vin) == 0 {
+		return errors.New("transaction has no inputs")
+	}
+	if len(tx.Vout) == 0 {
+		return errors.New("transaction has no outputs")
+	}
+	if !bytes.Equal(tx.ID, tx.Hash()) {
+		return errors.New("transaction id does not match its hash")
+	}
+	maxSupply := params.MaxSupply()
+	for i, out := range tx.Vout {
+		// 出块奖励减半到0后 没有手续费的铸币交易金额为0
+		if out.Value < 0 || out.Value == 0 && !tx.IsCoinbase() {
+			return fmt.Errorf("output %d has non-positive value %d", i, out.Value)
+		}
+		if out.Value > maxSupply {
+			return fmt.Errorf("%w: output %d has value %d above the maximum supply %d", core.ErrValueOutOfRange, i, out.Value, maxSupply)
+		}
+
+	}
+	if _, err := tx.OutputValue(maxSupply); err != nil {
+		return fmt.Errorf("total output value: %w", err)
+	}
+	if !tx.IsCoinbase() {
+		for i, vin := range tx.Vin {
+			if len(vin.Txid) == 0 || vin.Vout < 0 {
+				return fmt.Errorf("input %d has a null previous output", i)
+			}
+		}
+	}
+
+	return nil
+}
+
+// 先在高度为height的区块内已创建的输出中查找 再到UTXO集中查找
+func fetchBlockOutput(state ChainState, created map[string]*core.Transaction, height int64, vin core.TXInput) (*UTXOEntry, error) {
+	if tx := created[hex.EncodeToString(vin.Txid)]; tx != nil {
+		if vin.Vout < 0 || vin.Vout >= len(tx.Vout) {
+			return nil, nil
+		}
+		return &UTXOEntry{Output: tx.Vout[vin.Vout], Coinbase: tx.IsCoinbase(), Height: height}, nil
+	}
+
+	return state.FetchUTXOEntry(vin.Txid, vin.Vout)
+}
+
+// OutpointKey 一个输出的唯一标识 txid:vout
+func OutpointKey(txid []byte, vout int) string {
+	return fmt.Sprintf("%x:%d", txid, vout)
+}
+
+// MedianTimePast 返回以prevHash为最后一个区块的最近medianTimeBlocks个区块时间戳的中位数
+// 新区块的时间戳必须大于该值
+func (bc *Blockchain) MedianTimePast(prevHash []byte) (int64, error) {
+	var medianTime int64
+
+	err := bc.db.View(func(tx storage.Tx) error {
+		var err error
+		medianTime, err = txState{tx, bc.params}.MedianTimePast(prevHash)
+
+		return err
+	})
+
+	return medianTime, err
+}
+
+// FetchUTXO 查询UTXO集中的一个未花费输出
+func (bc *Blockchain) FetchUTXO(txid []byte, vout int) (*core.TXOutput, error) {
+	return UTXOset{Blockchain: bc}.FetchUTXO(txid, vout)
+}
+
+// FetchUTXOEntry 查询UTXO集中的一个未花费输出及其来源
+func (bc *Blockchain) FetchUTXOEntry(txid []byte, vout int) (*UTXOEntry, error) {
+	return UTXOset{Blockchain: bc}.FetchUTXOEntry(txid, vout)
+}
+
+// BlockHeight 返回hash对应区块的高度 区块可以不在主链上
+func (bc *Blockchain) BlockHeight(hash []byte) (int64, error) {
+	var height int64
+
+	err := bc.db.View(func(tx storage.Tx) error {
+		var err error
+		height, err = txState{tx, bc.params}.BlockHeight(hash)
+
+		return err
+	})
+
+	return height, err
+}
+
+// Params 返回区块链使用的共识参数
+func (bc *Blockchain) Params() *ConsensusParams {
+	return bc.params
+}
+
+// ChainParams 返回区块链所属网络的参数
+func (bc *Blockchain) ChainParams() *ChainParams {
+	return bc.chainParams
+}
+
+// 在一个存储事务中查询链状态
+// 连接区块与链重组时 验证需要看到同一事务中尚未提交的修改
+type txState struct {
+	tx     storage.Tx
+	params *ConsensusParams
+}
+
+func (s txState) MedianTimePast(prevHash []byte) (int64, error) {
+	var timestamps []int64
+
+	b := s.tx.Bucket(storage.BlocksBucket)
+	hash := prevHash
+	for len(hash) != 0 && len(timestamps) < medianTimeBlocks {
+		block, err := getBlock(b, hash)
+		if err != nil {
+			return 0, err
+		}
+		timestamps = append(timestamps, block.Timestamp)
+		hash = block.PrevBlockHash
+	}
+
+	if len(timestamps) == 0 {
+		return 0, nil
+	}
+
+	sort.Slice(timestamps, func(i, j int) bool { return timestamps[i] < timestamps[j] })
+
+	return timestamps[len(timestamps)/2], nil
+}
+
+func (s txState) FetchUTXOEntry(txid []byte, vout int) (*UTXOEntry, error) {
+	return fetchUTXOEntry(s.tx.Bucket(storage.ChainstateBucket), txid, vout)
+}
+
+func (s txState) BlockHeight(hash []byte) (int64, error) {
+	block, err := getBlock(s.tx.Bucket(storage.BlocksBucket), hash)
+	if err != nil {
+		return 0, err
+	}
+
+	return block.Height, nil
+}
+
+func (s txState) Params() *ConsensusParams {
+	return s.params
+}
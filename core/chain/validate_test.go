@@ -0,0 +1,132 @@
+package chain_test
+
+import (
+	"errors"
+	"testing"
+
+	"blockchain/core"
+	"blockchain/core/chain"
+	"blockchain/internal/chaintest"
+	"blockchain/wallet"
+)
+
+// 区块被拒绝 且主链末端保持不变
+func assertBlockRejected(t *testing.T, bc *chain.Blockchain, block *core.Block, target error) {
+	t.Helper()
+
+	tip := bc.Tip()
+	_, err := bc.AddBlock(block)
+	if !errors.Is(err, chain.ErrInvalidBlock) {
+		t.Fatalf("AddBlock() error = %v, want %v", err, chain.ErrInvalidBlock)
+	}
+	if target != nil && !errors.Is(err, target) {
+		t.Fatalf("AddBlock() error = %v, want %v", err, target)
+	}
+	if string(bc.Tip()) != string(tip) {
+		t.Fatalf("tip moved to rejected block %x", bc.Tip())
+	}
+}
+
+func TestCoinbaseValueOverflow(t *testing.T) {
+	bc := chaintest.NewChain(t, nil)
+	pubKeyHash := wallet.HashPubKey(chaintest.NewWallet(t).PublicKey)
+
+	// 两个输出之和溢出为负数 不能绕过铸币交易金额的上限
+	half := int(^uint(0)>>1)/2 + 1
+	coinbase := chaintest.Coinbase(t, 1, pubKeyHash, half, half)
+	assertBlockRejected(t, bc, chaintest.NewBlock(t, bc, coinbase), core.ErrValueOutOfRange)
+
+	utxos, err := chain.UTXOset{Blockchain: bc}.FindUTXO(pubKeyHash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(utxos) != 0 {
+		t.Fatalf("rejected coinbase left %d outputs in the UTXO set", len(utxos))
+	}
+}
+
+func TestTransactionOutputOverflow(t *testing.T) {
+	bc := chaintest.NewChain(t, nil)
+	w := chaintest.NewWallet(t)
+	pubKeyHash := wallet.HashPubKey(w.PublicKey)
+	params := bc.Params()
+
+	coinbases := chaintest.MineBlocks(t, bc, int(params.CoinbaseMaturity), pubKeyHash)
+	height := params.CoinbaseMaturity + 1
+
+	// 输出之和溢出为负数时 输入金额看起来足以支付输出 差额成为巨额手续费
+	half := int(^uint(0)>>1)/2 + 1
+	spend := chaintest.Spend(t, w, coinbases[0], 0, half, half)
+	coinbase := chaintest.Coinbase(t, height, pubKeyHash, params.BlockSubsidy(height))
+	assertBlockRejected(t, bc, chaintest.NewBlock(t, bc, coinbase, spend), core.ErrValueOutOfRange)
+
+	// 单个输出超过货币供应量的上限
+	spend = chaintest.Spend(t, w, coinbases[0], 0, params.MaxSupply()+1)
+	assertBlockRejected(t, bc, chaintest.NewBlock(t, bc, coinbase, spend), core.ErrValueOutOfRange)
+}
+
+func TestCoinbaseValueLimit(t *testing.T) {
+	bc := chaintest.NewChain(t, nil)
+	w := chaintest.NewWallet(t)
+	pubKeyHash := wallet.HashPubKey(w.PublicKey)
+	params := bc.Params()
+
+	coinbases := chaintest.MineBlocks(t, bc, int(params.CoinbaseMaturity), pubKeyHash)
+	height := params.CoinbaseMaturity + 1
+	subsidy := params.BlockSubsidy(height)
+
+	const fee = 3
+	spend := chaintest.Spend(t, w, coinbases[0], 0, coinbases[0].Vout[0].Value-fee)
+
+	// 比出块奖励与手续费之和多1
+	coinbase := chaintest.Coinbase(t, height, pubKeyHash, subsidy, fee+1)
+	assertBlockRejected(t, bc, chaintest.NewBlock(t, bc, coinbase, spend), nil)
+
+	// 恰好等于出块奖励与手续费之和
+	coinbase = chaintest.Coinbase(t, height, pubKeyHash, subsidy, fee)
+	block := chaintest.NewBlock(t, bc, coinbase, spend)
+	status, err := bc.AddBlock(block)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status != chain.BlockMainChain {
+		t.Fatalf("AddBlock() status = %s, want %s", status, chain.BlockMainChain)
+	}
+}
+
+func TestCoinbaseMaturity(t *testing.T) {
+	bc := chaintest.NewChain(t, nil)
+	w := chaintest.NewWallet(t)
+	pubKeyHash := wallet.HashPubKey(w.PublicKey)
+	maturity := bc.Params().CoinbaseMaturity
+
+	// 铸币交易位于高度1 高度为1+maturity的区块才能花费它
+	coinbase := chaintest.MineBlocks(t, bc, 1, pubKeyHash)[0]
+	chaintest.MineBlocks(t, bc, int(maturity)-2, pubKeyHash)
+	spend := chaintest.Spend(t, w, coinbase, 0, coinbase.Vout[0].Value)
+
+	checker, err := bc.NewTemplateChecker(bc.Tip())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := checker.Check(spend); !errors.Is(err, chain.ErrInvalidTransaction) {
+		t.Fatalf("Check() at depth %d error = %v, want %v", maturity-1, err, chain.ErrInvalidTransaction)
+	}
+	immature := chaintest.NewBlock(t, bc, chaintest.Coinbase(t, maturity, make([]byte, 20), bc.Params().BlockSubsidy(maturity)), spend)
+	assertBlockRejected(t, bc, immature, chain.ErrImmatureCoinbase)
+
+	chaintest.MineBlocks(t, bc, 1, pubKeyHash)
+	if checker, err = bc.NewTemplateChecker(bc.Tip()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := checker.Check(spend); err != nil {
+		t.Fatalf("Check() at depth %d error = %v", maturity, err)
+	}
+	mature := chaintest.NewBlock(t, bc, chaintest.Coinbase(t, maturity+1, pubKeyHash, bc.Params().BlockSubsidy(maturity+1)), spend)
+	if _, err := bc.AddBlock(mature); err != nil {
+		t.Fatalf("AddBlock() spending the coinbase at depth %d error = %v", maturity, err)
+	}
+	if out, err := bc.FetchUTXO(coinbase.ID, 0); err != nil || out != nil {
+		t.Fatalf("FetchUTXO() of the spent coinbase = %v, %v, want nil", out, err)
+	}
+}
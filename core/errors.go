@@ -0,0 +1,13 @@
+package core
+
+import "errors"
+
+// 对外暴露的错误类型 调用方可以通过errors.Is对其进行判断
+var (
+	// ErrInvalidSignature 交易的签名验证失败
+	ErrInvalidSignature = errors.New("invalid transaction signature")
+	// ErrUnknownInput 交易的输入引用了一个不存在的交易或输出
+	ErrUnknownInput = errors.New("transaction input references an unknown output")
+	// ErrValueOutOfRange 金额为负数或金额之和超过了货币供应量的上限
+	ErrValueOutOfRange = errors.New("value out of range")
+)
@@ -0,0 +1,17 @@
+package mempool
+
+import "errors"
+
+// 对外暴露的错误类型 调用方可以通过errors.Is对其进行判断
+var (
+	// ErrAlreadyExists 交易已经在交易池中或已被打包进区块
+	ErrAlreadyExists = errors.New("transaction already exists")
+	// ErrNotFound 交易池中不存在对应的交易
+	ErrNotFound = errors.New("transaction is not in the mempool")
+	// ErrDoubleSpend 交易花费的输出已被交易池中的另一笔交易花费
+	ErrDoubleSpend = errors.New("transaction double spends an output spent by another mempool transaction")
+	// ErrMissingInputs 交易引用的输出既不在UTXO集中 也不是交易池中交易的输出
+	ErrMissingInputs = errors.New("transaction spends a missing or already spent output")
+	// ErrPoolFull 交易池已满 且交易的手续费率不高于池中最低的手续费率
+	ErrPoolFull = errors.New("mempool is full and the transaction fee rate is too low")
+)
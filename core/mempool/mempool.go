@@ -0,0 +1,571 @@
+// Package mempool 保存已经通过验证但尚未被打包进区块的交易
+//
+// 交易池中的交易持久化在区块链数据库的mempool bucket中 重新打开时会被重新验证
+// 交易可以花费池中其他交易创建的输出 挖矿时父交易总是排在子交易之前
+package mempool
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"blockchain/core"
+	"blockchain/core/chain"
+	"blockchain/storage"
+)
+
+// DefaultMaxSize 交易池中所有交易序列化后的总大小上限
+const DefaultMaxSize = 1 << 20
+
+// Entry 交易池中的一笔交易
+type Entry struct {
+	Tx   *core.Transaction
+	Fee  int
+	Size int
+	// 交易加入交易池的时间
+	Added int64
+}
+
+// FeeRate 交易每字节的手续费
+func (e *Entry) FeeRate() float64 {
+	return float64(e.Fee) / float64(e.Size)
+}
+
+// Mempool 交易池 可以被多个goroutine同时使用
+type Mempool struct {
+	// MaxSize 交易总大小的上限 超过时按手续费率从低到高驱逐交易
+	MaxSize int
+
+	mu      sync.Mutex
+	bc      *chain.Blockchain
+	entries map[string]*Entry
+	// 被池中交易花费的输出 outpoint -> 花费它的交易ID
+	spent map[string]string
+	size  int
+}
+
+// New 打开区块链对应的交易池 并重新验证之前保存的交易 已经失效的交易会被丢弃
+func New(bc *chain.Blockchain) (*Mempool, error) {
+	mp := &Mempool{
+		MaxSize: DefaultMaxSize,
+		bc:      bc,
+		entries: make(map[string]*Entry),
+		spent:   make(map[string]string),
+	}
+
+	if err := mp.load(); err != nil {
+		return nil, err
+	}
+
+	return mp, nil
+}
+
+// Add 验证交易并将其加入交易池
+// 交易的输入必须引用UTXO集中的输出或池中其他交易的输出 且不能与池中的交易花费同一个输出
+// 交易池已满时驱逐手续费率更低的交易 返回加入的交易
+func (mp *Mempool) Add(tx *core.Transaction) (*Entry, error) {
+	mp.mu.Lock()
+	defer mp.mu.Unlock()
+
+	entry, evicted, err := mp.accept(tx, time.Now().Unix())
+	if err != nil {
+		return nil, err
+	}
+
+	if err := mp.persist([]*Entry{entry}, evicted); err != nil {
+		return nil, err
+	}
+
+	return entry, nil
+}
+
+// Get 返回交易池中对应ID的交易 不存在时返回ErrNotFound
+func (mp *Mempool) Get(txid []byte) (*Entry, error) {
+	mp.mu.Lock()
+	defer mp.mu.Unlock()
+
+	entry := mp.entries[hex.EncodeToString(txid)]
+	if entry == nil {
+		return nil, fmt.Errorf("%w: %x", ErrNotFound, txid)
+	}
+
+	return entry, nil
+}
+
+// List 返回交易池中的所有交易 父交易总是排在花费其输出的子交易之前
+func (mp *Mempool) List() []*Entry {
+	mp.mu.Lock()
+	defer mp.mu.Unlock()
+
+	return mp.ordered()
+}
+
+// Count 返回交易池中的交易数
+func (mp *Mempool) Count() int {
+	mp.mu.Lock()
+	defer mp.mu.Unlock()
+
+	return len(mp.entries)
+}
+
+// Size 返回交易池中所有交易的总大小
+func (mp *Mempool) Size() int {
+	mp.mu.Lock()
+	defer mp.mu.Unlock()
+
+	return mp.size
+}
+
+// Remove 从交易池中移除一笔交易以及所有花费了其输出的后代交易 返回被移除的交易
+func (mp *Mempool) Remove(txid []byte) ([]*Entry, error) {
+	mp.mu.Lock()
+	defer mp.mu.Unlock()
+
+	entry := mp.entries[hex.EncodeToString(txid)]
+	if entry == nil {
+		return nil, fmt.Errorf("%w: %x", ErrNotFound, txid)
+	}
+
+	removed := mp.removeWithDescendants(entry)
+	if err := mp.persist(nil, removed); err != nil {
+		return nil, err
+	}
+
+	return removed, nil
+}
+
+// RemoveBlock 在区块加入主链后调用: 移除区块中已被打包的交易
+// 以及与区块中的交易花费同一个输出的冲突交易及其后代
+func (mp *Mempool) RemoveBlock(block *core.Block) error {
+	mp.mu.Lock()
+	defer mp.mu.Unlock()
+
+	var removed []*Entry
+	for _, tx := range block.Transactions {
+		if tx.IsCoinbase() {
+			continue
+		}
+
+		txID := hex.EncodeToString(tx.ID)
+		if entry := mp.entries[txID]; entry != nil {
+			// 子交易花费的输出现在已在UTXO集中 因此保留子交易
+			mp.removeEntry(entry)
+			removed = append(removed, entry)
+		}
+
+		for _, vin := range tx.Vin {
+			spender := mp.spent[chain.OutpointKey(vin.Txid, vin.Vout)]
+			if spender == "" || spender == txID {
+				continue
+			}
+			removed = append(removed, mp.removeWithDescendants(mp.entries[spender])...)
+		}
+	}
+
+	return mp.persist(nil, removed)
+}
+
+// Revalidate 清空内存中的交易池并重新验证所有保存的交易 用于主链发生重组或一次连接多个区块之后
+// 已被主链打包、与主链冲突或输入不再存在的交易会被丢弃
+func (mp *Mempool) Revalidate() error {
+	mp.mu.Lock()
+	defer mp.mu.Unlock()
+
+	mp.entries = make(map[string]*Entry)
+	mp.spent = make(map[string]string)
+	mp.size = 0
+
+	return mp.load()
+}
+
+// IsSpent 输出是否已被池中的交易花费
+func (mp *Mempool) IsSpent(txid []byte, vout int) bool {
+	mp.mu.Lock()
+	defer mp.mu.Unlock()
+
+	return mp.spent[chain.OutpointKey(txid, vout)] != ""
+}
+
+// FetchOutput 返回池中交易创建的输出 不存在时返回nil
+func (mp *Mempool) FetchOutput(txid []byte, vout int) *core.TXOutput {
+	mp.mu.Lock()
+	defer mp.mu.Unlock()
+
+	return mp.poolOutput(txid, vout)
+}
+
+// UnspentOutputs 返回池中交易创建的 属于pubKeyHash且未被池中其他交易花费的输出
+func (mp *Mempool) UnspentOutputs(pubKeyHash []byte) []chain.SpendableOutput {
+	mp.mu.Lock()
+	defer mp.mu.Unlock()
+
+	var outputs []chain.SpendableOutput
+	for _, entry := range mp.ordered() {
+		for i, out := range entry.Tx.Vout {
+			if out.IsLockedWithKey(pubKeyHash) && mp.spent[chain.OutpointKey(entry.Tx.ID, i)] == "" {
+				outputs = append(outputs, chain.SpendableOutput{Txid: entry.Tx.ID, Vout: i, Output: out})
+			}
+		}
+	}
+
+	return outputs
+}
+
+// 验证交易并将其加入内存中的交易池 返回加入的交易以及为其腾出空间而被驱逐的交易
+func (mp *Mempool) accept(tx *core.Transaction, added int64) (*Entry, []*Entry, error) {
+	txID := hex.EncodeToString(tx.ID)
+	if mp.entries[txID] != nil {
+		return nil, nil, fmt.Errorf("%w: %s is in the mempool", ErrAlreadyExists, txID)
+	}
+	if tx.IsCoinbase() {
+		return nil, nil, fmt.Errorf("%w: coinbase %s cannot be relayed", chain.ErrInvalidTransaction, txID)
+	}
+	if err := chain.CheckTransactionSanity(tx, mp.bc.Params()); err != nil {
+		return nil, nil, fmt.Errorf("%w: %v", chain.ErrInvalidTransaction, err)
+	}
+
+	utxo := chain.UTXOset{Blockchain: mp.bc}
+	for i := range tx.Vout {
+		out, err := utxo.FetchUTXO(tx.ID, i)
+		if err != nil {
+			return nil, nil, err
+		}
+		if out != nil {
+			return nil, nil, fmt.Errorf("%w: %s is already in the chain", ErrAlreadyExists, txID)
+		}
+	}
+
+	// 交易最早被打包进下一个区块 铸币交易的输出需要在该高度成熟
+	tipHeight, err := mp.bc.GetBestHeight()
+	if err != nil {
+		return nil, nil, err
+	}
+	maturity := mp.bc.Params().CoinbaseMaturity
+
+	prevOuts := make(map[string]*core.TXOutput)
+	for _, vin := range tx.Vin {
+		key := chain.OutpointKey(vin.Txid, vin.Vout)
+		if prevOuts[key] != nil {
+			return nil, nil, fmt.Errorf("%w: %s spends %s twice", chain.ErrInvalidTransaction, txID, key)
+		}
+		if spender := mp.spent[key]; spender != "" {
+			return nil, nil, fmt.Errorf("%w: %s is already spent by %s", ErrDoubleSpend, key, spender)
+		}
+
+		out := mp.poolOutput(vin.Txid, vin.Vout)
+		if out == nil {
+			entry, err := utxo.FetchUTXOEntry(vin.Txid, vin.Vout)
+			if err != nil {
+				return nil, nil, err
+			}
+			if entry != nil && !entry.IsMature(tipHeight+1, maturity) {
+				return nil, nil, fmt.Errorf("%w: %s from height %d", chain.ErrImmatureCoinbase, key, entry.Height)
+			}
+			if entry != nil {
+				out = &entry.Output
+			}
+		}
+		if out == nil {
+			return nil, nil, fmt.Errorf("%w: %s", ErrMissingInputs, key)
+		}
+		prevOuts[key] = out
+	}
+
+	fetch := func(in core.TXInput) *core.TXOutput {
+		return prevOuts[chain.OutpointKey(in.Txid, in.Vout)]
+	}
+	if err := tx.VerifyInputs(fetch); err != nil {
+		return nil, nil, err
+	}
+	fee, err := tx.Fee(fetch, mp.bc.Params().MaxSupply())
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: %v", chain.ErrInvalidTransaction, err)
+	}
+	if fee < 0 {
+		return nil, nil, fmt.Errorf("%w: %s spends %d more than its inputs", chain.ErrInvalidTransaction, txID, -fee)
+	}
+
+	entry := &Entry{Tx: tx, Fee: fee, Size: tx.Size(), Added: added}
+	evicted, err := mp.makeRoom(entry)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	mp.entries[txID] = entry
+	for _, vin := range tx.Vin {
+		mp.spent[chain.OutpointKey(vin.Txid, vin.Vout)] = txID
+	}
+	mp.size += entry.Size
+
+	return entry, evicted, nil
+}
+
+// 交易池超过容量上限时 按手续费率从低到高驱逐交易及其后代 直到能够容纳entry
+// 只驱逐手续费率低于entry的交易 且不会驱逐entry依赖的祖先交易
+func (mp *Mempool) makeRoom(entry *Entry) ([]*Entry, error) {
+	if mp.size+entry.Size <= mp.MaxSize {
+		return nil, nil
+	}
+	if entry.Size > mp.MaxSize {
+		return nil, ErrPoolFull
+	}
+
+	ancestors := make(map[string]bool)
+	mp.collectAncestors(entry.Tx, ancestors)
+
+	candidates := make([]*Entry, 0, len(mp.entries))
+	for _, e := range mp.entries {
+		candidates = append(candidates, e)
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		return candidates[i].FeeRate() < candidates[j].FeeRate()
+	})
+
+	// 先确定需要驱逐的交易 空间不足时交易池保持不变
+	evict := make(map[string]*Entry)
+	freed := 0
+	for _, candidate := range candidates {
+		if mp.size-freed+entry.Size <= mp.MaxSize {
+			break
+		}
+		id := hex.EncodeToString(candidate.Tx.ID)
+		if evict[id] != nil || ancestors[id] {
+			continue
+		}
+		if candidate.FeeRate() >= entry.FeeRate() {
+			return nil, ErrPoolFull
+		}
+
+		for _, e := range append([]*Entry{candidate}, mp.descendants(candidate)...) {
+			eID := hex.EncodeToString(e.Tx.ID)
+			if evict[eID] == nil {
+				evict[eID] = e
+				freed += e.Size
+			}
+		}
+	}
+	if mp.size-freed+entry.Size > mp.MaxSize {
+		return nil, ErrPoolFull
+	}
+
+	var evicted []*Entry
+	for _, e := range evict {
+		mp.removeEntry(e)
+		evicted = append(evicted, e)
+	}
+
+	return evicted, nil
+}
+
+// 找到tx在池中依赖的所有祖先交易
+func (mp *Mempool) collectAncestors(tx *core.Transaction, ancestors map[string]bool) {
+	for _, vin := range tx.Vin {
+		id := hex.EncodeToString(vin.Txid)
+		if parent := mp.entries[id]; parent != nil && !ancestors[id] {
+			ancestors[id] = true
+			mp.collectAncestors(parent.Tx, ancestors)
+		}
+	}
+}
+
+// 所有直接或间接花费了entry输出的池中交易
+func (mp *Mempool) descendants(entry *Entry) []*Entry {
+	var result []*Entry
+	seen := make(map[string]bool)
+	queue := []*Entry{entry}
+
+	for len(queue) > 0 {
+		e := queue[0]
+		queue = queue[1:]
+
+		for i := range e.Tx.Vout {
+			spender := mp.spent[chain.OutpointKey(e.Tx.ID, i)]
+			if spender == "" || seen[spender] {
+				continue
+			}
+			seen[spender] = true
+			child := mp.entries[spender]
+			result = append(result, child)
+			queue = append(queue, child)
+		}
+	}
+
+	return result
+}
+
+func (mp *Mempool) removeWithDescendants(entry *Entry) []*Entry {
+	removed := append([]*Entry{entry}, mp.descendants(entry)...)
+	for _, e := range removed {
+		mp.removeEntry(e)
+	}
+
+	return removed
+}
+
+func (mp *Mempool) removeEntry(entry *Entry) {
+	txID := hex.EncodeToString(entry.Tx.ID)
+	if mp.entries[txID] == nil {
+		return
+	}
+
+	delete(mp.entries, txID)
+	for _, vin := range entry.Tx.Vin {
+		key := chain.OutpointKey(vin.Txid, vin.Vout)
+		if mp.spent[key] == txID {
+			delete(mp.spent, key)
+		}
+	}
+	mp.size -= entry.Size
+}
+
+func (mp *Mempool) poolOutput(txid []byte, vout int) *core.TXOutput {
+	entry := mp.entries[hex.EncodeToString(txid)]
+	if entry == nil || vout < 0 || vout >= len(entry.Tx.Vout) {
+		return nil
+	}
+
+	return &entry.Tx.Vout[vout]
+}
+
+// 按加入的时间排序 并保证父交易排在子交易之前
+func (mp *Mempool) ordered() []*Entry {
+	entries := make([]*Entry, 0, len(mp.entries))
+	for _, e := range mp.entries {
+		entries = append(entries, e)
+	}
+	sortByAdded(entries)
+
+	result := make([]*Entry, 0, len(entries))
+	visited := make(map[string]bool)
+	var visit func(e *Entry)
+	visit = func(e *Entry) {
+		id := hex.EncodeToString(e.Tx.ID)
+		if visited[id] {
+			return
+		}
+		visited[id] = true
+
+		for _, vin := range e.Tx.Vin {
+			if parent := mp.entries[hex.EncodeToString(vin.Txid)]; parent != nil {
+				visit(parent)
+			}
+		}
+		result = append(result, e)
+	}
+	for _, e := range entries {
+		visit(e)
+	}
+
+	return result
+}
+
+func sortByAdded(entries []*Entry) {
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].Added != entries[j].Added {
+			return entries[i].Added < entries[j].Added
+		}
+		return bytes.Compare(entries[i].Tx.ID, entries[j].Tx.ID) < 0
+	})
+}
+
+// 将新加入的交易写入数据库 并删除被移除的交易
+func (mp *Mempool) persist(added, removed []*Entry) error {
+	if len(added) == 0 && len(removed) == 0 {
+		return nil
+	}
+
+	return mp.bc.Store().Update(func(tx storage.Tx) error {
+		b, err := tx.CreateBucketIfNotExists(storage.MempoolBucket)
+		if err != nil {
+			return err
+		}
+
+		for _, e := range removed {
+			if err := b.Delete(e.Tx.ID); err != nil {
+				return err
+			}
+		}
+		for _, e := range added {
+			data, err := e.serialize()
+			if err != nil {
+				return err
+			}
+			if err := b.Put(e.Tx.ID, data); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+// 读取数据库中保存的交易并重新验证 父交易需要先于子交易加入 因此反复尝试直到没有新的交易能够加入
+func (mp *Mempool) load() error {
+	var saved []*Entry
+
+	err := mp.bc.Store().View(func(tx storage.Tx) error {
+		b := tx.Bucket(storage.MempoolBucket)
+		if b == nil {
+			return nil
+		}
+
+		return b.ForEach(func(k, v []byte) error {
+			entry, err := deserializeEntry(v)
+			if err != nil {
+				return err
+			}
+			saved = append(saved, entry)
+			return nil
+		})
+	})
+	if err != nil {
+		return err
+	}
+	sortByAdded(saved)
+
+	var dropped []*Entry
+	for progress := true; progress && len(saved) > 0; {
+		progress = false
+		var retry []*Entry
+
+		for _, e := range saved {
+			_, evicted, err := mp.accept(e.Tx, e.Added)
+			dropped = append(dropped, evicted...)
+			switch {
+			case err == nil:
+				progress = true
+			case errors.Is(err, ErrMissingInputs):
+				retry = append(retry, e)
+			default:
+				dropped = append(dropped, e)
+			}
+		}
+		saved = retry
+	}
+
+	return mp.persist(nil, append(dropped, saved...))
+}
+
+func (e *Entry) serialize() ([]byte, error) {
+	var result bytes.Buffer
+
+	if err := gob.NewEncoder(&result).Encode(e); err != nil {
+		return nil, err
+	}
+
+	return result.Bytes(), nil
+}
+
+func deserializeEntry(data []byte) (*Entry, error) {
+	var entry Entry
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&entry); err != nil {
+		return nil, err
+	}
+
+	return &entry, nil
+}
@@ -0,0 +1,57 @@
+package mempool
+
+import (
+	"errors"
+	"testing"
+
+	"blockchain/core"
+	"blockchain/core/chain"
+	"blockchain/internal/chaintest"
+	"blockchain/wallet"
+)
+
+// 在内存中创建一条回归测试网络的区块链 并挖出足够的区块使第一个铸币交易成熟
+// 返回区块链、出块奖励的接收者与第一个铸币交易
+func newTestChain(t *testing.T) (*chain.Blockchain, *wallet.Wallet, *core.Transaction) {
+	t.Helper()
+
+	bc := chaintest.NewChain(t, nil)
+	w := chaintest.NewWallet(t)
+	coinbases := chaintest.MineBlocks(t, bc, int(bc.Params().CoinbaseMaturity), wallet.HashPubKey(w.PublicKey))
+
+	return bc, w, coinbases[0]
+}
+
+func TestAddComputesFee(t *testing.T) {
+	bc, w, coinbase := newTestChain(t)
+	mp, err := New(bc)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	entry, err := mp.Add(chaintest.Spend(t, w, coinbase, 0, coinbase.Vout[0].Value-3))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entry.Fee != 3 {
+		t.Fatalf("Fee = %d, want 3", entry.Fee)
+	}
+}
+
+func TestAddRejectsOverflowingOutputs(t *testing.T) {
+	bc, w, coinbase := newTestChain(t)
+	mp, err := New(bc)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 输出之和溢出为负数时 手续费会变成一个巨大的正数
+	half := int(^uint(0)>>1)/2 + 1
+	_, err = mp.Add(chaintest.Spend(t, w, coinbase, 0, half, half))
+	if !errors.Is(err, chain.ErrInvalidTransaction) {
+		t.Fatalf("Add() error = %v, want %v", err, chain.ErrInvalidTransaction)
+	}
+	if mp.Count() != 0 {
+		t.Fatalf("mempool holds %d transactions after rejecting the only one", mp.Count())
+	}
+}
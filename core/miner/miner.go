@@ -0,0 +1,235 @@
+// Package miner 从交易池中选取交易组装区块 完成工作量证明后将其加入区块链
+package miner
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"time"
+
+	"blockchain/core"
+	"blockchain/core/chain"
+	"blockchain/core/mempool"
+	"blockchain/pow"
+	"blockchain/wallet"
+)
+
+// 为区块头与铸币交易预留的字节数
+// 单独序列化的交易带有gob的类型信息 其大小之和总是大于交易在区块中实际占用的字节数
+const blockReserve = 1000
+
+// 挖矿期间检查主链末端是否变化的间隔
+const tipPollInterval = 500 * time.Millisecond
+
+// Miner 从交易池中选取交易组装区块并进行挖矿 区块奖励与手续费支付给Address
+type Miner struct {
+	// Address 接收区块奖励的地址
+	Address string
+	// MaxBlockSize 组装的区块的最大字节数 不能超过core.MaxBlockSize
+	MaxBlockSize int
+	// Workers 并行进行工作量证明的goroutine数 小于等于0时使用所有CPU
+	Workers int
+	// HashRate 不为nil时在挖矿期间定期以每秒计算的hash数调用
+	HashRate func(hashesPerSecond float64)
+
+	bc      *chain.Blockchain
+	mempool *mempool.Mempool
+}
+
+// New 创建一个从mp中选取交易 将区块加入bc的矿工
+func New(bc *chain.Blockchain, mp *mempool.Mempool, address string) (*Miner, error) {
+	if !wallet.ValidateAddress(address, bc.ChainParams().AddressVersion) {
+		return nil, fmt.Errorf("%w: %s", wallet.ErrInvalidAddress, address)
+	}
+
+	return &Miner{
+		Address:      address,
+		MaxBlockSize: core.MaxBlockSize,
+		bc:           bc,
+		mempool:      mp,
+	}, nil
+}
+
+// BlockTemplate 按手续费率从高到低从交易池中选取交易 直到区块达到最大字节数
+// 返回的第一笔交易为领取区块奖励与所有手续费的铸币交易 父交易总是排在子交易之前
+// 无法通过验证的交易及其后代被跳过 并通过重新验证交易池将其移出交易池
+func (m *Miner) BlockTemplate() ([]*core.Transaction, error) {
+	return m.blockTemplate(m.bc.Tip())
+}
+
+// 为接在prevHash之后的区块组装交易
+func (m *Miner) blockTemplate(prevHash []byte) ([]*core.Transaction, error) {
+	prevHeight, err := m.bc.BlockHeight(prevHash)
+	if err != nil {
+		return nil, err
+	}
+	height := prevHeight + 1
+
+	maxSize := m.MaxBlockSize
+	if maxSize <= 0 || maxSize > core.MaxBlockSize {
+		maxSize = core.MaxBlockSize
+	}
+
+	entries := m.mempool.List()
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].FeeRate() > entries[j].FeeRate()
+	})
+
+	pending := make(map[string]bool)
+	for _, entry := range entries {
+		pending[hex.EncodeToString(entry.Tx.ID)] = true
+	}
+
+	checker, err := m.bc.NewTemplateChecker(prevHash)
+	if err != nil {
+		return nil, err
+	}
+	// 交易池中有交易在加入之后失效 例如其输入已被其他区块花费
+	invalid := false
+
+	var txs []*core.Transaction
+	fees := 0
+	size := blockReserve
+	selected := make(map[string]bool)
+	skipped := make(map[string]bool)
+
+	// 子交易的手续费率可能高于其父交易 父交易被选中后需要再次尝试之前跳过的子交易
+	for progress := true; progress; {
+		progress = false
+		for _, entry := range entries {
+			txID := hex.EncodeToString(entry.Tx.ID)
+			if selected[txID] || skipped[txID] {
+				continue
+			}
+
+			ready, orphaned := parentsSelected(entry.Tx, pending, selected, skipped)
+			// 放不下的交易的后代也不能被选中
+			if orphaned || size+entry.Size > maxSize {
+				skipped[txID] = true
+				progress = true
+				continue
+			}
+			if !ready {
+				continue
+			}
+
+			fee, err := checker.Check(entry.Tx)
+			if err != nil {
+				skipped[txID] = true
+				invalid = true
+				progress = true
+				continue
+			}
+
+			txs = append(txs, entry.Tx)
+			fees += fee
+			size += entry.Size
+			selected[txID] = true
+			progress = true
+		}
+	}
+
+	if invalid {
+		if err := m.mempool.Revalidate(); err != nil {
+			return nil, err
+		}
+	}
+
+	reward := m.bc.Params().BlockSubsidy(height) + fees
+	cbTx, err := core.NewCoinbaseTX(m.Address, m.bc.ChainParams().AddressVersion, "", height, reward)
+	if err != nil {
+		return nil, err
+	}
+
+	return append([]*core.Transaction{cbTx}, txs...), nil
+}
+
+// 检查交易引用的交易池中的父交易 ready表示父交易都已被选中 orphaned表示有父交易不会被选中
+func parentsSelected(tx *core.Transaction, pending, selected, skipped map[string]bool) (ready, orphaned bool) {
+	ready = true
+	for _, vin := range tx.Vin {
+		parent := hex.EncodeToString(vin.Txid)
+		if !pending[parent] {
+			continue
+		}
+		if skipped[parent] {
+			return false, true
+		}
+		if !selected[parent] {
+			ready = false
+		}
+	}
+
+	return ready, false
+}
+
+// MineBlock 组装区块模板并完成工作量证明 区块加入区块链与UTXO集后将其中的交易移出交易池
+// 挖矿期间主链末端发生变化时放弃当前区块 在新的末端上重新组装模板 ctx被取消时返回ctx.Err()
+func (m *Miner) MineBlock(ctx context.Context) (*core.Block, error) {
+	for {
+		tip := m.bc.Tip()
+		txs, err := m.blockTemplate(tip)
+		if err != nil {
+			return nil, err
+		}
+
+		// 组装交易期间主链末端发生变化时 铸币交易记录的高度可能与区块不符
+		block, err := m.bc.NewBlockTemplate(txs)
+		if !bytes.Equal(m.bc.Tip(), tip) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		solveCtx, cancel := context.WithCancel(ctx)
+		go m.watchTip(solveCtx, cancel, block.PrevBlockHash)
+
+		opts := pow.SolveOptions{Workers: m.Workers, HashRate: m.HashRate}
+		nonce, hash, err := pow.NewProofOfWork(block).Solve(solveCtx, opts)
+		cancel()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			continue
+		}
+		block.Nonce = nonce
+		block.Hash = hash
+
+		status, err := m.bc.AddBlock(block)
+		if err != nil {
+			return nil, err
+		}
+		// 区块加入之前主链已被其他区块延长
+		if status != chain.BlockMainChain {
+			continue
+		}
+
+		if err := m.mempool.RemoveBlock(block); err != nil {
+			return nil, err
+		}
+
+		return block, nil
+	}
+}
+
+// 定期检查主链末端 不再是prevHash时调用cancel
+func (m *Miner) watchTip(ctx context.Context, cancel context.CancelFunc, prevHash []byte) {
+	ticker := time.NewTicker(tipPollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if !bytes.Equal(m.bc.Tip(), prevHash) {
+				cancel()
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,60 @@
+package miner
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"blockchain/core"
+	"blockchain/core/mempool"
+	"blockchain/internal/chaintest"
+	"blockchain/wallet"
+)
+
+func TestMineBlockSkipsStaleTransactions(t *testing.T) {
+	bc := chaintest.NewChain(t, nil)
+	w := chaintest.NewWallet(t)
+	pubKeyHash := wallet.HashPubKey(w.PublicKey)
+	params := bc.Params()
+	coinbases := chaintest.MineBlocks(t, bc, int(params.CoinbaseMaturity)+1, pubKeyHash)
+
+	mp, err := mempool.New(bc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stale, err := mp.Add(chaintest.Spend(t, w, coinbases[0], 0, coinbases[0].Vout[0].Value-2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	valid, err := mp.Add(chaintest.Spend(t, w, coinbases[1], 0, coinbases[1].Vout[0].Value-1))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 另一个区块花费了stale的输入 但没有经过交易池
+	height := params.CoinbaseMaturity + 2
+	coinbase, err := core.NewCoinbaseTXToPubKeyHash(pubKeyHash, "", height, params.BlockSubsidy(height))
+	if err != nil {
+		t.Fatal(err)
+	}
+	conflict := chaintest.Spend(t, w, coinbases[0], 0, coinbases[0].Vout[0].Value)
+	if _, err := bc.MineBlock([]*core.Transaction{coinbase, conflict}); err != nil {
+		t.Fatal(err)
+	}
+
+	m, err := New(bc, mp, string(w.GetAddress(bc.ChainParams().AddressVersion)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, err := m.MineBlock(context.Background())
+	if err != nil {
+		t.Fatalf("MineBlock() with a stale mempool transaction: %v", err)
+	}
+
+	if len(block.Transactions) != 2 || !bytes.Equal(block.Transactions[1].ID, valid.Tx.ID) {
+		t.Fatalf("mined block has %d transactions, want the coinbase and %x", len(block.Transactions), valid.Tx.ID)
+	}
+	if _, err := mp.Get(stale.Tx.ID); err == nil {
+		t.Fatalf("stale transaction %x is still in the mempool", stale.Tx.ID)
+	}
+}
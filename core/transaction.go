@@ -0,0 +1,393 @@
+package core
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/gob"
+	"encoding/hex"
+	"fmt"
+	"math/big"
+	"strings"
+
+	"blockchain/wallet"
+)
+
+// gob编码中包含类型的编号 编号在进程中按类型第一次被编码或解码的顺序分配
+// 交易ID与默克尔树根都是对gob编码计算hash 因此在其他类型之前确定交易与区块的类型编号
+// 否则不同的进程(例如先处理了网络消息的节点)对同一笔交易会得到不同的编码与hash
+func init() {
+	Transaction{}.Serialize()
+	(&Block{}).Serialize()
+}
+
+// 使用gob编码交易、区块与输出集合
+// 这些类型只包含整数、字节切片与由它们组成的结构体和切片 写入bytes.Buffer也不会失败
+// 因此编码只会在类型定义被改成gob不支持的类型时出错 这是程序的错误而不是运行时的错误
+// 交易与区块在init中已经编码过一次 这样的错误在进程启动时就会暴露
+func gobEncode(v interface{}) []byte {
+	var encoded bytes.Buffer
+	if err := gob.NewEncoder(&encoded).Encode(v); err != nil {
+		panic(fmt.Sprintf("core: cannot gob encode %T: %v", v, err))
+	}
+
+	return encoded.Bytes()
+}
+
+// Transaction 按照bitcoin论文中的模型定义一个transcation
+type Transaction struct {
+	ID   []byte
+	Vin  []TXInput
+	Vout []TXOutput
+}
+
+// IsCoinbase 判断当前交易是否为铸币交易
+func (tx Transaction) IsCoinbase() bool {
+	// 在此区块链原型中 将铸币交易的Txid设置为空，并且将Vout设置为-1 并且只有一个输入
+	// 通过以上特征判断交易是否为铸币交易
+	return len(tx.Vin) == 1 && len(tx.Vin[0].Txid) == 0 && tx.Vin[0].Vout == -1
+}
+
+// OutputValue 返回交易所有输出的金额之和 任一输出为负数或总和超过maxValue时返回ErrValueOutOfRange
+func (tx Transaction) OutputValue(maxValue int) (int, error) {
+	value := 0
+	for _, out := range tx.Vout {
+		var err error
+		if value, err = AddValue(value, out.Value, maxValue); err != nil {
+			return 0, err
+		}
+	}
+
+	return value, nil
+}
+
+// AddValue 将金额value累加到total上 任一金额为负数或总和超过maxValue时返回ErrValueOutOfRange
+// 在累加之前检查 因此不会发生整数溢出
+func AddValue(total, value, maxValue int) (int, error) {
+	if total < 0 || value < 0 || value > maxValue-total {
+		return 0, fmt.Errorf("%w: %d + %d is not within [0, %d]", ErrValueOutOfRange, total, value, maxValue)
+	}
+
+	return total + value, nil
+}
+
+// Size 返回交易序列化后的字节数 用于按费率计算手续费
+func (tx Transaction) Size() int {
+	return len(tx.Serialize())
+}
+
+// Fee 返回交易的手续费 即所有输入的金额之和减去所有输出的金额之和 铸币交易的手续费为0
+// 任意一个输入引用的输出不存在时返回ErrUnknownInput 输入或输出的金额之和超过maxValue时返回ErrValueOutOfRange
+func (tx *Transaction) Fee(fetch PrevOutputFetcher, maxValue int) (int, error) {
+	if tx.IsCoinbase() {
+		return 0, nil
+	}
+
+	inputValue := 0
+	for _, vin := range tx.Vin {
+		prevOut := fetch(vin)
+		if prevOut == nil {
+			return 0, fmt.Errorf("%w: %x:%d", ErrUnknownInput, vin.Txid, vin.Vout)
+		}
+
+		var err error
+		if inputValue, err = AddValue(inputValue, prevOut.Value, maxValue); err != nil {
+			return 0, err
+		}
+	}
+
+	outputValue, err := tx.OutputValue(maxValue)
+	if err != nil {
+		return 0, err
+	}
+
+	return inputValue - outputValue, nil
+}
+
+// Serialize 使用gob对交易进行序列化
+func (tx Transaction) Serialize() []byte {
+	return gobEncode(tx)
+}
+
+// DeserializeTransaction 反序列化交易
+func DeserializeTransaction(data []byte) (*Transaction, error) {
+	var tx Transaction
+
+	dec := gob.NewDecoder(bytes.NewReader(data))
+	if err := dec.Decode(&tx); err != nil {
+		return nil, err
+	}
+
+	return &tx, nil
+}
+
+// Hash 方法将transcation序列化后的hash作为当前交易的ID
+// 交易的ID在签名之前计算 因此计算时忽略所有输入的签名 签名后ID保持不变
+func (tx *Transaction) Hash() []byte {
+	var hash [32]byte
+
+	txCopy := *tx
+	txCopy.ID = []byte{}
+	txCopy.Vin = make([]TXInput, len(tx.Vin))
+	for i, vin := range tx.Vin {
+		txCopy.Vin[i] = TXInput{vin.Txid, vin.Vout, nil, vin.PubKey}
+	}
+
+	hash = sha256.Sum256(txCopy.Serialize())
+
+	return hash[:]
+}
+
+// PrevOutputFetcher 返回交易输入引用的输出 不存在时返回nil
+type PrevOutputFetcher func(in TXInput) *TXOutput
+
+// 根据交易ID索引的前序交易构造PrevOutputFetcher
+func prevTXsFetcher(prevTXs map[string]Transaction) PrevOutputFetcher {
+	return func(in TXInput) *TXOutput {
+		prevTX, ok := prevTXs[hex.EncodeToString(in.Txid)]
+		if !ok || prevTX.ID == nil || in.Vout < 0 || in.Vout >= len(prevTX.Vout) {
+			return nil
+		}
+
+		return &prevTX.Vout[in.Vout]
+	}
+}
+
+// Sign 对交易的每一个输入进行签名 prevTXs中缺少输入引用的交易时返回ErrUnknownInput
+func (tx *Transaction) Sign(privKey ecdsa.PrivateKey, prevTXs map[string]Transaction) error {
+	return tx.SignInputs(privKey, prevTXsFetcher(prevTXs))
+}
+
+// SignInputs 与Sign相同 但通过fetch获取输入引用的输出
+func (tx *Transaction) SignInputs(privKey ecdsa.PrivateKey, fetch PrevOutputFetcher) error {
+	return tx.signInputs(func(TXInput) (*ecdsa.PrivateKey, error) { return &privKey, nil }, fetch)
+}
+
+// SignInputsWithKeys 与SignInputs相同 但每个输入使用keys中以其公钥的hex编码为key的私钥签名
+// 输入可以来自不同的地址 缺少某个输入对应的私钥时返回错误
+func (tx *Transaction) SignInputsWithKeys(keys map[string]ecdsa.PrivateKey, fetch PrevOutputFetcher) error {
+	return tx.signInputs(func(in TXInput) (*ecdsa.PrivateKey, error) {
+		key, ok := keys[hex.EncodeToString(in.PubKey)]
+		if !ok {
+			return nil, fmt.Errorf("no private key for public key %x", in.PubKey)
+		}
+		return &key, nil
+	}, fetch)
+}
+
+// 使用keyFor返回的私钥对每一个输入进行签名
+func (tx *Transaction) signInputs(keyFor func(in TXInput) (*ecdsa.PrivateKey, error), fetch PrevOutputFetcher) error {
+	// 不需要对coinbase进行签名
+	if tx.IsCoinbase() {
+		return nil
+	}
+
+	// 需要对交易中输入的ID进行验证
+	prevOuts, err := fetchPrevOutputs(tx, fetch)
+	if err != nil {
+		return err
+	}
+
+	txCopy := tx.TrimmedCopy()
+
+	for inID := range txCopy.Vin {
+		// 双重保障 无特殊意义
+		txCopy.Vin[inID].Signature = nil
+		// 公钥被设置为引用输出的PubKeyHash
+		txCopy.Vin[inID].PubKey = prevOuts[inID].PubKeyHash
+		// 得到一个仅有当前交易有PubKey的hash
+		txCopy.ID = txCopy.Hash()
+		// 用完之后再将其置空
+		txCopy.Vin[inID].PubKey = nil
+
+		privKey, err := keyFor(tx.Vin[inID])
+		if err != nil {
+			return err
+		}
+		// 使用ecdsa对其进行签名
+		r, s, err := ecdsa.Sign(rand.Reader, privKey, txCopy.ID)
+		if err != nil {
+			return err
+		}
+		// r与s各自补齐为32字节 验证时才能从中间准确地将其分开
+		signature := append(padBytes(r.Bytes(), 32), padBytes(s.Bytes(), 32)...)
+		// 给其数字签名进行赋值
+		tx.Vin[inID].Signature = signature
+	}
+
+	return nil
+}
+
+// 取出交易每个输入引用的输出 任意一个不存在时返回ErrUnknownInput
+func fetchPrevOutputs(tx *Transaction, fetch PrevOutputFetcher) ([]*TXOutput, error) {
+	prevOuts := make([]*TXOutput, len(tx.Vin))
+	for i, vin := range tx.Vin {
+		prevOuts[i] = fetch(vin)
+		if prevOuts[i] == nil {
+			return nil, fmt.Errorf("%w: %x:%d", ErrUnknownInput, vin.Txid, vin.Vout)
+		}
+	}
+
+	return prevOuts, nil
+}
+
+// 在左侧补0直到长度为size
+func padBytes(b []byte, size int) []byte {
+	if len(b) >= size {
+		return b
+	}
+
+	return append(make([]byte, size-len(b)), b...)
+}
+
+// String 数据视化的函数
+func (tx Transaction) String() string {
+	var lines []string
+
+	lines = append(lines, fmt.Sprintf("--- Transaction %x:", tx.ID))
+
+	for i, input := range tx.Vin {
+		lines = append(lines, fmt.Sprintf("     Input %d:", i))
+		lines = append(lines, fmt.Sprintf("       TXID:      %x", input.Txid))
+		lines = append(lines, fmt.Sprintf("       Out:       %d", input.Vout))
+		lines = append(lines, fmt.Sprintf("       Signature: %x", input.Signature))
+		lines = append(lines, fmt.Sprintf("       PubKey:    %x", input.PubKey))
+	}
+
+	for i, output := range tx.Vout {
+		lines = append(lines, fmt.Sprintf("     Output %d:", i))
+		lines = append(lines, fmt.Sprintf("       Value:  %d", output.Value))
+		lines = append(lines, fmt.Sprintf("       Script: %x", output.PubKeyHash))
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+// TrimmedCopy 仿照bitcoin实现，深拷贝一个裁剪过的副本 用于签名
+func (tx *Transaction) TrimmedCopy() Transaction {
+	var inputs []TXInput
+	var outputs []TXOutput
+
+	// 将公钥及签名省略
+	for _, vin := range tx.Vin {
+		inputs = append(inputs, TXInput{vin.Txid, vin.Vout, nil, nil})
+	}
+
+	for _, vout := range tx.Vout {
+		outputs = append(outputs, TXOutput{vout.Value, vout.PubKeyHash})
+	}
+
+	txCopy := Transaction{tx.ID, inputs, outputs}
+
+	return txCopy
+}
+
+// Verify 验证交易每个输入的签名 签名不合法时返回ErrInvalidSignature
+func (tx *Transaction) Verify(prevTXs map[string]Transaction) error {
+	return tx.VerifyInputs(prevTXsFetcher(prevTXs))
+}
+
+// VerifyInputs 与Verify相同 但通过fetch获取输入引用的输出
+func (tx *Transaction) VerifyInputs(fetch PrevOutputFetcher) error {
+	if tx.IsCoinbase() {
+		return nil
+	}
+
+	// 验证交易输入的合法性
+	prevOuts, err := fetchPrevOutputs(tx, fetch)
+	if err != nil {
+		return err
+	}
+
+	txCopy := tx.TrimmedCopy()
+	curve := elliptic.P256()
+
+	// 部分深拷贝的txCopy只存储了来源的txID与Vout
+	for inID, vin := range tx.Vin {
+		prevOut := prevOuts[inID]
+		// 输入中携带的公钥必须与被引用输出锁定的公钥hash一致
+		if !vin.UseKey(prevOut.PubKeyHash) {
+			return fmt.Errorf("%w: input %d of %x is not unlocked by its public key", ErrInvalidSignature, inID, tx.ID)
+		}
+		txCopy.Vin[inID].Signature = nil
+		txCopy.Vin[inID].PubKey = prevOut.PubKeyHash
+		txCopy.ID = txCopy.Hash()
+		txCopy.Vin[inID].PubKey = nil
+
+		r := big.Int{}
+		s := big.Int{}
+
+		// 获取Vin中签名的长度
+		// 将原始的公钥，由于使用的是椭圆曲线，故其公钥为椭圆曲线中的坐标，带入验证即可
+		sigLen := len(vin.Signature)
+		r.SetBytes(vin.Signature[:(sigLen / 2)])
+		s.SetBytes(vin.Signature[(sigLen / 2):])
+
+		x := big.Int{}
+		y := big.Int{}
+		keyLen := len(vin.PubKey)
+		x.SetBytes(vin.PubKey[:(keyLen / 2)])
+		y.SetBytes(vin.PubKey[(keyLen / 2):])
+
+		rawPubKey := ecdsa.PublicKey{Curve: curve, X: &x, Y: &y}
+		if ecdsa.Verify(&rawPubKey, txCopy.ID, &r, &s) == false {
+			return fmt.Errorf("%w: input %d of %x", ErrInvalidSignature, inID, tx.ID)
+		}
+	}
+
+	return nil
+}
+
+// NewCoinbaseTX 创建一个铸币交易 在公链区块链中 铸币交易是不可取代的一种交易
+// 铸币交易向to支付reward 即高度为height的区块的出块奖励与区块中其余交易的手续费之和
+// 区块高度被写在输入数据的开头 使不同区块的铸币交易具有不同的ID version为所在网络的地址版本字节
+func NewCoinbaseTX(to string, version byte, data string, height int64, reward int) (*Transaction, error) {
+	pubKeyHash, err := wallet.PubKeyHashFromAddress(to, version)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewCoinbaseTXToPubKeyHash(pubKeyHash, data, height, reward)
+}
+
+// NewCoinbaseTXToPubKeyHash 与NewCoinbaseTX相同 但输出直接由公钥hash锁定
+// 用于创世块这类输出不属于任何钱包地址的铸币交易
+func NewCoinbaseTXToPubKeyHash(pubKeyHash []byte, data string, height int64, reward int) (*Transaction, error) {
+	if reward < 0 {
+		return nil, fmt.Errorf("negative coinbase reward %d", reward)
+	}
+
+	// 如果没有指定铸币交易的data
+	// 则默认将铸币交易的data设置为奖励 to
+	if data == "" {
+		randData := make([]byte, 20)
+		_, err := rand.Read(randData)
+		if err != nil {
+			return nil, err
+		}
+
+		data = fmt.Sprintf("%x", randData)
+	}
+
+	heightData := make([]byte, 8)
+	binary.BigEndian.PutUint64(heightData, uint64(height))
+
+	txin := TXInput{[]byte{}, -1, nil, append(heightData, data...)}
+	txout := TXOutput{reward, pubKeyHash}
+	tx := Transaction{nil, []TXInput{txin}, []TXOutput{txout}}
+	tx.ID = tx.Hash()
+
+	return &tx, nil
+}
+
+// CoinbaseHeight 返回铸币交易中记录的区块高度 交易不是铸币交易或没有记录高度时返回false
+func (tx Transaction) CoinbaseHeight() (int64, bool) {
+	if !tx.IsCoinbase() || len(tx.Vin[0].PubKey) < 8 {
+		return 0, false
+	}
+
+	return int64(binary.BigEndian.Uint64(tx.Vin[0].PubKey)), true
+}
@@ -1,7 +1,12 @@
-package main
+package core
 
-import "bytes"
+import (
+	"bytes"
 
+	"blockchain/wallet"
+)
+
+// TXInput 交易的输入 引用之前某笔交易的一个输出
 type TXInput struct {
 	// 存储其来源的Txid
 	Txid []byte
@@ -13,9 +18,9 @@ type TXInput struct {
 	PubKey []byte
 }
 
-// 检验提供的公钥hash是否用当前交易的公钥生成
+// UseKey 检验提供的公钥hash是否用当前交易的公钥生成
 func (in *TXInput) UseKey(pubKeyHash []byte) bool {
-	lockingHash := HashPubKey(in.PubKey)
+	lockingHash := wallet.HashPubKey(in.PubKey)
 
 	return bytes.Compare(lockingHash, pubKeyHash) == 0
 }
@@ -0,0 +1,74 @@
+package core
+
+import (
+	"bytes"
+	"encoding/gob"
+	"sort"
+
+	"blockchain/encoding/base58"
+)
+
+// TXOutput 交易的输出 由公钥hash锁定
+type TXOutput struct {
+	Value      int
+	PubKeyHash []byte
+}
+
+// Lock 使用公钥hash对output签名
+func (out *TXOutput) Lock(address []byte) {
+	// 地址解码后中间部分即为公钥hash
+	pubKeyHash := base58.Decode(address)
+	pubKeyHash = pubKeyHash[1 : len(pubKeyHash)-4]
+	// 使用公钥hash锁定输出
+	out.PubKeyHash = pubKeyHash
+}
+
+// IsLockedWithKey 检查当前的TXOutput是否是由当前的公钥锁定的
+func (out *TXOutput) IsLockedWithKey(pubKeyHash []byte) bool {
+	return bytes.Compare(out.PubKeyHash, pubKeyHash) == 0
+}
+
+// NewTXOutput 新建一个Output transcation
+func NewTXOutput(value int, address string) *TXOutput {
+	txo := &TXOutput{value, nil}
+	// 通过lock方法填充公钥hash
+	txo.Lock([]byte(address))
+
+	return txo
+}
+
+// TXOutputs 一笔交易中尚未花费的输出集合 用于UTXO集的存储
+// 以输出在交易中的原始索引为key 部分输出被花费后其余输出的索引保持不变
+type TXOutputs struct {
+	Outputs map[int]TXOutput
+	// Coinbase 输出是否由铸币交易创建
+	Coinbase bool
+	// Height 创建输出的交易所在区块的高度
+	Height int64
+}
+
+// Indexes 按从小到大的顺序返回所有输出的索引
+func (outs TXOutputs) Indexes() []int {
+	indexes := make([]int, 0, len(outs.Outputs))
+	for index := range outs.Outputs {
+		indexes = append(indexes, index)
+	}
+	sort.Ints(indexes)
+
+	return indexes
+}
+
+// Serialize 使用gob对输出集合进行序列化
+func (outs TXOutputs) Serialize() []byte {
+	return gobEncode(outs)
+}
+
+// DeserializeOutputs 反序列化输出集合
+func DeserializeOutputs(data []byte) (TXOutputs, error) {
+	var outputs TXOutputs
+
+	dec := gob.NewDecoder(bytes.NewReader(data))
+	err := dec.Decode(&outputs)
+
+	return outputs, err
+}
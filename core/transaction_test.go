@@ -0,0 +1,59 @@
+package core
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestAddValue(t *testing.T) {
+	maxInt := int(^uint(0) >> 1)
+
+	tests := []struct {
+		total, value, max int
+		want              int
+		wantErr           bool
+	}{
+		{total: 1, value: 2, max: 10, want: 3},
+		{total: 4, value: 6, max: 10, want: 10},
+		{total: 5, value: 6, max: 10, wantErr: true},
+		{total: 0, value: -1, max: 10, wantErr: true},
+		{total: -1, value: 1, max: 10, wantErr: true},
+		{total: maxInt/2 + 1, value: maxInt/2 + 1, max: maxInt, wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := AddValue(tt.total, tt.value, tt.max)
+		if tt.wantErr {
+			if !errors.Is(err, ErrValueOutOfRange) {
+				t.Errorf("AddValue(%d, %d, %d) error = %v, want %v", tt.total, tt.value, tt.max, err, ErrValueOutOfRange)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("AddValue(%d, %d, %d) = %d, %v, want %d", tt.total, tt.value, tt.max, got, err, tt.want)
+		}
+	}
+}
+
+func TestFeeRejectsOverflowingValues(t *testing.T) {
+	half := int(^uint(0)>>1)/2 + 1
+	prevOut := &TXOutput{Value: half}
+	fetch := func(TXInput) *TXOutput { return prevOut }
+
+	// 输入之和溢出
+	tx := &Transaction{
+		Vin:  []TXInput{{Txid: []byte{1}, Vout: 0}, {Txid: []byte{1}, Vout: 1}},
+		Vout: []TXOutput{{Value: 1}},
+	}
+	if _, err := tx.Fee(fetch, 1000000); !errors.Is(err, ErrValueOutOfRange) {
+		t.Fatalf("Fee() error = %v, want %v", err, ErrValueOutOfRange)
+	}
+
+	// 输出之和溢出
+	tx = &Transaction{
+		Vin:  []TXInput{{Txid: []byte{1}, Vout: 0}},
+		Vout: []TXOutput{{Value: half}, {Value: half}},
+	}
+	if _, err := tx.Fee(fetch, 1000000); !errors.Is(err, ErrValueOutOfRange) {
+		t.Fatalf("Fee() error = %v, want %v", err, ErrValueOutOfRange)
+	}
+}
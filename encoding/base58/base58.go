@@ -1,4 +1,5 @@
-package main
+// Package base58 实现了比特币风格的base58编码 用于生成与解析钱包地址
+package base58
 
 import (
 	"bytes"
@@ -8,7 +9,8 @@ import (
 // base58编码时使用的特殊字典
 var b58Alphabet = []byte("123456789ABCDEFGHJKLMNPQRSTUVWXYZabcdefghijkmnopqrstuvwxyz")
 
-func Base58Encode(input []byte) []byte {
+// Encode 将字节数组编码为base58字符
+func Encode(input []byte) []byte {
 	var result []byte
 
 	x := big.NewInt(0).SetBytes(input)
@@ -22,27 +24,29 @@ func Base58Encode(input []byte) []byte {
 		result = append(result, b58Alphabet[mod.Int64()])
 	}
 
-	ReverseBytes(result)
+	reverseBytes(result)
 
-	for b := range input {
-		if b == 0x00 {
-			return append([]byte{b58Alphabet[0]}, result...)
-		} else {
+	// 开头的每个0字节编码为一个字典的第一个字符
+	for _, b := range input {
+		if b != 0x00 {
 			break
 		}
+		result = append([]byte{b58Alphabet[0]}, result...)
 	}
 
 	return result
 }
 
-func Base58Decode(input []byte) []byte {
+// Decode 将base58字符解码为原始的字节数组
+func Decode(input []byte) []byte {
 	result := big.NewInt(0)
 	zeroBytes := 0
 
-	for b := range input {
-		if b == 0x00 {
-			zeroBytes++
+	for _, b := range input {
+		if b != b58Alphabet[0] {
+			break
 		}
+		zeroBytes++
 	}
 
 	payload := input[zeroBytes:]
@@ -57,3 +61,10 @@ func Base58Decode(input []byte) []byte {
 
 	return decoded
 }
+
+// 字符串反转
+func reverseBytes(data []byte) {
+	for i, j := 0, len(data)-1; i < j; i, j = i+1, j-1 {
+		data[i], data[j] = data[j], data[i]
+	}
+}
@@ -1,6 +1,6 @@
 module blockchain
 
-go 1.15
+go 1.16
 
 require (
 	github.com/boltdb/bolt v1.3.1
@@ -0,0 +1,133 @@
+// Package chaintest 提供测试中构造区块链、交易与区块的辅助函数
+//
+// 区块链保存在内存中 区块使用链的规则要求的难度并完成工作量证明
+// 同一高度的铸币交易向同一个地址支付相同金额时交易ID相同 分叉上的区块需要使用不同的地址
+package chaintest
+
+import (
+	"testing"
+
+	"blockchain/core"
+	"blockchain/core/chain"
+	"blockchain/pow"
+	"blockchain/storage"
+	"blockchain/wallet"
+)
+
+// NewChain 在内存中创建一条使用params的区块链 params为nil时使用回归测试网络
+func NewChain(t *testing.T, params *chain.ChainParams) *chain.Blockchain {
+	t.Helper()
+
+	if params == nil {
+		params = &chain.RegTestParams
+	}
+	bc, err := chain.InitBlockChain(storage.NewMemory(), params)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return bc
+}
+
+// NewWallet 创建一个新的钱包
+func NewWallet(t *testing.T) *wallet.Wallet {
+	t.Helper()
+
+	w, err := wallet.NewWallet()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return w
+}
+
+// Coinbase 高度为height的铸币交易 依次向pubKeyHash支付values中的金额
+func Coinbase(t *testing.T, height int64, pubKeyHash []byte, values ...int) *core.Transaction {
+	t.Helper()
+
+	tx, err := core.NewCoinbaseTXToPubKeyHash(pubKeyHash, "", height, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx.Vout = nil
+	for _, value := range values {
+		tx.Vout = append(tx.Vout, core.TXOutput{Value: value, PubKeyHash: pubKeyHash})
+	}
+	tx.ID = tx.Hash()
+
+	return tx
+}
+
+// Spend 花费prevTx的第vout个输出 依次向w支付values中的金额
+func Spend(t *testing.T, w *wallet.Wallet, prevTx *core.Transaction, vout int, values ...int) *core.Transaction {
+	t.Helper()
+
+	pubKeyHash := wallet.HashPubKey(w.PublicKey)
+	tx := &core.Transaction{Vin: []core.TXInput{{Txid: prevTx.ID, Vout: vout, PubKey: w.PublicKey}}}
+	for _, value := range values {
+		tx.Vout = append(tx.Vout, core.TXOutput{Value: value, PubKeyHash: pubKeyHash})
+	}
+	tx.ID = tx.Hash()
+
+	err := tx.SignInputs(w.PrivateKey, func(in core.TXInput) *core.TXOutput {
+		return &prevTx.Vout[in.Vout]
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return tx
+}
+
+// NewBlock 构造接在主链末端的区块并完成工作量证明 不验证其中的交易
+func NewBlock(t *testing.T, bc *chain.Blockchain, txs ...*core.Transaction) *core.Block {
+	t.Helper()
+
+	return NewBlockOn(t, bc, bc.Tip(), txs...)
+}
+
+// NewBlockOn 构造接在prevHash之后的区块并完成工作量证明 prevHash可以是侧链上的区块
+func NewBlockOn(t *testing.T, bc *chain.Blockchain, prevHash []byte, txs ...*core.Transaction) *core.Block {
+	t.Helper()
+
+	height, err := bc.BlockHeight(prevHash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bits, err := bc.RequiredBits(prevHash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	medianTime, err := bc.MedianTimePast(prevHash)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	block := core.NewBlock(txs, prevHash, height+1, bits)
+	if block.Timestamp <= medianTime {
+		block.Timestamp = medianTime + 1
+	}
+	block.Nonce, block.Hash = pow.NewProofOfWork(block).Run()
+
+	return block
+}
+
+// MineBlocks 在主链末端挖出n个只包含铸币交易的区块 出块奖励支付给pubKeyHash 返回各区块的铸币交易
+func MineBlocks(t *testing.T, bc *chain.Blockchain, n int, pubKeyHash []byte) []*core.Transaction {
+	t.Helper()
+
+	var coinbases []*core.Transaction
+	for i := 0; i < n; i++ {
+		height, err := bc.BlockHeight(bc.Tip())
+		if err != nil {
+			t.Fatal(err)
+		}
+		coinbase := Coinbase(t, height+1, pubKeyHash, bc.Params().BlockSubsidy(height+1))
+		if _, err := bc.AddBlock(NewBlock(t, bc, coinbase)); err != nil {
+			t.Fatal(err)
+		}
+		coinbases = append(coinbases, coinbase)
+	}
+
+	return coinbases
+}
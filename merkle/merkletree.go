@@ -1,18 +1,21 @@
-package main
+// Package merkle 实现了用于计算区块交易摘要的默克尔树
+package merkle
 
 import "crypto/sha256"
 
+// MerkleNode 是默克尔树中的一个节点 Data为其子树的hash
 type MerkleNode struct {
 	Left  *MerkleNode
 	Right *MerkleNode
 	Data  []byte
 }
 
+// MerkleTree 仅保存默克尔树的根节点
 type MerkleTree struct {
 	RootNode *MerkleNode
 }
 
-// 创建单棵merkleTree的逻辑
+// NewMerkleNode 创建单棵merkleTree的逻辑
 func NewMerkleNode(left, right *MerkleNode, data []byte) *MerkleNode {
 	mNode := MerkleNode{}
 
@@ -31,6 +34,7 @@ func NewMerkleNode(left, right *MerkleNode, data []byte) *MerkleNode {
 	return &mNode
 }
 
+// NewMerkleTree 根据传入的数据构造一棵默克尔树 data不能为空
 func NewMerkleTree(data [][]byte) *MerkleTree {
 	var nodes []MerkleNode
 
@@ -45,7 +49,12 @@ func NewMerkleTree(data [][]byte) *MerkleTree {
 		nodes = append(nodes, *node)
 	}
 
-	for i := 0; i < len(data)/2; i++ {
+	// 逐层向上合并 直到只剩下根节点 每一层的节点数为奇数时同样复制最后一个节点
+	for len(nodes) > 1 {
+		if len(nodes)%2 != 0 {
+			nodes = append(nodes, nodes[len(nodes)-1])
+		}
+
 		var newLevel []MerkleNode
 
 		for j := 0; j < len(nodes); j += 2 {
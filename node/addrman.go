@@ -0,0 +1,287 @@
+package node
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"net"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// 地址簿最多保存的地址数 超过时优先淘汰从未连接成功且最久没有见到的地址
+	maxKnownAddrs = 2000
+	// 连接失败后重试的初始间隔 每次失败后加倍
+	retryBaseDelay = 5 * time.Second
+	// 重试间隔的上限
+	retryMaxDelay = time.Hour
+	// 连续失败这么多次且从未连接成功的地址被移出地址簿
+	maxFailedAttempts = 10
+)
+
+// KnownAddress 地址簿中的一个节点监听地址
+type KnownAddress struct {
+	Addr string `json:"addr"`
+	// LastSeen 最近一次从addr消息或连接得知该地址的时间
+	LastSeen time.Time `json:"lastseen"`
+	// LastSuccess 最近一次与该地址完成握手的时间 从未成功时为零值
+	LastSuccess time.Time `json:"lastsuccess"`
+	// LastAttempt 最近一次尝试连接的时间
+	LastAttempt time.Time `json:"lastattempt"`
+	// Attempts 最近一次成功之后连续尝试的次数
+	Attempts int `json:"attempts"`
+}
+
+// 下一次可以尝试连接的时间 连续失败的次数越多间隔越长
+func (ka *KnownAddress) retryAt() time.Time {
+	if ka.Attempts == 0 {
+		return ka.LastAttempt
+	}
+
+	delay := retryBaseDelay
+	for i := 1; i < ka.Attempts && delay < retryMaxDelay; i++ {
+		delay *= 2
+	}
+	if delay > retryMaxDelay {
+		delay = retryMaxDelay
+	}
+
+	return ka.LastAttempt.Add(delay)
+}
+
+// AddrManager 已知节点地址的地址簿 保存在JSON文件中
+// 地址来自配置的种子节点、addr消息与对方在握手时声明的监听端口
+type AddrManager struct {
+	path string
+
+	mu    sync.Mutex
+	addrs map[string]*KnownAddress
+}
+
+// NewAddrManager 读取path中的地址簿 文件不存在时地址簿为空 path为空时地址簿只保存在内存中
+func NewAddrManager(path string) (*AddrManager, error) {
+	a := &AddrManager{path: path, addrs: make(map[string]*KnownAddress)}
+	if path == "" {
+		return a, nil
+	}
+
+	content, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return a, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var addrs []*KnownAddress
+	if err := json.Unmarshal(content, &addrs); err != nil {
+		return nil, fmt.Errorf("address book %s: %v", path, err)
+	}
+	for _, ka := range addrs {
+		a.addrs[ka.Addr] = ka
+	}
+
+	return a, nil
+}
+
+// Add 记录addrs中的地址 返回其中之前不在地址簿中的地址
+func (a *AddrManager) Add(addrs []string) []string {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	now := time.Now()
+	var fresh []string
+	for _, addr := range addrs {
+		if _, _, err := net.SplitHostPort(addr); err != nil {
+			continue
+		}
+		if ka := a.addrs[addr]; ka != nil {
+			ka.LastSeen = now
+			continue
+		}
+
+		a.addrs[addr] = &KnownAddress{Addr: addr, LastSeen: now}
+		fresh = append(fresh, addr)
+	}
+	a.evict()
+
+	return fresh
+}
+
+// Remove 将addr移出地址簿 用于连接到自己的地址
+func (a *AddrManager) Remove(addr string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	delete(a.addrs, addr)
+}
+
+// Attempt 记录一次对addr的连接尝试 在Good之前的每次尝试都被视为失败
+func (a *AddrManager) Attempt(addr string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	ka := a.addrs[addr]
+	if ka == nil {
+		ka = &KnownAddress{Addr: addr, LastSeen: time.Now()}
+		a.addrs[addr] = ka
+	}
+	ka.LastAttempt = time.Now()
+	ka.Attempts++
+
+	// 从未成功且屡次失败的地址大概已经不存在
+	if ka.LastSuccess.IsZero() && ka.Attempts >= maxFailedAttempts {
+		delete(a.addrs, addr)
+	}
+}
+
+// Good 记录与addr成功完成了握手
+func (a *AddrManager) Good(addr string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	ka := a.addrs[addr]
+	if ka == nil {
+		ka = &KnownAddress{Addr: addr}
+		a.addrs[addr] = ka
+	}
+	now := time.Now()
+	ka.LastSeen = now
+	ka.LastSuccess = now
+	ka.Attempts = 0
+}
+
+// CanRetry addr不在地址簿中或已经过了重试的等待时间
+func (a *AddrManager) CanRetry(addr string) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	ka := a.addrs[addr]
+	return ka == nil || !time.Now().Before(ka.retryAt())
+}
+
+// Select 随机选择一个可以连接的地址 skip返回true的地址被跳过
+// 优先选择与usedGroups中的网段都不同的地址 使主动连接分布在不同的网络中 没有可选的地址时返回空字符串
+func (a *AddrManager) Select(usedGroups map[string]bool, skip func(addr string) bool) string {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	now := time.Now()
+	var diverse, others []string
+	for addr, ka := range a.addrs {
+		if now.Before(ka.retryAt()) || skip(addr) {
+			continue
+		}
+		if usedGroups[addrGroup(addr)] {
+			others = append(others, addr)
+		} else {
+			diverse = append(diverse, addr)
+		}
+	}
+
+	candidates := diverse
+	if len(candidates) == 0 {
+		candidates = others
+	}
+	if len(candidates) == 0 {
+		return ""
+	}
+
+	return candidates[rand.Intn(len(candidates))]
+}
+
+// Addresses 返回至多max个最近见到的地址 用于发送给其他节点
+func (a *AddrManager) Addresses(max int) []string {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	known := a.sorted()
+	var addrs []string
+	for _, ka := range known {
+		if len(addrs) == max {
+			break
+		}
+		addrs = append(addrs, ka.Addr)
+	}
+
+	return addrs
+}
+
+// Len 返回地址簿中的地址数
+func (a *AddrManager) Len() int {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	return len(a.addrs)
+}
+
+// Save 将地址簿写入文件
+func (a *AddrManager) Save() error {
+	if a.path == "" {
+		return nil
+	}
+
+	a.mu.Lock()
+	content, err := json.MarshalIndent(a.sorted(), "", "  ")
+	a.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(a.path, content, 0600)
+}
+
+// 按最近见到的时间从新到旧排序的地址 调用者需持有a.mu
+func (a *AddrManager) sorted() []*KnownAddress {
+	known := make([]*KnownAddress, 0, len(a.addrs))
+	for _, ka := range a.addrs {
+		known = append(known, ka)
+	}
+	sort.Slice(known, func(i, j int) bool {
+		if !known[i].LastSeen.Equal(known[j].LastSeen) {
+			return known[i].LastSeen.After(known[j].LastSeen)
+		}
+		return known[i].Addr < known[j].Addr
+	})
+
+	return known
+}
+
+// 地址数超过上限时淘汰地址 调用者需持有a.mu
+// 曾经连接成功的地址最后才被淘汰 否则大量伪造的addr消息可以挤掉所有可用的地址
+func (a *AddrManager) evict() {
+	if len(a.addrs) <= maxKnownAddrs {
+		return
+	}
+
+	known := a.sorted()
+	sort.SliceStable(known, func(i, j int) bool {
+		return !known[i].LastSuccess.IsZero() && known[j].LastSuccess.IsZero()
+	})
+	for _, ka := range known[maxKnownAddrs:] {
+		delete(a.addrs, ka.Addr)
+	}
+}
+
+// 地址所在的网段: IPv4取前16位 IPv6取前32位 域名取其本身
+// 同一网段的节点可能由同一方控制 主动连接应尽量分布在不同的网段
+func addrGroup(addr string) string {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		host = addr
+	}
+
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return host
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		return ip4.Mask(net.CIDRMask(16, 32)).String()
+	}
+
+	return ip.Mask(net.CIDRMask(32, 128)).String()
+}
@@ -0,0 +1,230 @@
+package node
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"blockchain/core/chain"
+)
+
+func TestAddrManagerPersistence(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "peers.json")
+	a, err := NewAddrManager(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a.Len() != 0 {
+		t.Fatalf("new address book has %d addresses", a.Len())
+	}
+
+	a.Add([]string{"10.0.0.1:23000", "10.0.0.2:23000", "not an address"})
+	a.Attempt("10.0.0.1:23000")
+	a.Attempt("10.0.0.2:23000")
+	a.Good("10.0.0.2:23000")
+	if err := a.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	reloaded, err := NewAddrManager(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reloaded.Len() != 2 {
+		t.Fatalf("reloaded address book has %d addresses, want 2", reloaded.Len())
+	}
+	for addr, want := range a.addrs {
+		got := reloaded.addrs[addr]
+		if got == nil {
+			t.Fatalf("%s is missing after reloading", addr)
+		}
+		if got.Attempts != want.Attempts || !got.LastSuccess.Equal(want.LastSuccess) ||
+			!got.LastAttempt.Equal(want.LastAttempt) || !got.LastSeen.Equal(want.LastSeen) {
+			t.Fatalf("%s after reloading = %+v, want %+v", addr, got, want)
+		}
+	}
+	// 重新读取后仍然按退避的间隔等待重试
+	if reloaded.CanRetry("10.0.0.1:23000") {
+		t.Fatal("a failed address can be retried immediately after reloading")
+	}
+
+	if err := ioutil.WriteFile(path, []byte("["), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewAddrManager(path); err == nil {
+		t.Fatal("NewAddrManager() of a malformed file succeeded")
+	}
+}
+
+func TestAddrManagerSelectDiverse(t *testing.T) {
+	a, err := NewAddrManager("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.Add([]string{"10.1.0.1:23000", "10.1.0.2:23000", "10.1.5.5:23000", "10.2.0.1:23000", "10.3.0.1:23000"})
+	none := func(string) bool { return false }
+
+	used := map[string]bool{addrGroup("10.1.0.1:23000"): true}
+	seen := make(map[string]bool)
+	for i := 0; i < 100; i++ {
+		addr := a.Select(used, none)
+		if strings.HasPrefix(addr, "10.1.") {
+			t.Fatalf("Select() = %s from a used group while other groups are available", addr)
+		}
+		seen[addr] = true
+	}
+	if !seen["10.2.0.1:23000"] || !seen["10.3.0.1:23000"] {
+		t.Fatalf("Select() chose only %v, want both unused groups", seen)
+	}
+
+	// 其他网段的地址都被跳过时 退而选择已经使用的网段
+	skipOthers := func(addr string) bool { return !strings.HasPrefix(addr, "10.1.") }
+	if addr := a.Select(used, skipOthers); !strings.HasPrefix(addr, "10.1.") {
+		t.Fatalf("Select() = %q, want an address from the used group", addr)
+	}
+
+	if addr := a.Select(nil, func(string) bool { return true }); addr != "" {
+		t.Fatalf("Select() with every address skipped = %q, want none", addr)
+	}
+}
+
+func TestAddrGroup(t *testing.T) {
+	tests := []struct {
+		a, b string
+		same bool
+	}{
+		{"10.1.2.3:1", "10.1.200.4:2", true},
+		{"10.1.2.3:1", "10.2.2.3:1", false},
+		{"[2001:db8:1::1]:1", "[2001:db8:ffff::1]:1", true},
+		{"[2001:db8:1::1]:1", "[2001:db9:1::1]:1", false},
+		{"seed.example.com:1", "seed.example.com:2", true},
+		{"seed.example.com:1", "other.example.com:1", false},
+	}
+
+	for _, tt := range tests {
+		if same := addrGroup(tt.a) == addrGroup(tt.b); same != tt.same {
+			t.Errorf("addrGroup(%s) == addrGroup(%s) is %v, want %v", tt.a, tt.b, same, tt.same)
+		}
+	}
+}
+
+func TestRetryBackoff(t *testing.T) {
+	last := time.Now()
+	tests := []struct {
+		attempts int
+		delay    time.Duration
+	}{
+		{0, 0},
+		{1, retryBaseDelay},
+		{2, 2 * retryBaseDelay},
+		{3, 4 * retryBaseDelay},
+		{4, 8 * retryBaseDelay},
+		{20, retryMaxDelay},
+		{1000, retryMaxDelay},
+	}
+
+	for _, tt := range tests {
+		ka := &KnownAddress{LastAttempt: last, Attempts: tt.attempts}
+		if got := ka.retryAt().Sub(last); got != tt.delay {
+			t.Errorf("retry delay after %d attempts = %v, want %v", tt.attempts, got, tt.delay)
+		}
+	}
+
+	a, err := NewAddrManager("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := "10.0.0.1:23000"
+	a.Add([]string{addr})
+	if !a.CanRetry(addr) {
+		t.Fatal("a new address cannot be tried")
+	}
+	a.Attempt(addr)
+	if a.CanRetry(addr) || a.Select(nil, func(string) bool { return false }) != "" {
+		t.Fatal("a failed address can be retried immediately")
+	}
+	a.Good(addr)
+	if !a.CanRetry(addr) {
+		t.Fatal("an address cannot be retried after a successful connection")
+	}
+
+	// 从未成功且屡次失败的地址被移出地址簿
+	a.Add([]string{"10.0.0.2:23000"})
+	for i := 0; i < maxFailedAttempts; i++ {
+		a.Attempt("10.0.0.2:23000")
+	}
+	if a.Len() != 1 {
+		t.Fatalf("address book has %d addresses after repeated failures, want 1", a.Len())
+	}
+}
+
+// 节点连接配置的-addnode节点与种子节点 并将成功连接的种子节点保存到地址簿
+func TestConnectsToAddNodesAndSeeds(t *testing.T) {
+	params := &chain.RegTestParams
+
+	seed := newTestNode(t, params, Config{ListenAddr: "127.0.0.1:0"})
+	seedAddr := runTestNode(t, seed)
+
+	added := newTestNode(t, params, Config{AddNodes: []string{seedAddr}})
+	runTestNode(t, added)
+
+	peersFile := filepath.Join(t.TempDir(), "peers.json")
+	seeded := newTestNode(t, params, Config{Seeds: []string{seedAddr}, PeersFile: peersFile})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done := make(chan error, 1)
+	go func() {
+		done <- seeded.Run(ctx)
+	}()
+
+	waitFor(t, "connections to the added node and the seed", func() bool {
+		return len(seed.Peers()) == 2 && len(added.Peers()) == 1 && len(seeded.Peers()) == 1
+	})
+	for _, n := range []*Node{added, seeded} {
+		if p := n.Peers()[0]; p.Inbound() || p.ListenAddr() != seedAddr {
+			t.Fatalf("peer %s: inbound = %v, listen address %q, want an outbound connection to %s", p.Addr(), p.Inbound(), p.ListenAddr(), seedAddr)
+		}
+	}
+
+	cancel()
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	saved, err := NewAddrManager(peersFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ka := saved.addrs[seedAddr]; ka == nil || ka.LastSuccess.IsZero() || ka.Attempts != 0 {
+		t.Fatalf("saved seed address = %+v, want a successful connection", ka)
+	}
+}
+
+func TestEvictKeepsConnectedAddresses(t *testing.T) {
+	a, err := NewAddrManager("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	good := "10.0.0.1:23000"
+	a.Add([]string{good})
+	a.Attempt(good)
+	a.Good(good)
+
+	// 大量新地址使地址簿超过上限 最近见到的时间都晚于good
+	var flood []string
+	for i := 0; i <= maxKnownAddrs; i++ {
+		flood = append(flood, fmt.Sprintf("10.%d.%d.%d:23000", 1+i/65536, i/256%256, i%256))
+	}
+	time.Sleep(time.Millisecond)
+	a.Add(flood)
+
+	if a.Len() != maxKnownAddrs {
+		t.Fatalf("address book has %d addresses, want %d", a.Len(), maxKnownAddrs)
+	}
+	if a.addrs[good] == nil {
+		t.Fatal("an address that was connected to was evicted by new addresses")
+	}
+}
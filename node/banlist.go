@@ -0,0 +1,182 @@
+package node
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Ban 一个被封禁的节点IP
+type Ban struct {
+	Host   string    `json:"host"`
+	Until  time.Time `json:"until"`
+	Reason string    `json:"reason"`
+}
+
+// BanList 被封禁的节点IP及封禁的截止时间 保存在JSON文件中 过期的封禁自动失效
+// 文件被其他进程(如unban命令)修改后 在下一次Reload、Ban、Unban或List时重新读取
+// IsBanned只检查内存中的列表 不访问文件
+type BanList struct {
+	path string
+
+	mu sync.Mutex
+	// 上次读取或写入时文件的修改时间
+	modTime time.Time
+	bans    map[string]Ban
+}
+
+// OpenBanList 读取path中的封禁列表 文件不存在时列表为空 path为空时封禁只保存在内存中
+func OpenBanList(path string) (*BanList, error) {
+	b := &BanList{path: path, bans: make(map[string]Ban)}
+	if err := b.reload(); err != nil {
+		return nil, err
+	}
+
+	return b, nil
+}
+
+// Ban 封禁host直到duration之后 已经被封禁时延长到两者中较晚的时间
+func (b *BanList) Ban(host string, duration time.Duration, reason string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if err := b.reload(); err != nil {
+		return err
+	}
+
+	until := time.Now().Add(duration)
+	if old, ok := b.bans[host]; ok && old.Until.After(until) {
+		until = old.Until
+	}
+	b.bans[host] = Ban{Host: host, Until: until, Reason: reason}
+
+	return b.save()
+}
+
+// Unban 解除对host的封禁 返回host之前是否被封禁
+func (b *BanList) Unban(host string) (bool, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if err := b.reload(); err != nil {
+		return false, err
+	}
+
+	ban, ok := b.bans[host]
+	if !ok {
+		return false, nil
+	}
+	delete(b.bans, host)
+
+	return time.Now().Before(ban.Until), b.save()
+}
+
+// IsBanned host当前是否被封禁 只检查内存中的列表
+func (b *BanList) IsBanned(host string) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	ban, ok := b.bans[host]
+
+	return ok && time.Now().Before(ban.Until)
+}
+
+// List 返回所有尚未过期的封禁 按IP排序
+func (b *BanList) List() ([]Ban, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if err := b.reload(); err != nil {
+		return nil, err
+	}
+
+	return b.active(), nil
+}
+
+// 尚未过期的封禁 调用者需持有b.mu
+func (b *BanList) active() []Ban {
+	now := time.Now()
+	bans := make([]Ban, 0, len(b.bans))
+	for _, ban := range b.bans {
+		if now.Before(ban.Until) {
+			bans = append(bans, ban)
+		}
+	}
+	sort.Slice(bans, func(i, j int) bool {
+		return bans[i].Host < bans[j].Host
+	})
+
+	return bans
+}
+
+// Reload 文件在上次读取或写入之后被修改时重新读取 文件无法读取时保留内存中的列表
+func (b *BanList) Reload() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.reload()
+}
+
+// 文件在上次访问之后被修改时重新读取 调用者需持有b.mu
+func (b *BanList) reload() error {
+	if b.path == "" {
+		return nil
+	}
+
+	info, err := os.Stat(b.path)
+	if os.IsNotExist(err) {
+		b.bans = make(map[string]Ban)
+		b.modTime = time.Time{}
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if info.ModTime().Equal(b.modTime) {
+		return nil
+	}
+
+	content, err := ioutil.ReadFile(b.path)
+	if err != nil {
+		return err
+	}
+	var bans []Ban
+	if err := json.Unmarshal(content, &bans); err != nil {
+		return fmt.Errorf("ban list %s: %v", b.path, err)
+	}
+
+	b.bans = make(map[string]Ban, len(bans))
+	for _, ban := range bans {
+		b.bans[ban.Host] = ban
+	}
+	b.modTime = info.ModTime()
+
+	return nil
+}
+
+// 将尚未过期的封禁写入文件 调用者需持有b.mu
+func (b *BanList) save() error {
+	if b.path == "" {
+		return nil
+	}
+
+	content, err := json.MarshalIndent(b.active(), "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(b.path, content, 0600); err != nil {
+		return err
+	}
+
+	info, err := os.Stat(b.path)
+	if err != nil {
+		return err
+	}
+	b.modTime = info.ModTime()
+
+	return nil
+}
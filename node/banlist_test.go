@@ -0,0 +1,101 @@
+package node
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// 模拟其他进程改写封禁列表文件 修改时间与上次写入不同
+func writeBanFile(t *testing.T, path string, bans []Ban) {
+	t.Helper()
+
+	content, err := json.Marshal(bans)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path, content, 0600); err != nil {
+		t.Fatal(err)
+	}
+	modTime := time.Now().Add(time.Minute)
+	if err := os.Chtimes(path, modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestBanListReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "banlist.json")
+	b, err := OpenBanList(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Ban("10.0.0.1", time.Hour, "test"); err != nil {
+		t.Fatal(err)
+	}
+
+	// 其他进程解除了10.0.0.1的封禁并封禁了10.0.0.2
+	writeBanFile(t, path, []Ban{{Host: "10.0.0.2", Until: time.Now().Add(time.Hour)}})
+
+	// Reload之前只使用内存中的列表
+	if !b.IsBanned("10.0.0.1") || b.IsBanned("10.0.0.2") {
+		t.Fatal("IsBanned() read the ban list file")
+	}
+
+	if err := b.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if b.IsBanned("10.0.0.1") || !b.IsBanned("10.0.0.2") {
+		t.Fatal("Reload() did not pick up the modified ban list")
+	}
+
+	// 文件无法解析时保留内存中的列表
+	if err := ioutil.WriteFile(path, []byte("{"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(path, time.Now(), time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Reload(); err == nil {
+		t.Fatal("Reload() of a malformed file succeeded")
+	}
+	if !b.IsBanned("10.0.0.2") {
+		t.Fatal("Reload() of a malformed file dropped the bans in memory")
+	}
+}
+
+func TestBanListPersists(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "banlist.json")
+	b, err := OpenBanList(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Ban("10.0.0.1", time.Hour, "test"); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Ban("10.0.0.2", -time.Second, "expired"); err != nil {
+		t.Fatal(err)
+	}
+
+	b, err = OpenBanList(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bans, err := b.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(bans) != 1 || bans[0].Host != "10.0.0.1" || bans[0].Reason != "test" {
+		t.Fatalf("List() after reopening = %+v, want only the ban of 10.0.0.1", bans)
+	}
+
+	wasBanned, err := b.Unban("10.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !wasBanned || b.IsBanned("10.0.0.1") {
+		t.Fatalf("Unban() = %v, IsBanned() = %v after unbanning", wasBanned, b.IsBanned("10.0.0.1"))
+	}
+}
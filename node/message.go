@@ -0,0 +1,219 @@
+package node
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io"
+
+	"blockchain/core"
+)
+
+// 协议版本 握手时交换
+const protocolVersion = 1
+
+// 消息头: 4字节网络标识 12字节命令名 4字节负载长度 4字节负载校验和
+const (
+	commandLength = 12
+	headerLength  = 4 + commandLength + 4 + 4
+)
+
+// 消息的命令名
+const (
+	cmdVersion    = "version"
+	cmdVerack     = "verack"
+	cmdAddr       = "addr"
+	cmdInv        = "inv"
+	cmdGetData    = "getdata"
+	cmdGetBlocks  = "getblocks"
+	cmdGetHeaders = "getheaders"
+	cmdHeaders    = "headers"
+	cmdBlock      = "block"
+	cmdTx         = "tx"
+)
+
+// inv与getdata中条目的类型
+const (
+	invBlock = "block"
+	invTx    = "tx"
+)
+
+// 一次inv消息最多包含的条目数
+const maxInvItems = 500
+
+// 一次headers消息最多包含的区块头数
+const maxHeaders = 2000
+
+// getblocks与getheaders的locator最多包含的hash数
+const maxLocatorItems = 101
+
+// 负载中除条目之外的gob类型描述等开销的上限
+const payloadOverhead = 1024
+
+// 返回命令的负载允许的最大字节数 未知命令的负载必须为空
+// 超过限制时无法跳过该消息继续解析 连接被断开
+func maxPayloadSize(command string) int {
+	switch command {
+	case cmdVersion, cmdVerack:
+		return payloadOverhead
+	case cmdAddr:
+		return maxAddrItems*64 + payloadOverhead
+	case cmdInv, cmdGetData:
+		return maxInvItems*64 + payloadOverhead
+	case cmdGetBlocks, cmdGetHeaders:
+		return maxLocatorItems*64 + payloadOverhead
+	case cmdHeaders:
+		return maxHeaders*256 + payloadOverhead
+	case cmdBlock, cmdTx:
+		// 区块与交易都不能超过区块的大小上限
+		return core.MaxBlockSize + payloadOverhead
+	}
+
+	return 0
+}
+
+// ErrInvalidMessage 收到的消息不符合协议
+var ErrInvalidMessage = errors.New("invalid message")
+
+// 网络上传输的一个消息 负载为gob编码的消息结构
+type message struct {
+	Command string
+	Payload []byte
+}
+
+// version 握手时发送的节点信息
+type versionMsg struct {
+	Version    int
+	BestHeight int64
+	// 节点监听的端口 为0时节点不接受连接
+	ListenPort int
+	// 随机数 用于发现连接到自己的连接
+	Nonce uint64
+}
+
+// addr 已知的可以连接的节点地址
+type addrMsg struct {
+	Addresses []string
+}
+
+// inv 通告节点拥有的区块或交易
+type invMsg struct {
+	Type  string
+	Items [][]byte
+}
+
+// getdata 请求inv中通告的区块或交易
+type getDataMsg struct {
+	Type  string
+	Items [][]byte
+}
+
+// getblocks 请求locator之后的主链区块 至多到Stop为止
+type getBlocksMsg struct {
+	Locator [][]byte
+	Stop    []byte
+}
+
+// getheaders 请求locator之后的主链区块头 至多到Stop为止
+type getHeadersMsg struct {
+	Locator [][]byte
+	Stop    []byte
+}
+
+// headers 按高度顺序排列的连续区块头
+type headersMsg struct {
+	Headers []*core.BlockHeader
+}
+
+// block 使用Block.Serialize编码的区块
+type blockMsg struct {
+	Block []byte
+}
+
+// tx 使用Transaction.Serialize编码的交易
+type txMsg struct {
+	Transaction []byte
+}
+
+// 编码一个消息 payload为nil时负载为空
+func newMessage(command string, payload interface{}) (*message, error) {
+	msg := &message{Command: command}
+	if payload == nil {
+		return msg, nil
+	}
+
+	var buff bytes.Buffer
+	if err := gob.NewEncoder(&buff).Encode(payload); err != nil {
+		return nil, err
+	}
+	msg.Payload = buff.Bytes()
+
+	return msg, nil
+}
+
+// decode 将负载解码到v中
+func (m *message) decode(v interface{}) error {
+	if err := gob.NewDecoder(bytes.NewReader(m.Payload)).Decode(v); err != nil {
+		return fmt.Errorf("%w: %s: %v", ErrInvalidMessage, m.Command, err)
+	}
+
+	return nil
+}
+
+// 负载的校验和 即两次sha256的前4个字节
+func checksum(payload []byte) []byte {
+	first := sha256.Sum256(payload)
+	second := sha256.Sum256(first[:])
+
+	return second[:4]
+}
+
+// 将消息加上消息头写入w magic为网络标识 每个消息都以其开头
+func writeMessage(w io.Writer, magic [4]byte, msg *message) error {
+	if len(msg.Command) > commandLength {
+		return fmt.Errorf("%w: command %q is too long", ErrInvalidMessage, msg.Command)
+	}
+
+	header := make([]byte, headerLength)
+	copy(header, magic[:])
+	copy(header[4:], msg.Command)
+	binary.BigEndian.PutUint32(header[4+commandLength:], uint32(len(msg.Payload)))
+	copy(header[4+commandLength+4:], checksum(msg.Payload))
+
+	if _, err := w.Write(append(header, msg.Payload...)); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// 从r中读取一个完整的消息 并检查网络标识、长度与校验和
+// 网络标识与magic不同的连接不属于本协议或属于其他网络
+func readMessage(r io.Reader, magic [4]byte) (*message, error) {
+	header := make([]byte, headerLength)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, err
+	}
+
+	if !bytes.Equal(header[:4], magic[:]) {
+		return nil, fmt.Errorf("%w: unknown network magic %x", ErrInvalidMessage, header[:4])
+	}
+	command := string(bytes.TrimRight(header[4:4+commandLength], "\x00"))
+	length := binary.BigEndian.Uint32(header[4+commandLength:])
+	if max := maxPayloadSize(command); int64(length) > int64(max) {
+		return nil, misbehaving(scoreInvalid, fmt.Errorf("%w: %s payload of %d bytes exceeds %d", ErrInvalidMessage, command, length, max))
+	}
+
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(checksum(payload), header[4+commandLength+4:]) {
+		return nil, misbehaving(scoreInvalidMessage, fmt.Errorf("%w: %s payload checksum mismatch", ErrInvalidMessage, command))
+	}
+
+	return &message{Command: command, Payload: payload}, nil
+}
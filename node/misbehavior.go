@@ -0,0 +1,117 @@
+package node
+
+import (
+	"errors"
+	"time"
+
+	"blockchain/core/chain"
+)
+
+// DefaultBanDuration 违规分数达到上限的节点默认被封禁的时长
+const DefaultBanDuration = 24 * time.Hour
+
+// 一个连接累计的违规分数达到该值时封禁对方的IP
+const banThreshold = 100
+
+// 各类违规的分数
+const (
+	// 无法解码、超出条目数限制或校验和错误的消息
+	scoreInvalidMessage = 10
+	// 超出inv/getdata速率限制的消息
+	scoreRateLimited = 10
+	// 无法接到任何已知区块上的区块头 诚实的节点在链重组时也可能发送
+	scoreUnconnectedHeaders = 20
+	// 有效性取决于本地环境的区块 例如时间戳超前于本地时钟 双方的时钟不一致时诚实的节点也可能发送
+	scoreContextual = 20
+	// 在任何节点看来都无效的区块、交易或工作量证明 以及无法继续解析的超长消息 立即封禁
+	scoreInvalid = banThreshold
+)
+
+// 无效区块的违规分数 只有不依赖本地环境的违规才会立即封禁对方
+func invalidBlockScore(err error) int {
+	if errors.Is(err, chain.ErrFutureBlock) {
+		return scoreContextual
+	}
+
+	return scoreInvalid
+}
+
+// inv与getdata中条目的速率限制: 每秒补充的条目数与最多累积的条目数
+const (
+	invItemsPerSecond     = 1000
+	invItemsBurst         = 5000
+	getDataItemsPerSecond = 1000
+	getDataItemsBurst     = 5000
+)
+
+// misbehavior 对方违反了协议 消息被丢弃 分数累计到banThreshold时封禁对方
+type misbehavior struct {
+	score int
+	err   error
+}
+
+func misbehaving(score int, err error) error {
+	return &misbehavior{score: score, err: err}
+}
+
+func (m *misbehavior) Error() string {
+	return m.err.Error()
+}
+
+func (m *misbehavior) Unwrap() error {
+	return m.err
+}
+
+// 增加对方的违规分数 达到banThreshold时封禁其IP并断开该IP的所有连接 返回对方是否被封禁
+func (n *Node) punish(p *Peer, score int, reason error) bool {
+	total := p.addBanScore(score)
+	n.log.Printf("Peer %s misbehaved (+%d, score %d): %v", p.Addr(), score, total, reason)
+	if total < banThreshold {
+		return false
+	}
+
+	host := p.Host()
+	if err := n.bans.Ban(host, n.cfg.BanDuration, reason.Error()); err != nil {
+		n.log.Printf("Ban %s: %v", host, err)
+	}
+	n.log.Printf("Banned %s for %s", host, n.cfg.BanDuration)
+
+	n.mu.Lock()
+	for q := range n.peers {
+		if q.Host() == host {
+			q.disconnect()
+		}
+	}
+	n.mu.Unlock()
+
+	return true
+}
+
+// 令牌桶 限制对方在一段时间内发送的条目数 只在连接的读取goroutine中使用
+type rateLimiter struct {
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newRateLimiter(rate, burst float64) *rateLimiter {
+	return &rateLimiter{rate: rate, burst: burst, tokens: burst, last: time.Now()}
+}
+
+// 消耗n个令牌 令牌不足时不消耗并返回false
+func (r *rateLimiter) allow(n int) bool {
+	now := time.Now()
+	r.tokens += now.Sub(r.last).Seconds() * r.rate
+	if r.tokens > r.burst {
+		r.tokens = r.burst
+	}
+	r.last = now
+
+	if float64(n) > r.tokens {
+		return false
+	}
+	r.tokens -= float64(n)
+
+	return true
+}
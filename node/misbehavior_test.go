@@ -0,0 +1,39 @@
+package node
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"blockchain/core"
+	"blockchain/internal/chaintest"
+)
+
+func TestInvalidBlockScore(t *testing.T) {
+	n := newTestNode(t, nil, Config{})
+	p := newTestPeer(t)
+	subsidy := n.bc.Params().BlockSubsidy(1)
+
+	tests := []struct {
+		name   string
+		modify func(block *core.Block)
+		want   int
+	}{
+		// 时间戳超前的区块在对方的时钟看来可能是有效的
+		{"timestamp in the future", func(block *core.Block) { block.Timestamp = time.Now().Add(3 * time.Hour).Unix() }, scoreContextual},
+		{"merkle root mismatch", func(block *core.Block) { block.MerkleRoot = make([]byte, 32) }, scoreInvalid},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			block := chaintest.NewBlock(t, n.bc, chaintest.Coinbase(t, 1, make([]byte, 20), subsidy))
+			tt.modify(block)
+			solveHeader(block)
+
+			var m *misbehavior
+			if err := n.processBlock(p, block); !errors.As(err, &m) || m.score != tt.want {
+				t.Fatalf("processBlock() error = %v, want misbehavior with score %d", err, tt.want)
+			}
+		})
+	}
+}
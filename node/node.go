@@ -0,0 +1,573 @@
+// Package node 实现节点之间的点对点网络 使多个节点共享同一条区块链
+//
+// 节点之间通过TCP连接交换带消息头的消息: 握手(version/verack)、地址(addr)、
+// 通告(inv)、请求(getdata/getblocks)以及区块(block)与交易(tx)
+// 区块与交易使用其自身的Serialize编码 收到的区块加入区块链与UTXO集 交易加入交易池 并转发给其他节点
+package node
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"blockchain/core/chain"
+	"blockchain/core/mempool"
+	"blockchain/core/miner"
+)
+
+// DefaultMaxPeers 默认的最大连接数
+const DefaultMaxPeers = 8
+
+// DefaultMaxOutbound 默认主动建立的连接数
+const DefaultMaxOutbound = 4
+
+const (
+	// 建立连接的超时时间
+	dialTimeout = 5 * time.Second
+	// 检查主动连接数并补充连接的间隔
+	connectInterval = time.Second
+	// 将地址簿写入文件的间隔
+	addrSaveInterval = time.Minute
+	// 检查封禁列表文件是否被其他进程修改的间隔
+	banReloadInterval = 5 * time.Second
+	// 挖矿失败后没有新的交易时重试的间隔
+	mineRetryInterval = 10 * time.Second
+)
+
+// Config 节点的配置
+type Config struct {
+	// ListenAddr 接受连接的地址 如":3000" 为空时不接受连接
+	ListenAddr string
+	// Connect 不为空时只连接这些节点 不从地址簿中选择其他节点
+	Connect []string
+	// AddNodes 除了从地址簿中选择的节点之外始终保持连接的节点
+	AddNodes []string
+	// Seeds 加入地址簿的种子节点 地址簿为空时从这些节点获得其他节点的地址
+	Seeds []string
+	// PeersFile 保存地址簿的文件 为空时地址簿只保存在内存中
+	PeersFile string
+	// MinerAddress 不为空时节点在交易池中有交易时挖矿 奖励支付给该地址
+	MinerAddress string
+	// MaxPeers 最大连接数 小于等于0时使用DefaultMaxPeers
+	MaxPeers int
+	// MaxOutbound 从地址簿中选择节点主动建立的连接数 小于等于0时使用DefaultMaxOutbound
+	MaxOutbound int
+	// Logger 为nil时输出到标准错误
+	Logger *log.Logger
+	// Progress 不为nil时在初始区块下载期间定期以同步进度调用
+	Progress func(SyncProgress)
+	// BanFile 保存封禁列表的文件 为空时封禁只保存在内存中
+	BanFile string
+	// BanDuration 违规的节点被封禁的时长 小于等于0时使用DefaultBanDuration
+	BanDuration time.Duration
+}
+
+// Node 一个网络节点 维护与其他节点的连接并同步区块链与交易池
+type Node struct {
+	cfg     Config
+	bc      *chain.Blockchain
+	mempool *mempool.Mempool
+	miner   *miner.Miner
+	log     *log.Logger
+	sync    *syncManager
+	bans    *BanList
+	addrman *AddrManager
+	// 本节点的随机数 用于发现连接到自己的连接
+	nonce uint64
+
+	listener net.Listener
+	// 交易池中加入了新交易 用于唤醒挖矿
+	txAdded chan struct{}
+	// 得知了新的地址或主动连接断开 用于唤醒连接的补充
+	connWake chan struct{}
+	wg       sync.WaitGroup
+
+	mu     sync.Mutex
+	closed bool
+	peers  map[*Peer]bool
+	// 正在建立或已经建立的主动连接
+	outbound map[string]bool
+	// 连接后发现是本节点的地址
+	selfAddrs map[string]bool
+}
+
+// New 创建一个同步bc与mp的节点 cfg.MinerAddress不合法时返回错误
+func New(bc *chain.Blockchain, mp *mempool.Mempool, cfg Config) (*Node, error) {
+	n := &Node{
+		cfg:      cfg,
+		bc:       bc,
+		mempool:  mp,
+		log:      cfg.Logger,
+		txAdded:  make(chan struct{}, 1),
+		connWake: make(chan struct{}, 1),
+		peers:    make(map[*Peer]bool),
+		outbound: make(map[string]bool),
+
+		selfAddrs: make(map[string]bool),
+	}
+	if n.cfg.MaxPeers <= 0 {
+		n.cfg.MaxPeers = DefaultMaxPeers
+	}
+	if n.cfg.MaxOutbound <= 0 {
+		n.cfg.MaxOutbound = DefaultMaxOutbound
+	}
+	if n.cfg.BanDuration <= 0 {
+		n.cfg.BanDuration = DefaultBanDuration
+	}
+	if n.log == nil {
+		n.log = log.New(os.Stderr, "", log.LstdFlags)
+	}
+	n.sync = newSyncManager(n)
+
+	bans, err := OpenBanList(cfg.BanFile)
+	if err != nil {
+		return nil, err
+	}
+	n.bans = bans
+
+	addrman, err := NewAddrManager(cfg.PeersFile)
+	if err != nil {
+		return nil, err
+	}
+	addrman.Add(cfg.Seeds)
+	n.addrman = addrman
+
+	var nonce [8]byte
+	if _, err := rand.Read(nonce[:]); err != nil {
+		return nil, err
+	}
+	n.nonce = binary.BigEndian.Uint64(nonce[:])
+
+	if cfg.MinerAddress != "" {
+		m, err := miner.New(bc, mp, cfg.MinerAddress)
+		if err != nil {
+			return nil, err
+		}
+		n.miner = m
+	}
+
+	return n, nil
+}
+
+// Run 开始接受连接 保持与配置的节点及地址簿中的节点的连接并在需要时挖矿 直到ctx被取消
+// 返回前关闭所有连接并保存地址簿 ctx被取消时返回nil
+func (n *Node) Run(ctx context.Context) error {
+	if n.cfg.ListenAddr != "" {
+		listener, err := net.Listen("tcp", n.cfg.ListenAddr)
+		if err != nil {
+			return err
+		}
+		n.mu.Lock()
+		n.listener = listener
+		n.mu.Unlock()
+		n.log.Printf("Listening on %s", listener.Addr())
+
+		n.wg.Add(1)
+		go n.acceptLoop(listener)
+	}
+
+	n.wg.Add(1)
+	go n.connLoop(ctx)
+
+	if n.miner != nil {
+		n.wg.Add(1)
+		go n.mineLoop(ctx)
+	}
+
+	n.wg.Add(1)
+	go n.syncLoop(ctx)
+
+	<-ctx.Done()
+
+	n.mu.Lock()
+	n.closed = true
+	if n.listener != nil {
+		n.listener.Close()
+	}
+	for p := range n.peers {
+		p.disconnect()
+	}
+	n.mu.Unlock()
+	n.wg.Wait()
+
+	if err := n.addrman.Save(); err != nil {
+		n.log.Printf("Save address book: %v", err)
+	}
+
+	return nil
+}
+
+// ListenAddr 返回节点实际监听的地址 节点不接受连接时返回nil
+func (n *Node) ListenAddr() net.Addr {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if n.listener == nil {
+		return nil
+	}
+
+	return n.listener.Addr()
+}
+
+// Peers 返回所有已经完成握手的连接
+func (n *Node) Peers() []*Peer {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	var peers []*Peer
+	for p := range n.peers {
+		if p.handshakeDone() {
+			peers = append(peers, p)
+		}
+	}
+
+	return peers
+}
+
+// Connect 连接addr上的节点并完成握手前的准备 连接在后台处理 addr被封禁时返回错误
+func (n *Node) Connect(addr string) error {
+	if err := n.reserveOutbound(addr); err != nil {
+		return err
+	}
+
+	return n.dial(addr)
+}
+
+// 在后台连接addr 失败时只记录日志
+func (n *Node) connectAsync(addr string) {
+	// 在启动goroutine之前占用地址 避免同一地址被重复连接
+	if err := n.reserveOutbound(addr); err != nil {
+		n.log.Printf("Connect %s: %v", addr, err)
+		return
+	}
+
+	n.wg.Add(1)
+	go func() {
+		defer n.wg.Done()
+		if err := n.dial(addr); err != nil {
+			n.log.Printf("Connect %s: %v", addr, err)
+		}
+	}()
+}
+
+// 检查能否主动连接addr 可以时将其记为正在建立的主动连接
+func (n *Node) reserveOutbound(addr string) error {
+	if n.isBannedAddr(addr) {
+		return fmt.Errorf("cannot connect to %s: address is banned", addr)
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if n.closed {
+		return fmt.Errorf("cannot connect to %s: node is shutting down", addr)
+	}
+	if n.outbound[addr] {
+		return fmt.Errorf("already connected to %s", addr)
+	}
+	if len(n.peers) >= n.cfg.MaxPeers {
+		return fmt.Errorf("cannot connect to %s: %d peers connected", addr, len(n.peers))
+	}
+	n.outbound[addr] = true
+
+	return nil
+}
+
+// 连接已经占用的地址addr 失败时释放该地址
+func (n *Node) dial(addr string) error {
+	n.addrman.Attempt(addr)
+	conn, err := net.DialTimeout("tcp", addr, dialTimeout)
+	if err != nil {
+		n.mu.Lock()
+		delete(n.outbound, addr)
+		n.mu.Unlock()
+		return err
+	}
+
+	p := newPeer(conn, false, n.bc.ChainParams().Magic)
+	p.dialAddr = addr
+	if !n.addPeer(p) {
+		return fmt.Errorf("cannot connect to %s: node is shutting down", addr)
+	}
+	n.sendVersion(p)
+
+	return nil
+}
+
+func (n *Node) acceptLoop(listener net.Listener) {
+	defer n.wg.Done()
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			// 监听被关闭
+			return
+		}
+
+		n.mu.Lock()
+		full := len(n.peers) >= n.cfg.MaxPeers
+		n.mu.Unlock()
+		if full || n.isBannedAddr(conn.RemoteAddr().String()) {
+			conn.Close()
+			continue
+		}
+
+		n.addPeer(newPeer(conn, true, n.bc.ChainParams().Magic))
+	}
+}
+
+// 记录连接并开始收发消息 节点已经关闭时关闭连接并返回false
+func (n *Node) addPeer(p *Peer) bool {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if n.closed {
+		p.disconnect()
+		return false
+	}
+	n.peers[p] = true
+
+	n.wg.Add(2)
+	go func() {
+		defer n.wg.Done()
+		p.writeLoop()
+	}()
+	go func() {
+		defer n.wg.Done()
+		n.readLoop(p)
+	}()
+
+	return true
+}
+
+// HOST:PORT形式的地址是否被封禁
+func (n *Node) isBannedAddr(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		host = addr
+	}
+
+	return n.bans.IsBanned(host)
+}
+
+// 连接断开后移除记录 并将其尚未完成的区块请求交给其他连接
+func (n *Node) removePeer(p *Peer) {
+	p.disconnect()
+
+	n.mu.Lock()
+	delete(n.peers, p)
+	if !p.inbound {
+		delete(n.outbound, p.dialAddr)
+	}
+	n.mu.Unlock()
+
+	n.sync.peerGone(p)
+	if !p.inbound {
+		n.wakeConnLoop()
+	}
+}
+
+// 依次处理对方发送的消息 出错时断开连接
+// 违反协议的消息只被丢弃并计入违规分数 对方被封禁时才断开连接
+func (n *Node) readLoop(p *Peer) {
+	defer n.removePeer(p)
+
+	for {
+		msg, err := p.readMessage()
+		if err == nil {
+			err = n.handleMessage(p, msg)
+		}
+		if err == nil {
+			continue
+		}
+
+		var m *misbehavior
+		if errors.As(err, &m) {
+			if !n.punish(p, m.score, m.err) {
+				continue
+			}
+		} else if !errors.Is(err, io.EOF) && !errors.Is(err, net.ErrClosed) {
+			n.log.Printf("Peer %s: %v", p.Addr(), err)
+		}
+		return
+	}
+}
+
+// 将消息加入所有已经完成握手的连接的发送队列 except为nil时发送给所有连接
+func (n *Node) broadcast(msg *message, except *Peer) {
+	for _, p := range n.Peers() {
+		if p != except {
+			p.queue(msg)
+		}
+	}
+}
+
+// 在交易池中有交易时挖矿 挖出的区块通告给所有连接
+func (n *Node) mineLoop(ctx context.Context) {
+	defer n.wg.Done()
+
+	for {
+		if n.mempool.Count() == 0 {
+			select {
+			case <-ctx.Done():
+				return
+			case <-n.txAdded:
+				continue
+			}
+		}
+
+		block, err := n.miner.MineBlock(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			n.log.Printf("Mining failed: %v", err)
+			// 等待新的交易或一段时间后再重试
+			select {
+			case <-ctx.Done():
+				return
+			case <-n.txAdded:
+			case <-time.After(mineRetryInterval):
+			}
+			continue
+		}
+
+		n.log.Printf("Mined block %x at height %d with %d transactions", block.Hash, block.Height, len(block.Transactions))
+		n.announceBlock(block.Hash, nil)
+	}
+}
+
+// 定期检查区块下载的超时并报告同步进度
+func (n *Node) syncLoop(ctx context.Context) {
+	defer n.wg.Done()
+
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			n.sync.tick()
+		}
+	}
+}
+
+// 定期补充主动连接 保存地址簿并重新读取封禁列表
+func (n *Node) connLoop(ctx context.Context) {
+	defer n.wg.Done()
+
+	ticker := time.NewTicker(connectInterval)
+	defer ticker.Stop()
+	saveTicker := time.NewTicker(addrSaveInterval)
+	defer saveTicker.Stop()
+	banTicker := time.NewTicker(banReloadInterval)
+	defer banTicker.Stop()
+
+	for {
+		n.maintainOutbound()
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-n.connWake:
+		case <-saveTicker.C:
+			if err := n.addrman.Save(); err != nil {
+				n.log.Printf("Save address book: %v", err)
+			}
+		case <-banTicker.C:
+			if err := n.bans.Reload(); err != nil {
+				n.log.Printf("Reload ban list: %v", err)
+			}
+		}
+	}
+}
+
+// 重新连接断开的固定节点 未指定cfg.Connect时从地址簿中选择节点补足cfg.MaxOutbound个主动连接
+func (n *Node) maintainOutbound() {
+	n.mu.Lock()
+	if n.closed {
+		n.mu.Unlock()
+		return
+	}
+	outbound := make(map[string]bool, len(n.outbound))
+	for addr := range n.outbound {
+		outbound[addr] = true
+	}
+	// 已经通过被动连接相连的节点与本节点自己的地址不需要再连接
+	connected := make(map[string]bool)
+	for p := range n.peers {
+		if addr := p.ListenAddr(); p.inbound && addr != "" {
+			connected[addr] = true
+		}
+	}
+	for addr := range n.selfAddrs {
+		connected[addr] = true
+	}
+	room := n.cfg.MaxPeers - len(n.peers)
+	n.mu.Unlock()
+
+	// 固定的节点不计入cfg.MaxOutbound 断开后按退避的间隔重试
+	fixed := make(map[string]bool)
+	for _, addr := range append(append([]string{}, n.cfg.Connect...), n.cfg.AddNodes...) {
+		fixed[addr] = true
+		if room > 0 && !outbound[addr] && n.addrman.CanRetry(addr) {
+			outbound[addr] = true
+			room--
+			n.connectAsync(addr)
+		}
+	}
+	if len(n.cfg.Connect) > 0 {
+		return
+	}
+
+	// 已有的主动连接所在的网段 新的连接优先选择其他网段
+	groups := make(map[string]bool)
+	automatic := 0
+	for addr := range outbound {
+		groups[addrGroup(addr)] = true
+		if !fixed[addr] {
+			automatic++
+		}
+	}
+
+	for ; automatic < n.cfg.MaxOutbound && room > 0; automatic++ {
+		addr := n.addrman.Select(groups, func(addr string) bool {
+			return outbound[addr] || connected[addr] || n.isBannedAddr(addr)
+		})
+		if addr == "" {
+			return
+		}
+
+		outbound[addr] = true
+		groups[addrGroup(addr)] = true
+		room--
+		n.connectAsync(addr)
+	}
+}
+
+// 唤醒连接的补充
+func (n *Node) wakeConnLoop() {
+	select {
+	case n.connWake <- struct{}{}:
+	default:
+	}
+}
+
+// 唤醒挖矿
+func (n *Node) notifyTxAdded() {
+	select {
+	case n.txAdded <- struct{}{}:
+	default:
+	}
+}
@@ -0,0 +1,137 @@
+package node
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"blockchain/core"
+	"blockchain/core/chain"
+	"blockchain/internal/chaintest"
+	"blockchain/wallet"
+)
+
+// 等待网络中的消息传递的最长时间
+const relayTimeout = 10 * time.Second
+
+// 在后台运行节点直到测试结束 返回节点实际监听的地址
+func runTestNode(t *testing.T, n *Node) string {
+	t.Helper()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- n.Run(ctx)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		if err := <-done; err != nil {
+			t.Errorf("Run() error = %v", err)
+		}
+	})
+
+	if n.cfg.ListenAddr == "" {
+		return ""
+	}
+	waitFor(t, "node to listen", func() bool {
+		return n.ListenAddr() != nil
+	})
+
+	return n.ListenAddr().String()
+}
+
+// 轮询直到cond成立 超过relayTimeout时测试失败
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(relayTimeout)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func sameTip(nodes ...*Node) bool {
+	for _, n := range nodes[1:] {
+		if !bytes.Equal(n.bc.Tip(), nodes[0].bc.Tip()) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// 三个节点通过本地回环连接成a-b-c 检查握手、初始同步以及区块与交易经过b的转发
+func TestLoopbackRelay(t *testing.T) {
+	params := &chain.RegTestParams
+	w := chaintest.NewWallet(t)
+	pubKeyHash := wallet.HashPubKey(w.PublicKey)
+
+	a := newTestNode(t, params, Config{ListenAddr: "127.0.0.1:0"})
+	coinbases := chaintest.MineBlocks(t, a.bc, int(params.Consensus.CoinbaseMaturity)+1, pubKeyHash)
+	aAddr := runTestNode(t, a)
+
+	b := newTestNode(t, params, Config{ListenAddr: "127.0.0.1:0", Connect: []string{aAddr}})
+	bAddr := runTestNode(t, b)
+
+	// c收到交易时挖矿 挖出的区块需要经过b才能到达a
+	miner := chaintest.NewWallet(t)
+	c := newTestNode(t, params, Config{Connect: []string{bAddr}, MinerAddress: string(miner.GetAddress(params.AddressVersion))})
+	runTestNode(t, c)
+
+	waitFor(t, "handshakes", func() bool {
+		return len(a.Peers()) == 1 && len(b.Peers()) == 2 && len(c.Peers()) == 1
+	})
+	for _, p := range b.Peers() {
+		if p.Inbound() == (p.ListenAddr() == aAddr) {
+			t.Errorf("peer %s of b: inbound = %v, listen address %q", p.Addr(), p.Inbound(), p.ListenAddr())
+		}
+	}
+
+	waitFor(t, "initial sync", func() bool {
+		return sameTip(a, b, c)
+	})
+
+	// a挖出的区块经过b转发到c
+	mined := chaintest.MineBlocks(t, a.bc, 1, pubKeyHash)
+	a.announceBlock(a.bc.Tip(), nil)
+	waitFor(t, "block relay", func() bool {
+		return sameTip(a, b, c)
+	})
+	if height, _ := c.bc.GetBestHeight(); height != int64(len(coinbases)+len(mined)) {
+		t.Fatalf("c is at height %d after block relay, want %d", height, len(coinbases)+len(mined))
+	}
+
+	// a的交易经过b转发到c c将其挖入区块后区块再回到a
+	tx := &core.Transaction{
+		Vin:  []core.TXInput{{Txid: coinbases[0].ID, Vout: 0, PubKey: w.PublicKey}},
+		Vout: []core.TXOutput{{Value: coinbases[0].Vout[0].Value - 1, PubKeyHash: pubKeyHash}},
+	}
+	tx.ID = tx.Hash()
+	err := tx.SignInputs(w.PrivateKey, func(in core.TXInput) *core.TXOutput {
+		return &coinbases[0].Vout[in.Vout]
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := a.mempool.Add(tx); err != nil {
+		t.Fatal(err)
+	}
+	inv, err := newMessage(cmdInv, &invMsg{Type: invTx, Items: [][]byte{tx.ID}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.broadcast(inv, nil)
+
+	waitFor(t, "transaction relay and mining", func() bool {
+		return a.mempool.Count() == 0 && sameTip(a, b, c)
+	})
+	for name, n := range map[string]*Node{"a": a, "b": b, "c": c} {
+		if _, err := n.bc.FindTransaction(tx.ID); err != nil {
+			t.Errorf("transaction %x is not in the chain of %s: %v", tx.ID, name, err)
+		}
+	}
+}
@@ -0,0 +1,198 @@
+package node
+
+import (
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// 等待发送的消息队列长度
+	sendQueueSize = 256
+	// 写入一个消息的超时时间
+	writeTimeout = 30 * time.Second
+	// 握手必须在该时间内完成
+	handshakeTimeout = 10 * time.Second
+)
+
+// Peer 一个已经建立TCP连接的节点
+type Peer struct {
+	conn    net.Conn
+	inbound bool
+	// 所在网络的消息标识
+	magic [4]byte
+	// 主动发起的连接所连接的地址
+	dialAddr string
+	send     chan *message
+	quit     chan struct{}
+	once     sync.Once
+	// inv与getdata的速率限制 只在读取goroutine中使用
+	invLimit     *rateLimiter
+	getDataLimit *rateLimiter
+
+	mu sync.Mutex
+	// 对方发送的version 握手完成前为nil
+	version *versionMsg
+	// 是否收到了对方的verack
+	verackReceived bool
+	// 对方拥有的主链高度 收到更高的区块时更新
+	bestHeight int64
+	// 本节点落后于对方时收到的交易通告 同步到对方的高度后再请求这些交易
+	deferredTxs [][]byte
+	// 累计的违规分数
+	banScore int
+}
+
+func newPeer(conn net.Conn, inbound bool, magic [4]byte) *Peer {
+	return &Peer{
+		conn:    conn,
+		inbound: inbound,
+		magic:   magic,
+		send:    make(chan *message, sendQueueSize),
+		quit:    make(chan struct{}),
+
+		invLimit:     newRateLimiter(invItemsPerSecond, invItemsBurst),
+		getDataLimit: newRateLimiter(getDataItemsPerSecond, getDataItemsBurst),
+	}
+}
+
+// Addr 返回连接对方的地址
+func (p *Peer) Addr() string {
+	return p.conn.RemoteAddr().String()
+}
+
+// Host 返回对方的IP 封禁以IP为单位
+func (p *Peer) Host() string {
+	host, _, err := net.SplitHostPort(p.Addr())
+	if err != nil {
+		return p.Addr()
+	}
+
+	return host
+}
+
+// Inbound 连接是否由对方发起
+func (p *Peer) Inbound() bool {
+	return p.inbound
+}
+
+// ListenAddr 返回对方接受连接的地址 对方不接受连接或握手尚未完成时返回空字符串
+// 主动发起的连接即为发起连接时使用的地址 被动接受的连接由对方的IP与其在version中声明的端口组成
+func (p *Peer) ListenAddr() string {
+	if !p.inbound {
+		return p.dialAddr
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.version == nil || p.version.ListenPort == 0 {
+		return ""
+	}
+	host, _, err := net.SplitHostPort(p.Addr())
+	if err != nil {
+		return ""
+	}
+
+	return net.JoinHostPort(host, strconv.Itoa(p.version.ListenPort))
+}
+
+// BanScore 返回对方累计的违规分数
+func (p *Peer) BanScore() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.banScore
+}
+
+// 增加违规分数并返回累计的分数
+func (p *Peer) addBanScore(score int) int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.banScore += score
+	return p.banScore
+}
+
+// BestHeight 返回已知的对方主链高度
+func (p *Peer) BestHeight() int64 {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.bestHeight
+}
+
+// 对方的主链至少达到height
+func (p *Peer) updateHeight(height int64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if height > p.bestHeight {
+		p.bestHeight = height
+	}
+}
+
+// 握手是否已经完成
+func (p *Peer) handshakeDone() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.version != nil && p.verackReceived
+}
+
+// queue 将消息加入发送队列 队列在writeTimeout内一直是满的说明对方无法及时接收 此时断开连接
+func (p *Peer) queue(msg *message) {
+	select {
+	case p.send <- msg:
+		return
+	case <-p.quit:
+		return
+	default:
+	}
+
+	timer := time.NewTimer(writeTimeout)
+	defer timer.Stop()
+
+	select {
+	case p.send <- msg:
+	case <-p.quit:
+	case <-timer.C:
+		p.disconnect()
+	}
+}
+
+// disconnect 关闭连接 可以被多次调用
+func (p *Peer) disconnect() {
+	p.once.Do(func() {
+		close(p.quit)
+		p.conn.Close()
+	})
+}
+
+// 依次发送队列中的消息 直到连接被关闭
+func (p *Peer) writeLoop() {
+	for {
+		select {
+		case <-p.quit:
+			return
+		case msg := <-p.send:
+			p.conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+			if err := writeMessage(p.conn, p.magic, msg); err != nil {
+				p.disconnect()
+				return
+			}
+		}
+	}
+}
+
+// 读取对方发送的下一个消息 握手需要在handshakeTimeout内完成
+func (p *Peer) readMessage() (*message, error) {
+	if p.handshakeDone() {
+		p.conn.SetReadDeadline(time.Time{})
+	} else {
+		p.conn.SetReadDeadline(time.Now().Add(handshakeTimeout))
+	}
+
+	return readMessage(p.conn, p.magic)
+}
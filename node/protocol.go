@@ -0,0 +1,508 @@
+package node
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net"
+
+	"blockchain/core"
+	"blockchain/core/chain"
+	"blockchain/core/mempool"
+)
+
+// 一次addr消息最多包含的地址数
+const maxAddrItems = 1000
+
+// 包含不超过这么多个地址的addr消息中的新地址会被转发 较大的消息通常是握手时发送的整个地址簿
+const maxAddrRelayItems = 10
+
+// 新地址转发给的连接数
+const addrRelayPeers = 2
+
+// 根据命令分发消息 返回错误时断开连接
+func (n *Node) handleMessage(p *Peer, msg *message) error {
+	switch msg.Command {
+	case cmdVersion:
+		return n.handleVersion(p, msg)
+	case cmdVerack:
+		return n.handleVerack(p)
+	}
+
+	if !p.handshakeDone() {
+		return fmt.Errorf("%w: %s before the handshake", ErrInvalidMessage, msg.Command)
+	}
+
+	var err error
+	switch msg.Command {
+	case cmdAddr:
+		err = n.handleAddr(p, msg)
+	case cmdInv:
+		err = n.handleInv(p, msg)
+	case cmdGetData:
+		err = n.handleGetData(p, msg)
+	case cmdGetBlocks:
+		err = n.handleGetBlocks(p, msg)
+	case cmdGetHeaders:
+		err = n.handleGetHeaders(p, msg)
+	case cmdHeaders:
+		err = n.handleHeaders(p, msg)
+	case cmdBlock:
+		err = n.handleBlock(p, msg)
+	case cmdTx:
+		err = n.handleTx(p, msg)
+	default:
+		err = fmt.Errorf("%w: unknown command %q", ErrInvalidMessage, msg.Command)
+	}
+
+	// 握手之后不合法的消息只被丢弃并计入违规分数
+	var m *misbehavior
+	if errors.Is(err, ErrInvalidMessage) && !errors.As(err, &m) {
+		return misbehaving(scoreInvalidMessage, err)
+	}
+
+	return err
+}
+
+// 编码消息并加入发送队列
+func (n *Node) send(p *Peer, command string, payload interface{}) {
+	msg, err := newMessage(command, payload)
+	if err != nil {
+		n.log.Printf("Encode %s: %v", command, err)
+		return
+	}
+
+	p.queue(msg)
+}
+
+func (n *Node) sendVersion(p *Peer) {
+	height, err := n.bc.GetBestHeight()
+	if err != nil {
+		n.log.Printf("Best height: %v", err)
+	}
+
+	n.send(p, cmdVersion, &versionMsg{
+		Version:    protocolVersion,
+		BestHeight: height,
+		ListenPort: n.listenPort(),
+		Nonce:      n.nonce,
+	})
+}
+
+func (n *Node) handleVersion(p *Peer, msg *message) error {
+	var payload versionMsg
+	if err := msg.decode(&payload); err != nil {
+		return err
+	}
+	if payload.Nonce == n.nonce {
+		// 该地址就是本节点 不再尝试连接
+		if !p.inbound {
+			n.mu.Lock()
+			n.selfAddrs[p.dialAddr] = true
+			n.mu.Unlock()
+			n.addrman.Remove(p.dialAddr)
+		}
+		return errors.New("connected to self")
+	}
+
+	p.mu.Lock()
+	duplicate := p.version != nil
+	if !duplicate {
+		p.version = &payload
+		p.bestHeight = payload.BestHeight
+	}
+	p.mu.Unlock()
+	if duplicate {
+		return fmt.Errorf("%w: duplicate version", ErrInvalidMessage)
+	}
+
+	// 被动接受的连接在收到对方的version之后才发送自己的version
+	if p.inbound {
+		n.sendVersion(p)
+	}
+	n.send(p, cmdVerack, nil)
+
+	if p.handshakeDone() {
+		n.onHandshake(p)
+	}
+
+	return nil
+}
+
+func (n *Node) handleVerack(p *Peer) error {
+	p.mu.Lock()
+	duplicate := p.verackReceived
+	p.verackReceived = true
+	p.mu.Unlock()
+	if duplicate {
+		return fmt.Errorf("%w: duplicate verack", ErrInvalidMessage)
+	}
+
+	if p.handshakeDone() {
+		n.onHandshake(p)
+	}
+
+	return nil
+}
+
+// 握手完成后交换地址 同步区块并通告交易池中的交易
+func (n *Node) onHandshake(p *Peer) {
+	direction := "outbound"
+	if p.inbound {
+		direction = "inbound"
+	}
+	n.log.Printf("Connected to %s peer %s at height %d", direction, p.Addr(), p.BestHeight())
+
+	listenAddr := p.ListenAddr()
+	if !p.inbound {
+		n.addrman.Good(listenAddr)
+	} else if listenAddr != "" {
+		n.addrman.Add([]string{listenAddr})
+	}
+
+	var addrs []string
+	for _, addr := range n.addrman.Addresses(maxAddrItems + 1) {
+		if addr != listenAddr && len(addrs) < maxAddrItems {
+			addrs = append(addrs, addr)
+		}
+	}
+	if len(addrs) > 0 {
+		n.send(p, cmdAddr, &addrMsg{Addresses: addrs})
+	}
+
+	n.sync.startHeaderSync(p)
+
+	var txids [][]byte
+	for _, entry := range n.mempool.List() {
+		txids = append(txids, entry.Tx.ID)
+		if len(txids) == maxInvItems {
+			n.send(p, cmdInv, &invMsg{Type: invTx, Items: txids})
+			txids = nil
+		}
+	}
+	if len(txids) > 0 {
+		n.send(p, cmdInv, &invMsg{Type: invTx, Items: txids})
+	}
+}
+
+// 将新的地址加入地址簿 小的addr消息中的新地址转发给其他连接
+func (n *Node) handleAddr(p *Peer, msg *message) error {
+	var payload addrMsg
+	if err := msg.decode(&payload); err != nil {
+		return err
+	}
+	if len(payload.Addresses) > maxAddrItems {
+		return fmt.Errorf("%w: %d addresses", ErrInvalidMessage, len(payload.Addresses))
+	}
+
+	var addrs []string
+	n.mu.Lock()
+	for _, addr := range payload.Addresses {
+		if !n.selfAddrs[addr] && !n.isBannedAddr(addr) {
+			addrs = append(addrs, addr)
+		}
+	}
+	n.mu.Unlock()
+	fresh := n.addrman.Add(addrs)
+	if len(fresh) == 0 {
+		return nil
+	}
+	n.wakeConnLoop()
+
+	if len(payload.Addresses) <= maxAddrRelayItems {
+		relayed := 0
+		for _, other := range n.Peers() {
+			if other == p || relayed == addrRelayPeers {
+				continue
+			}
+			n.send(other, cmdAddr, &addrMsg{Addresses: fresh})
+			relayed++
+		}
+	}
+
+	return nil
+}
+
+// 请求本节点还没有的区块与交易
+func (n *Node) handleInv(p *Peer, msg *message) error {
+	var payload invMsg
+	if err := msg.decode(&payload); err != nil {
+		return err
+	}
+	if len(payload.Items) > maxInvItems {
+		return fmt.Errorf("%w: %d inventory items", ErrInvalidMessage, len(payload.Items))
+	}
+	if !p.invLimit.allow(len(payload.Items)) {
+		return misbehaving(scoreRateLimited, fmt.Errorf("inv rate limit exceeded by %d items", len(payload.Items)))
+	}
+
+	var missing [][]byte
+	switch payload.Type {
+	case invBlock:
+		for _, hash := range payload.Items {
+			if !n.bc.HasBlock(hash) {
+				missing = append(missing, hash)
+			}
+		}
+	case invTx:
+		// 落后时无法验证交易 等同步到对方的高度后再请求
+		if height, err := n.bc.GetBestHeight(); err == nil && height < p.BestHeight() {
+			p.mu.Lock()
+			if len(p.deferredTxs) < maxInvItems {
+				p.deferredTxs = append(p.deferredTxs, payload.Items...)
+			}
+			p.mu.Unlock()
+			return nil
+		}
+		missing = n.missingTxs(payload.Items)
+	default:
+		return fmt.Errorf("%w: unknown inventory type %q", ErrInvalidMessage, payload.Type)
+	}
+
+	if len(missing) > 0 {
+		n.send(p, cmdGetData, &getDataMsg{Type: payload.Type, Items: missing})
+	}
+
+	return nil
+}
+
+// 发送对方请求的区块与交易 本节点没有的条目被忽略
+func (n *Node) handleGetData(p *Peer, msg *message) error {
+	var payload getDataMsg
+	if err := msg.decode(&payload); err != nil {
+		return err
+	}
+	if len(payload.Items) > maxInvItems {
+		return fmt.Errorf("%w: %d getdata items", ErrInvalidMessage, len(payload.Items))
+	}
+	if !p.getDataLimit.allow(len(payload.Items)) {
+		return misbehaving(scoreRateLimited, fmt.Errorf("getdata rate limit exceeded by %d items", len(payload.Items)))
+	}
+
+	for _, id := range payload.Items {
+		switch payload.Type {
+		case invBlock:
+			block, err := n.bc.GetBlockByHash(id)
+			if err != nil {
+				continue
+			}
+			n.send(p, cmdBlock, &blockMsg{Block: block.Serialize()})
+		case invTx:
+			entry, err := n.mempool.Get(id)
+			if err != nil {
+				continue
+			}
+			n.send(p, cmdTx, &txMsg{Transaction: entry.Tx.Serialize()})
+		default:
+			return fmt.Errorf("%w: unknown inventory type %q", ErrInvalidMessage, payload.Type)
+		}
+	}
+
+	return nil
+}
+
+// 通告locator之后的主链区块
+func (n *Node) handleGetBlocks(p *Peer, msg *message) error {
+	var payload getBlocksMsg
+	if err := msg.decode(&payload); err != nil {
+		return err
+	}
+	if len(payload.Locator) > maxLocatorItems {
+		return fmt.Errorf("%w: %d locator hashes", ErrInvalidMessage, len(payload.Locator))
+	}
+
+	hashes, err := n.bc.LocateBlocks(payload.Locator, payload.Stop, maxInvItems)
+	if err != nil {
+		return err
+	}
+	if len(hashes) > 0 {
+		n.send(p, cmdInv, &invMsg{Type: invBlock, Items: hashes})
+	}
+
+	return nil
+}
+
+// 发送locator之后的主链区块头 用于初始区块下载
+func (n *Node) handleGetHeaders(p *Peer, msg *message) error {
+	var payload getHeadersMsg
+	if err := msg.decode(&payload); err != nil {
+		return err
+	}
+	if len(payload.Locator) > maxLocatorItems {
+		return fmt.Errorf("%w: %d locator hashes", ErrInvalidMessage, len(payload.Locator))
+	}
+
+	headers, err := n.bc.LocateHeaders(payload.Locator, payload.Stop, maxHeaders)
+	if err != nil {
+		return err
+	}
+	// 没有更多区块头时也要回复 对方据此判断区块头已经下载完成
+	n.send(p, cmdHeaders, &headersMsg{Headers: headers})
+
+	return nil
+}
+
+func (n *Node) handleHeaders(p *Peer, msg *message) error {
+	var payload headersMsg
+	if err := msg.decode(&payload); err != nil {
+		return err
+	}
+
+	return n.sync.handleHeaders(p, payload.Headers)
+}
+
+func (n *Node) handleBlock(p *Peer, msg *message) error {
+	var payload blockMsg
+	if err := msg.decode(&payload); err != nil {
+		return err
+	}
+	block, err := core.DeserializeBlock(payload.Block)
+	if err != nil {
+		return fmt.Errorf("%w: block: %v", ErrInvalidMessage, err)
+	}
+
+	if !n.sync.blockReceived(p, block) {
+		if err := n.processBlock(p, block); err != nil {
+			return err
+		}
+	}
+
+	// 已经同步到对方的高度 请求之前推迟的交易
+	if height, err := n.bc.GetBestHeight(); err == nil && height >= p.BestHeight() {
+		p.mu.Lock()
+		deferred := p.deferredTxs
+		p.deferredTxs = nil
+		p.mu.Unlock()
+
+		if missing := n.missingTxs(deferred); len(missing) > 0 {
+			n.send(p, cmdGetData, &getDataMsg{Type: invTx, Items: missing})
+		}
+	}
+
+	return nil
+}
+
+// 返回不在交易池中的交易
+func (n *Node) missingTxs(txids [][]byte) [][]byte {
+	var missing [][]byte
+	for _, txid := range txids {
+		if _, err := n.mempool.Get(txid); err != nil {
+			missing = append(missing, txid)
+		}
+	}
+
+	return missing
+}
+
+// 将收到的区块加入区块链 主链变化时通告新的链尾 缺少父区块时从对方下载区块头
+// 区块违反共识规则时返回misbehavior
+func (n *Node) processBlock(p *Peer, block *core.Block) error {
+	status, changed, err := n.addBlock(block)
+	if errors.Is(err, chain.ErrDuplicateBlock) {
+		return nil
+	}
+	if errors.Is(err, chain.ErrInvalidBlock) {
+		return misbehaving(invalidBlockScore(err), err)
+	}
+	if err != nil {
+		n.log.Printf("Rejected block %x from %s: %v", block.Hash, p.Addr(), err)
+		return nil
+	}
+
+	p.updateHeight(block.Height)
+
+	if status == chain.BlockOrphan {
+		n.sync.startHeaderSync(p)
+		return nil
+	}
+	if changed {
+		newTip := n.bc.Tip()
+		height, _ := n.bc.GetBestHeight()
+		n.log.Printf("Chain tip %x at height %d", newTip, height)
+		n.announceBlock(newTip, p)
+	}
+
+	return nil
+}
+
+// 将区块加入区块链 主链末端变化时更新交易池 changed表示主链末端是否变化
+func (n *Node) addBlock(block *core.Block) (status chain.BlockStatus, changed bool, err error) {
+	oldTip := n.bc.Tip()
+
+	status, err = n.bc.AddBlock(block)
+	if err != nil {
+		return status, false, err
+	}
+
+	newTip := n.bc.Tip()
+	if bytes.Equal(newTip, oldTip) {
+		return status, false, nil
+	}
+
+	// 只连接了这一个区块时按区块移除交易 否则重新验证整个交易池
+	if bytes.Equal(block.PrevBlockHash, oldTip) && bytes.Equal(newTip, block.Hash) {
+		err = n.mempool.RemoveBlock(block)
+	} else {
+		err = n.mempool.Revalidate()
+	}
+	if err != nil {
+		n.log.Printf("Update mempool: %v", err)
+	}
+
+	return status, true, nil
+}
+
+// 向除except之外的所有连接通告区块
+func (n *Node) announceBlock(hash []byte, except *Peer) {
+	msg, err := newMessage(cmdInv, &invMsg{Type: invBlock, Items: [][]byte{hash}})
+	if err != nil {
+		n.log.Printf("Encode inv: %v", err)
+		return
+	}
+
+	n.broadcast(msg, except)
+}
+
+// 将收到的交易加入交易池 成功时转发给其他连接并唤醒挖矿
+func (n *Node) handleTx(p *Peer, msg *message) error {
+	var payload txMsg
+	if err := msg.decode(&payload); err != nil {
+		return err
+	}
+	tx, err := core.DeserializeTransaction(payload.Transaction)
+	if err != nil {
+		return fmt.Errorf("%w: tx: %v", ErrInvalidMessage, err)
+	}
+
+	if _, err := n.mempool.Add(tx); err != nil {
+		// 无效的交易会消耗验证签名的开销 发送者被立即封禁
+		// 交易池只对不依赖链状态的违规返回ErrInvalidTransaction 花费未成熟的铸币交易、
+		// 缺少输入或与交易池中的交易冲突取决于本地的链与交易池 只记录日志
+		if errors.Is(err, chain.ErrInvalidTransaction) || errors.Is(err, core.ErrInvalidSignature) {
+			return misbehaving(scoreInvalid, err)
+		}
+		if !errors.Is(err, mempool.ErrAlreadyExists) {
+			n.log.Printf("Rejected transaction %x from %s: %v", tx.ID, p.Addr(), err)
+		}
+		return nil
+	}
+	n.log.Printf("Accepted transaction %x from %s", tx.ID, p.Addr())
+
+	inv, err := newMessage(cmdInv, &invMsg{Type: invTx, Items: [][]byte{tx.ID}})
+	if err == nil {
+		n.broadcast(inv, p)
+	}
+	n.notifyTxAdded()
+
+	return nil
+}
+
+// 本节点监听的端口 不接受连接时为0
+func (n *Node) listenPort() int {
+	addr, ok := n.ListenAddr().(*net.TCPAddr)
+	if !ok {
+		return 0
+	}
+
+	return addr.Port
+}
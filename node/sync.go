@@ -0,0 +1,541 @@
+package node
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/big"
+	"sync"
+	"time"
+
+	"blockchain/core"
+	"blockchain/core/chain"
+	"blockchain/pow"
+)
+
+const (
+	// 每个连接同时请求的最多区块数
+	maxBlocksInFlight = 16
+	// 只请求待连接区块之后这么多个区块 限制已下载但尚未连接的区块占用的内存
+	downloadWindow = 1024
+	// 请求的区块在该时间内没有到达时改为向其他连接请求
+	blockRequestTimeout = 20 * time.Second
+	// 同步期间报告进度的间隔
+	progressInterval = 2 * time.Second
+)
+
+// SyncProgress 初始区块下载的进度
+type SyncProgress struct {
+	// Height 主链的高度
+	Height int64
+	// TargetHeight 已经下载并验证的区块头链的高度
+	TargetHeight int64
+	// BlocksPerSecond 自上次报告以来每秒连接的区块数
+	BlocksPerSecond float64
+	// Done 区块已经全部下载并连接
+	Done bool
+}
+
+// 一个已经发出的区块请求
+type blockRequest struct {
+	peer *Peer
+	sent time.Time
+}
+
+// 一个已经到达但还不能连接的区块 区块无效时惩罚发送它的连接
+type downloadedBlock struct {
+	block *core.Block
+	peer  *Peer
+}
+
+// 初始区块下载: 先从一个连接下载区块头并验证其工作量证明链
+// 再从所有高度足够的连接并行下载区块 按区块头的顺序连接到区块链与UTXO集
+type syncManager struct {
+	n *Node
+
+	mu sync.Mutex
+	// 已经验证的区块头链 第一个区块头的父区块在本节点的区块链中
+	headers []*core.BlockHeader
+	index   map[string]int
+	// 从创世块到headers中每个区块头(包含)的累计工作量
+	work []*big.Int
+	// 正在从其下载区块头的连接
+	headerPeer *Peer
+	// 下一个要连接的区块在headers中的位置
+	next int
+	// 已经请求但尚未到达的区块
+	requests map[string]*blockRequest
+	inFlight map[*Peer]int
+	// 已经到达但还不能连接的区块
+	downloaded map[string]*downloadedBlock
+	// 有goroutine正在连接区块 其他goroutine收到的区块由它继续连接
+	connecting bool
+
+	syncing        bool
+	reportedAt     time.Time
+	reportedHeight int64
+}
+
+func newSyncManager(n *Node) *syncManager {
+	return &syncManager{
+		n:          n,
+		index:      make(map[string]int),
+		requests:   make(map[string]*blockRequest),
+		inFlight:   make(map[*Peer]int),
+		downloaded: make(map[string]*downloadedBlock),
+	}
+}
+
+// 没有正在下载区块头时 开始从对方下载区块头
+// 对方声明的高度不能说明其链的工作量 是否切换到对方的链由收到的区块头的累计工作量决定
+func (s *syncManager) startHeaderSync(p *Peer) {
+	s.mu.Lock()
+	if s.headerPeer != nil {
+		s.mu.Unlock()
+		return
+	}
+	s.headerPeer = p
+	locator := s.locator()
+	s.mu.Unlock()
+
+	s.n.log.Printf("Downloading headers from %s (height %d)", p.Addr(), p.BestHeight())
+	s.n.send(p, cmdGetHeaders, &getHeadersMsg{Locator: locator})
+}
+
+// 区块头链的末端加上主链的locator 调用者需持有s.mu
+func (s *syncManager) locator() [][]byte {
+	locator, err := s.n.bc.BlockLocator()
+	if err != nil {
+		s.n.log.Printf("Block locator: %v", err)
+	}
+	if len(s.headers) > 0 {
+		locator = append([][]byte{s.headers[len(s.headers)-1].Hash}, locator...)
+	}
+
+	return locator
+}
+
+// 验证并记录收到的区块头 区块头不连续或工作量证明无效时返回错误
+func (s *syncManager) handleHeaders(p *Peer, headers []*core.BlockHeader) error {
+	if len(headers) > maxHeaders {
+		return fmt.Errorf("%w: %d headers", ErrInvalidMessage, len(headers))
+	}
+
+	s.mu.Lock()
+	more, err := s.addHeaders(p, headers)
+	var locator [][]byte
+	if more {
+		locator = s.locator()
+	} else if s.headerPeer == p {
+		s.headerPeer = nil
+	}
+	s.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	if more {
+		s.n.send(p, cmdGetHeaders, &getHeadersMsg{Locator: locator})
+	}
+	// 区块可能在区块头下载完成之前就已经全部连接
+	if s.connectDownloaded() {
+		s.n.announceBlock(s.n.bc.Tip(), nil)
+	}
+	if !more {
+		s.dropWithoutMoreWork()
+	}
+	s.schedule()
+
+	return nil
+}
+
+// 区块头已经全部下载 但其累计工作量仍不超过主链时放弃这条区块头链
+func (s *syncManager) dropWithoutMoreWork() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.headers) > 0 && s.headerPeer == nil && !s.connecting && !s.exceedsTip() {
+		s.reset()
+	}
+}
+
+// 区块头链的累计工作量是否超过主链 调用者需持有s.mu
+func (s *syncManager) exceedsTip() bool {
+	if len(s.work) == 0 {
+		return false
+	}
+
+	tipWork, err := s.n.bc.ChainWork(s.n.bc.Tip())
+	if err != nil {
+		s.n.log.Printf("Chain work of the tip: %v", err)
+		return false
+	}
+
+	return s.work[len(s.work)-1].Cmp(tipWork) > 0
+}
+
+// 将区块头接到区块头链上 返回是否需要继续请求 调用者需持有s.mu
+func (s *syncManager) addHeaders(p *Peer, headers []*core.BlockHeader) (more bool, err error) {
+	if len(headers) == 0 {
+		return false, nil
+	}
+
+	// 难度调整需要的更早的区块头可以在本批 区块头链或区块链中
+	batch := make(map[string]*core.BlockHeader, len(headers))
+	fetch := func(hash []byte) (*core.BlockHeader, error) {
+		key := hex.EncodeToString(hash)
+		if header := batch[key]; header != nil {
+			return header, nil
+		}
+		if i, ok := s.index[key]; ok {
+			return s.headers[i], nil
+		}
+		block, err := s.n.bc.GetBlockByHash(hash)
+		if err != nil {
+			return nil, err
+		}
+		return block.Header(), nil
+	}
+
+	// 第一个区块头的父区块可以在区块头链中 也可以是区块链中的区块
+	first := headers[0]
+	prev, err := fetch(first.PrevBlockHash)
+	if err != nil {
+		return false, misbehaving(scoreUnconnectedHeaders, fmt.Errorf("%w: headers do not connect to a known block: %v", ErrInvalidMessage, err))
+	}
+	base, inHeaders := s.index[hex.EncodeToString(first.PrevBlockHash)]
+	var work *big.Int
+	if inHeaders {
+		work = s.work[base]
+		base++
+	} else if work, err = s.n.bc.ChainWork(first.PrevBlockHash); err != nil {
+		return false, err
+	}
+
+	params := s.n.bc.Params()
+	works := make([]*big.Int, len(headers))
+	for i, header := range headers {
+		if !bytes.Equal(header.PrevBlockHash, prev.Hash) || header.Height != prev.Height+1 {
+			return false, misbehaving(scoreInvalid, fmt.Errorf("%w: header %x does not follow %x", ErrInvalidMessage, header.Hash, prev.Hash))
+		}
+		bits, err := chain.NextRequiredBits(prev, fetch, params)
+		if err != nil {
+			return false, err
+		}
+		if header.Bits != bits {
+			return false, misbehaving(scoreInvalid, fmt.Errorf("%w: header %x has bits %08x, want %08x", ErrInvalidMessage, header.Hash, header.Bits, bits))
+		}
+		if err := chain.CheckHeaderProof(header, params); err != nil {
+			return false, misbehaving(scoreInvalid, fmt.Errorf("%w: %v", ErrInvalidMessage, err))
+		}
+
+		work = new(big.Int).Add(work, pow.CalcWork(header.Bits))
+		works[i] = work
+		batch[hex.EncodeToString(header.Hash)] = header
+		prev = header
+	}
+
+	last := headers[len(headers)-1]
+	p.updateHeight(last.Height)
+
+	// 只在累计工作量超过区块头链或主链时替换分叉点之后的区块头
+	// 区块头链为空且对方还有更多区块头时还无法比较 先接受这一批 但在工作量超过主链之前不下载区块
+	if len(s.headers) > 0 {
+		if work.Cmp(s.work[len(s.work)-1]) <= 0 {
+			return false, nil
+		}
+	} else if len(headers) < maxHeaders {
+		tipWork, err := s.n.bc.ChainWork(s.n.bc.Tip())
+		if err != nil {
+			return false, err
+		}
+		if work.Cmp(tipWork) <= 0 {
+			return false, nil
+		}
+	}
+	for _, header := range s.headers[base:] {
+		delete(s.index, hex.EncodeToString(header.Hash))
+	}
+	s.headers = append(s.headers[:base], headers...)
+	s.work = append(s.work[:base], works...)
+	for i := base; i < len(s.headers); i++ {
+		s.index[hex.EncodeToString(s.headers[i].Hash)] = i
+	}
+	if s.next > base {
+		s.next = base
+	}
+
+	if !s.syncing && s.exceedsTip() {
+		s.syncing = true
+		s.reportedAt = time.Now()
+		s.reportedHeight, _ = s.n.bc.GetBestHeight()
+	}
+
+	return len(headers) == maxHeaders, nil
+}
+
+// 向有空闲的连接请求下载窗口内尚未请求的区块
+func (s *syncManager) schedule() {
+	s.mu.Lock()
+	s.skipConnected()
+
+	now := time.Now()
+	for key, req := range s.requests {
+		if now.Sub(req.sent) > blockRequestTimeout {
+			s.n.log.Printf("Block %s from %s timed out", key, req.peer.Addr())
+			s.cancelRequest(key)
+		}
+	}
+
+	batches := s.nextRequests(s.n.Peers())
+	s.mu.Unlock()
+
+	for p, hashes := range batches {
+		s.n.send(p, cmdGetData, &getDataMsg{Type: invBlock, Items: hashes})
+	}
+}
+
+// 为下载窗口内尚未请求的区块选择连接并记录请求 返回向每个连接请求的区块
+// 区块头链的累计工作量超过主链之前不请求任何区块 调用者需持有s.mu
+func (s *syncManager) nextRequests(peers []*Peer) map[*Peer][][]byte {
+	batches := make(map[*Peer][][]byte)
+	if !s.exceedsTip() {
+		return batches
+	}
+
+	now := time.Now()
+	for i := s.next; i < len(s.headers) && i < s.next+downloadWindow; i++ {
+		header := s.headers[i]
+		key := hex.EncodeToString(header.Hash)
+		if s.downloaded[key] != nil || s.requests[key] != nil || s.n.bc.HasBlock(header.Hash) {
+			continue
+		}
+
+		// 选择拥有该区块且请求最少的连接
+		var best *Peer
+		for _, p := range peers {
+			if p.BestHeight() < header.Height || s.inFlight[p] >= maxBlocksInFlight {
+				continue
+			}
+			if best == nil || s.inFlight[p] < s.inFlight[best] {
+				best = p
+			}
+		}
+		if best == nil {
+			break
+		}
+
+		s.requests[key] = &blockRequest{peer: best, sent: now}
+		s.inFlight[best]++
+		batches[best] = append(batches[best], header.Hash)
+	}
+
+	return batches
+}
+
+// 跳过区块链中已有的区块 调用者需持有s.mu
+func (s *syncManager) skipConnected() {
+	for s.next < len(s.headers) {
+		hash := s.headers[s.next].Hash
+		if s.downloaded[hex.EncodeToString(hash)] != nil || !s.n.bc.HasBlock(hash) {
+			return
+		}
+		s.next++
+	}
+}
+
+// 调用者需持有s.mu
+func (s *syncManager) cancelRequest(key string) {
+	req := s.requests[key]
+	if req == nil {
+		return
+	}
+
+	delete(s.requests, key)
+	if s.inFlight[req.peer]--; s.inFlight[req.peer] <= 0 {
+		delete(s.inFlight, req.peer)
+	}
+}
+
+// 处理p发送的区块头链中的区块 按顺序连接所有已经到达的区块
+// 区块不在区块头链中时返回false 由调用者按普通的区块处理
+func (s *syncManager) blockReceived(p *Peer, block *core.Block) bool {
+	key := hex.EncodeToString(block.Hash)
+
+	s.mu.Lock()
+	if _, ok := s.index[key]; !ok {
+		s.mu.Unlock()
+		return false
+	}
+	s.cancelRequest(key)
+	s.downloaded[key] = &downloadedBlock{block: block, peer: p}
+	s.mu.Unlock()
+
+	if s.connectDownloaded() {
+		s.n.announceBlock(s.n.bc.Tip(), nil)
+	}
+	s.schedule()
+
+	return true
+}
+
+// 从s.next开始连接已经到达的区块 返回同步是否刚刚完成
+// 连接区块、惩罚连接与报告进度都不持有s.mu 同一时刻只有一个goroutine连接区块
+func (s *syncManager) connectDownloaded() bool {
+	s.mu.Lock()
+	if s.connecting {
+		s.mu.Unlock()
+		return false
+	}
+	s.connecting = true
+
+	for {
+		ready := s.takeReady()
+		if len(ready) == 0 {
+			break
+		}
+
+		s.mu.Unlock()
+		rejected := s.connectBlocks(ready)
+		s.mu.Lock()
+
+		if rejected {
+			// 区块头有效但区块不合法 放弃这条区块头链
+			s.reset()
+			break
+		}
+	}
+	s.connecting = false
+
+	if !s.syncing || s.headerPeer != nil || s.next < len(s.headers) {
+		s.mu.Unlock()
+		return false
+	}
+	s.syncing = false
+	progress := s.progress(true)
+	s.reset()
+	s.mu.Unlock()
+
+	s.report(progress)
+
+	return true
+}
+
+// 取出从s.next开始连续到达的区块并前移s.next 调用者需持有s.mu
+func (s *syncManager) takeReady() []*downloadedBlock {
+	var ready []*downloadedBlock
+	for {
+		s.skipConnected()
+		if s.next == len(s.headers) {
+			return ready
+		}
+
+		key := hex.EncodeToString(s.headers[s.next].Hash)
+		downloaded := s.downloaded[key]
+		if downloaded == nil {
+			return ready
+		}
+		delete(s.downloaded, key)
+		ready = append(ready, downloaded)
+		s.next++
+	}
+}
+
+// 依次连接区块 返回是否有区块被拒绝 调用者不能持有s.mu
+func (s *syncManager) connectBlocks(ready []*downloadedBlock) bool {
+	for _, downloaded := range ready {
+		block := downloaded.block
+		if _, _, err := s.n.addBlock(block); err != nil {
+			s.n.log.Printf("Rejected block %x at height %d: %v", block.Hash, block.Height, err)
+			if errors.Is(err, chain.ErrInvalidBlock) {
+				s.n.punish(downloaded.peer, invalidBlockScore(err), err)
+			}
+			return true
+		}
+	}
+
+	return false
+}
+
+// 清空区块头链与所有下载状态 调用者需持有s.mu
+func (s *syncManager) reset() {
+	s.headers = nil
+	s.index = make(map[string]int)
+	s.work = nil
+	s.next = 0
+	s.requests = make(map[string]*blockRequest)
+	s.inFlight = make(map[*Peer]int)
+	s.downloaded = make(map[string]*downloadedBlock)
+	s.syncing = false
+}
+
+// 连接断开后重新分配其尚未完成的请求 需要时改为从其他连接下载区块头
+func (s *syncManager) peerGone(p *Peer) {
+	s.mu.Lock()
+	for key, req := range s.requests {
+		if req.peer == p {
+			s.cancelRequest(key)
+		}
+	}
+	headerPeer := s.headerPeer == p
+	if headerPeer {
+		s.headerPeer = nil
+	}
+	s.mu.Unlock()
+
+	if headerPeer {
+		for _, other := range s.n.Peers() {
+			if other != p {
+				s.startHeaderSync(other)
+			}
+		}
+	}
+	s.schedule()
+}
+
+// 定期检查超时的请求并报告进度
+func (s *syncManager) tick() {
+	s.schedule()
+
+	s.mu.Lock()
+	if !s.syncing || time.Since(s.reportedAt) < progressInterval {
+		s.mu.Unlock()
+		return
+	}
+	progress := s.progress(false)
+	s.mu.Unlock()
+
+	s.report(progress)
+}
+
+// 计算同步进度并开始新的统计区间 调用者需持有s.mu
+func (s *syncManager) progress(done bool) SyncProgress {
+	height, _ := s.n.bc.GetBestHeight()
+	target := height
+	if len(s.headers) > 0 {
+		target = s.headers[len(s.headers)-1].Height
+	}
+
+	elapsed := time.Since(s.reportedAt).Seconds()
+	rate := 0.0
+	if elapsed > 0 {
+		rate = float64(height-s.reportedHeight) / elapsed
+	}
+	s.reportedAt = time.Now()
+	s.reportedHeight = height
+
+	return SyncProgress{Height: height, TargetHeight: target, BlocksPerSecond: rate, Done: done}
+}
+
+// 报告同步进度 调用者不能持有s.mu
+func (s *syncManager) report(progress SyncProgress) {
+	if s.n.cfg.Progress != nil {
+		s.n.cfg.Progress(progress)
+	}
+	if progress.Done {
+		s.n.log.Printf("Sync complete at height %d", progress.Height)
+	} else {
+		s.n.log.Printf("Syncing: height %d / %d (%.1f blocks/s)", progress.Height, progress.TargetHeight, progress.BlocksPerSecond)
+	}
+}
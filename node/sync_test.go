@@ -0,0 +1,240 @@
+package node
+
+import (
+	"errors"
+	"io/ioutil"
+	"log"
+	"net"
+	"testing"
+
+	"blockchain/core"
+	"blockchain/core/chain"
+	"blockchain/core/mempool"
+	"blockchain/internal/chaintest"
+	"blockchain/pow"
+)
+
+// 每两个区块调整一次难度的regtest 区块间隔远小于期望时难度上升
+func retargetParams() *chain.ChainParams {
+	params := chain.RegTestParams
+	params.Consensus.NoRetargeting = false
+	params.Consensus.RetargetInterval = 2
+
+	return &params
+}
+
+func newTestNode(t *testing.T, params *chain.ChainParams, cfg Config) *Node {
+	t.Helper()
+
+	bc := chaintest.NewChain(t, params)
+	mp, err := mempool.New(bc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Logger == nil {
+		cfg.Logger = log.New(ioutil.Discard, "", 0)
+	}
+	n, err := New(bc, mp, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return n
+}
+
+// 没有真实连接的Peer 只用于记录对方的高度
+func newTestPeer(t *testing.T) *Peer {
+	conn, other := net.Pipe()
+	t.Cleanup(func() {
+		conn.Close()
+		other.Close()
+	})
+
+	return newPeer(conn, false, chain.RegTestParams.Magic)
+}
+
+// 在区块链的创世块之后构造n个区块头 相邻区块的时间戳相差interval秒
+// 每个区块头使用链的规则要求的难度并完成工作量证明
+func newTestHeaders(t *testing.T, bc *chain.Blockchain, n int, interval int64) []*core.BlockHeader {
+	t.Helper()
+
+	return extendTestHeaders(t, bc, nil, n, interval)
+}
+
+// 与newTestHeaders相同 但新的区块头接在创世块之后的区块头链prev之后 只返回新的区块头
+func extendTestHeaders(t *testing.T, bc *chain.Blockchain, prevHeaders []*core.BlockHeader, n int, interval int64) []*core.BlockHeader {
+	t.Helper()
+
+	genesis, err := bc.GetBlockByHeight(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	known := map[string]*core.BlockHeader{string(genesis.Hash): genesis.Header()}
+	fetch := func(hash []byte) (*core.BlockHeader, error) {
+		return known[string(hash)], nil
+	}
+
+	var headers []*core.BlockHeader
+	prev := genesis.Header()
+	for _, header := range prevHeaders {
+		known[string(header.Hash)] = header
+		prev = header
+	}
+	for i := 0; i < n; i++ {
+		bits, err := chain.NextRequiredBits(prev, fetch, bc.Params())
+		if err != nil {
+			t.Fatal(err)
+		}
+		block := &core.Block{
+			Timestamp:     prev.Timestamp + interval,
+			PrevBlockHash: prev.Hash,
+			MerkleRoot:    make([]byte, 32),
+			Bits:          bits,
+			Height:        prev.Height + 1,
+		}
+		solveHeader(block)
+
+		header := block.Header()
+		known[string(header.Hash)] = header
+		headers = append(headers, header)
+		prev = header
+	}
+
+	return headers
+}
+
+func solveHeader(block *core.Block) {
+	block.Nonce, block.Hash = pow.NewProofOfWork(block).Run()
+}
+
+func headerChainWork(headers []*core.BlockHeader) int64 {
+	var work int64
+	for _, header := range headers {
+		work += pow.CalcWork(header.Bits).Int64()
+	}
+
+	return work
+}
+
+func TestAddHeadersPrefersMostWork(t *testing.T) {
+	params := retargetParams()
+	target := int64(params.Consensus.TargetBlockTime.Seconds())
+
+	n := newTestNode(t, params, Config{})
+	// 区块间隔很短的链难度逐渐上升 较少的区块就有更多的工作量
+	fast := newTestHeaders(t, n.bc, 5, 1)
+	slow := newTestHeaders(t, n.bc, 8, 10*target)
+	if headerChainWork(fast) <= headerChainWork(slow) {
+		t.Fatalf("test setup: fast chain work %d is not above slow chain work %d", headerChainWork(fast), headerChainWork(slow))
+	}
+
+	tests := []struct {
+		name   string
+		chains [][]*core.BlockHeader
+	}{
+		{"higher chain with less work is ignored", [][]*core.BlockHeader{fast, slow}},
+		{"lower chain with more work replaces", [][]*core.BlockHeader{slow, fast}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			n := newTestNode(t, params, Config{})
+			p := newTestPeer(t)
+
+			for _, headers := range tt.chains {
+				if _, err := n.sync.addHeaders(p, headers); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			got := n.sync.headers[len(n.sync.headers)-1]
+			if want := fast[len(fast)-1]; string(got.Hash) != string(want.Hash) {
+				t.Fatalf("header chain ends at height %d, want the fast chain at height %d", got.Height, want.Height)
+			}
+		})
+	}
+
+	// 工作量不超过主链的区块头链不被接受
+	t.Run("chain without more work than the tip", func(t *testing.T) {
+		n := newTestNode(t, params, Config{})
+		chaintest.MineBlocks(t, n.bc, 3, make([]byte, 20))
+
+		if _, err := n.sync.addHeaders(newTestPeer(t), newTestHeaders(t, n.bc, 2, 10*target)); err != nil {
+			t.Fatal(err)
+		}
+		if len(n.sync.headers) != 0 {
+			t.Fatalf("a fork with less work than the main chain was accepted: %d headers", len(n.sync.headers))
+		}
+	})
+}
+
+// 一整批区块头的工作量不超过主链时暂时保留 但直到工作量超过主链才开始下载区块
+func TestFullHeaderBatchWaitsForMoreWork(t *testing.T) {
+	params := retargetParams()
+	target := int64(params.Consensus.TargetBlockTime.Seconds())
+
+	// 快速挖出的区块难度逐渐上升 主链的工作量超过一整批最低难度的区块头
+	n := newTestNode(t, params, Config{})
+	chaintest.MineBlocks(t, n.bc, 12, make([]byte, 20))
+	tipWork, err := n.bc.ChainWork(n.bc.Tip())
+	if err != nil {
+		t.Fatal(err)
+	}
+	genesisWork := pow.CalcWork(params.Consensus.PowLimitBits).Int64()
+
+	headers := newTestHeaders(t, n.bc, maxHeaders, 10*target)
+	if work := genesisWork + headerChainWork(headers); work >= tipWork.Int64() {
+		t.Fatalf("test setup: header chain work %d is not below the tip work %d", work, tipWork)
+	}
+	p := newTestPeer(t)
+
+	more, err := n.sync.addHeaders(p, headers)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !more || len(n.sync.headers) != maxHeaders {
+		t.Fatalf("addHeaders() of a full batch = %v with %d headers kept, want more with %d", more, len(n.sync.headers), maxHeaders)
+	}
+	if requests := n.sync.nextRequests([]*Peer{p}); len(requests) != 0 {
+		t.Fatalf("blocks of a header chain with less work than the tip were requested: %d peers", len(requests))
+	}
+
+	// 下一批区块头使工作量超过主链后开始下载
+	var extra []*core.BlockHeader
+	for genesisWork+headerChainWork(headers)+headerChainWork(extra) <= tipWork.Int64() {
+		extra = append(extra, extendTestHeaders(t, n.bc, append(headers, extra...), 100, 10*target)...)
+	}
+	if _, err := n.sync.addHeaders(p, extra); err != nil {
+		t.Fatal(err)
+	}
+	if requests := n.sync.nextRequests([]*Peer{p}); len(requests[p]) != maxBlocksInFlight {
+		t.Fatalf("%d blocks were requested after the header chain passed the tip, want %d", len(requests[p]), maxBlocksInFlight)
+	}
+}
+
+func TestAddHeadersChecksRequiredBits(t *testing.T) {
+	params := retargetParams()
+	n := newTestNode(t, params, Config{})
+
+	// 第二个区块需要提高难度 使用更低或更高的难度都不合法
+	for _, bits := range []uint32{params.Consensus.PowLimitBits, 0x1f7fffff} {
+		headers := newTestHeaders(t, n.bc, 2, 1)
+		if headers[1].Bits == bits {
+			t.Fatalf("test setup: required bits are already %08x", bits)
+		}
+
+		block := headers[1].Block()
+		block.Bits = bits
+		solveHeader(block)
+		headers[1] = block.Header()
+
+		_, err := n.sync.addHeaders(newTestPeer(t), headers)
+		var m *misbehavior
+		if !errors.Is(err, ErrInvalidMessage) || !errors.As(err, &m) {
+			t.Fatalf("addHeaders() with bits %08x error = %v, want misbehavior", bits, err)
+		}
+		if len(n.sync.headers) != 0 {
+			t.Fatalf("headers with bits %08x were accepted", bits)
+		}
+	}
+}
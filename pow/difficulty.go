@@ -0,0 +1,98 @@
+package pow
+
+import "math/big"
+
+// 每次难度调整的最大倍数
+const maxRetargetFactor = 4
+
+// CompactToBig 将比特币的compact格式("bits")解码为目标值
+// 最高字节为以字节计的长度 低三字节为尾数 尾数的最高位为符号位
+func CompactToBig(compact uint32) *big.Int {
+	mantissa := compact & 0x007fffff
+	isNegative := compact&0x00800000 != 0
+	exponent := uint(compact >> 24)
+
+	var bn *big.Int
+	if exponent <= 3 {
+		mantissa >>= 8 * (3 - exponent)
+		bn = big.NewInt(int64(mantissa))
+	} else {
+		bn = big.NewInt(int64(mantissa))
+		bn.Lsh(bn, 8*(exponent-3))
+	}
+
+	if isNegative {
+		bn = bn.Neg(bn)
+	}
+
+	return bn
+}
+
+// BigToCompact 将目标值编码为compact格式 低位精度会被舍弃
+func BigToCompact(n *big.Int) uint32 {
+	if n.Sign() == 0 {
+		return 0
+	}
+
+	var mantissa uint32
+	abs := new(big.Int).Abs(n)
+	exponent := uint(len(abs.Bytes()))
+	if exponent <= 3 {
+		mantissa = uint32(abs.Uint64())
+		mantissa <<= 8 * (3 - exponent)
+	} else {
+		mantissa = uint32(new(big.Int).Rsh(abs, 8*(exponent-3)).Uint64())
+	}
+
+	// 尾数的最高位为符号位 需要将其腾出
+	if mantissa&0x00800000 != 0 {
+		mantissa >>= 8
+		exponent++
+	}
+
+	compact := uint32(exponent<<24) | mantissa
+	if n.Sign() < 0 {
+		compact |= 0x00800000
+	}
+
+	return compact
+}
+
+// CalcNextBits 根据实际耗时与期望耗时的比值调整难度
+// 单次调整的幅度限制在4倍以内 调整后的难度不会低于powLimitBits
+func CalcNextBits(prevBits uint32, actualTimespan, targetTimespan int64, powLimitBits uint32) uint32 {
+	if actualTimespan < targetTimespan/maxRetargetFactor {
+		actualTimespan = targetTimespan / maxRetargetFactor
+	}
+	if actualTimespan > targetTimespan*maxRetargetFactor {
+		actualTimespan = targetTimespan * maxRetargetFactor
+	}
+	// 避免目标值变为0导致之后再也无法出块
+	if actualTimespan < 1 {
+		actualTimespan = 1
+	}
+
+	// 新的目标值 = 旧的目标值 * 实际耗时 / 期望耗时
+	newTarget := CompactToBig(prevBits)
+	newTarget.Mul(newTarget, big.NewInt(actualTimespan))
+	newTarget.Div(newTarget, big.NewInt(targetTimespan))
+
+	powLimit := CompactToBig(powLimitBits)
+	if newTarget.Cmp(powLimit) > 0 {
+		newTarget = powLimit
+	}
+
+	return BigToCompact(newTarget)
+}
+
+// CalcWork 计算难度为bits的区块代表的工作量 即找到满足目标值的hash平均需要尝试的次数
+// work = 2^256 / (target + 1)
+func CalcWork(bits uint32) *big.Int {
+	target := CompactToBig(bits)
+	if target.Sign() <= 0 {
+		return big.NewInt(0)
+	}
+
+	denominator := new(big.Int).Add(target, big.NewInt(1))
+	return new(big.Int).Div(new(big.Int).Lsh(big.NewInt(1), 256), denominator)
+}
@@ -0,0 +1,97 @@
+package pow
+
+import (
+	"math/big"
+	"strings"
+	"testing"
+)
+
+func TestCompactRoundTrip(t *testing.T) {
+	tests := []struct {
+		name    string
+		compact uint32
+		target  string
+		// BigToCompact返回的规范编码
+		want uint32
+	}{
+		{"mainnet limit", 0x1f010000, "0x1" + strings.Repeat("0", 60), 0x1f010000},
+		{"regtest limit", 0x207fffff, "0x7fffff" + strings.Repeat("0", 58), 0x207fffff},
+		{"three byte target", 0x03123456, "0x123456", 0x03123456},
+		{"short exponent drops bytes", 0x01123456, "0x12", 0x01120000},
+		{"short exponent drops everything", 0x01003456, "0x0", 0},
+		{"zero", 0, "0x0", 0},
+		// 尾数的最高位为符号位 最高字节不小于0x80的目标值需要多用一个字节
+		{"mantissa overflow into the sign bit", 0x02008000, "0x80", 0x02008000},
+		{"long mantissa overflow into the sign bit", 0x05009234, "0x92340000", 0x05009234},
+		{"negative", 0x04923456, "-0x12345600", 0x04923456},
+		{"negative with short exponent", 0x01fedcba, "-0x7e", 0x01fe0000},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			target := CompactToBig(tt.compact)
+			want, ok := new(big.Int).SetString(tt.target, 0)
+			if !ok {
+				t.Fatalf("bad target %s", tt.target)
+			}
+			if target.Cmp(want) != 0 {
+				t.Fatalf("CompactToBig(%#08x) = %#x, want %s", tt.compact, target, tt.target)
+			}
+			if compact := BigToCompact(target); compact != tt.want {
+				t.Fatalf("BigToCompact(%#x) = %#08x, want %#08x", target, compact, tt.want)
+			}
+		})
+	}
+
+	// 超过三个字节的精度被舍弃
+	if compact := BigToCompact(big.NewInt(0x12345678)); compact != 0x04123456 {
+		t.Fatalf("BigToCompact(0x12345678) = %#08x, want 0x04123456", compact)
+	}
+}
+
+func TestCalcNextBits(t *testing.T) {
+	const (
+		powLimitBits   = 0x1f010000
+		prevBits       = 0x1e010000
+		targetTimespan = 100
+	)
+
+	tests := []struct {
+		name     string
+		prevBits uint32
+		actual   int64
+		want     uint32
+	}{
+		{"on schedule", prevBits, targetTimespan, prevBits},
+		{"twice as slow", prevBits, 2 * targetTimespan, 0x1e020000},
+		{"twice as fast", prevBits, targetTimespan / 2, 0x1e008000},
+		{"four times as slow", prevBits, 4 * targetTimespan, 0x1e040000},
+		{"slower than four times is clamped", prevBits, 10 * targetTimespan, 0x1e040000},
+		{"four times as fast", prevBits, targetTimespan / 4, 0x1d400000},
+		{"faster than four times is clamped", prevBits, 1, 0x1d400000},
+		{"timestamps going backwards are clamped", prevBits, -targetTimespan, 0x1d400000},
+		{"easier than the limit", powLimitBits, 2 * targetTimespan, powLimitBits},
+		{"easier than the limit after clamping", 0x1f008000, 10 * targetTimespan, powLimitBits},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if bits := CalcNextBits(tt.prevBits, tt.actual, targetTimespan, powLimitBits); bits != tt.want {
+				t.Fatalf("CalcNextBits(%#08x, %d, %d) = %#08x, want %#08x", tt.prevBits, tt.actual, targetTimespan, bits, tt.want)
+			}
+		})
+	}
+}
+
+func TestCalcWork(t *testing.T) {
+	// 目标值减半时工作量加倍
+	if work, double := CalcWork(0x1e010000), CalcWork(0x1e008000); new(big.Int).Lsh(work, 1).Cmp(double) > 0 || double.Cmp(work) <= 0 {
+		t.Fatalf("CalcWork() = %s for twice the difficulty of %s", double, work)
+	}
+
+	for _, bits := range []uint32{0, 0x04923456} {
+		if work := CalcWork(bits); work.Sign() != 0 {
+			t.Fatalf("CalcWork(%#08x) = %s, want 0 for a target that is not positive", bits, work)
+		}
+	}
+}
@@ -0,0 +1,229 @@
+// Package pow 实现了区块的工作量证明
+package pow
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"math"
+	"math/big"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"blockchain/core"
+)
+
+const (
+	// 每个时间戳下尝试的最大nonce 用尽后将区块的时间戳加一继续搜索
+	maxNonce = math.MaxInt32
+	// 每个worker每尝试这么多个nonce汇报一次计数并检查是否需要停止
+	checkInterval = 1 << 12
+)
+
+// ProofOfWork 对一个区块进行工作量证明 target为区块Bits字段解码后hash需要小于的目标值
+type ProofOfWork struct {
+	block  *core.Block
+	target *big.Int
+}
+
+// NewProofOfWork 为区块创建工作量证明 目标值取自区块头中声明的Bits
+func NewProofOfWork(b *core.Block) *ProofOfWork {
+	target := CompactToBig(b.Bits)
+
+	pow := &ProofOfWork{b, target}
+	return pow
+}
+
+// convert all blockchain data to byte array
+func (pow *ProofOfWork) prepareData(nonce int) []byte {
+	data := bytes.Join([][]byte{
+		pow.block.PrevBlockHash,
+		pow.block.MerkleRoot,
+		intToHex(pow.block.Timestamp),
+		intToHex(int64(pow.block.Bits)),
+		intToHex(pow.block.Height),
+		intToHex(int64(nonce)),
+	}, []byte{})
+
+	return data
+}
+
+// Run 使用所有CPU搜索nonce直到区块的hash小于目标值 返回找到的nonce与hash
+// 当前时间戳下的nonce用尽时会将区块的时间戳加一
+func (pow *ProofOfWork) Run() (int, []byte) {
+	nonce, hash, _ := pow.Solve(context.Background(), SolveOptions{})
+
+	return nonce, hash
+}
+
+// Solve 将nonce空间交错地划分给多个worker并行搜索 找到的nonce与hash可以通过Validate
+// 当前时间戳下的nonce用尽时会将区块的时间戳加一继续搜索 ctx被取消时返回ctx.Err()
+func (pow *ProofOfWork) Solve(ctx context.Context, opts SolveOptions) (int, []byte, error) {
+	workers := opts.Workers
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+
+	var hashes uint64
+	if opts.HashRate != nil {
+		interval := opts.ReportInterval
+		if interval <= 0 {
+			interval = time.Second
+		}
+
+		var wg sync.WaitGroup
+		done := make(chan struct{})
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			reportHashRate(&hashes, interval, opts.HashRate, done)
+		}()
+		defer wg.Wait()
+		defer close(done)
+	}
+
+	for {
+		if sol, ok := pow.search(ctx, workers, maxNonce, &hashes); ok {
+			return sol.nonce, sol.hash, nil
+		}
+		if err := ctx.Err(); err != nil {
+			return 0, nil, err
+		}
+
+		pow.block.Timestamp++
+	}
+}
+
+// SolveOptions 并行工作量证明的参数
+type SolveOptions struct {
+	// Workers 并行搜索的goroutine数 小于等于0时使用runtime.NumCPU()
+	Workers int
+	// HashRate 不为nil时每隔ReportInterval以每秒计算的hash数调用一次
+	HashRate func(hashesPerSecond float64)
+	// ReportInterval 汇报hash速率的间隔 默认为1秒
+	ReportInterval time.Duration
+}
+
+type solution struct {
+	nonce int
+	hash  []byte
+}
+
+// 在区块当前的时间戳下搜索[0, end]中的nonce 第i个worker依次尝试i, i+workers, i+2*workers...
+// 任意一个worker找到结果或ctx被取消后所有worker停止 nonce用尽时返回false
+func (pow *ProofOfWork) search(ctx context.Context, workers, end int, hashes *uint64) (solution, bool) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// 区块头中nonce之前的部分在本轮搜索中保持不变
+	header := pow.prepareData(0)
+	prefix := header[:len(header)-8]
+
+	results := make(chan solution, workers)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func(start int) {
+			defer wg.Done()
+			if sol, ok := pow.searchRange(ctx, prefix, start, workers, end, hashes); ok {
+				results <- sol
+				cancel()
+			}
+		}(i)
+	}
+	wg.Wait()
+	close(results)
+
+	sol, ok := <-results
+	return sol, ok
+}
+
+// 从start开始以step为步长尝试不超过end的nonce 每checkInterval次检查一次是否需要停止
+func (pow *ProofOfWork) searchRange(ctx context.Context, prefix []byte, start, step, end int, hashes *uint64) (solution, bool) {
+	var hashInt big.Int
+	data := make([]byte, len(prefix)+8)
+	copy(data, prefix)
+
+	var count uint64
+	defer func() { atomic.AddUint64(hashes, count) }()
+
+	// nonce溢出为负数时同样视为用尽
+	for nonce := start; nonce >= 0 && nonce <= end; nonce += step {
+		if count%checkInterval == checkInterval-1 {
+			atomic.AddUint64(hashes, count)
+			count = 0
+
+			select {
+			case <-ctx.Done():
+				return solution{}, false
+			default:
+			}
+		}
+		count++
+
+		binary.BigEndian.PutUint64(data[len(prefix):], uint64(nonce))
+		hash := sha256.Sum256(data)
+		// 只要比pow.target小则是一个合法的hash
+		hashInt.SetBytes(hash[:])
+		if hashInt.Cmp(pow.target) == -1 {
+			return solution{nonce, hash[:]}, true
+		}
+	}
+
+	return solution{}, false
+}
+
+// 每隔interval以每秒hash数汇报一次计算速率 直到done被关闭
+func reportHashRate(hashes *uint64, interval time.Duration, report func(float64), done <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	last := time.Now()
+	var lastCount uint64
+	for {
+		select {
+		case <-done:
+			return
+		case now := <-ticker.C:
+			count := atomic.LoadUint64(hashes)
+			report(float64(count-lastCount) / now.Sub(last).Seconds())
+			last, lastCount = now, count
+		}
+	}
+}
+
+// Hash 使用区块当前的Nonce计算区块头的hash
+func (pow *ProofOfWork) Hash() []byte {
+	hash := sha256.Sum256(pow.prepareData(pow.block.Nonce))
+
+	return hash[:]
+}
+
+// Validate 验证当前的工作量证明是否有效
+// requiredBits为链的规则在该高度要求的难度 区块声明的Bits必须与其一致 且hash需要小于对应的目标值
+func (pow *ProofOfWork) Validate(requiredBits uint32) bool {
+	var hashInt big.Int
+
+	if pow.block.Bits != requiredBits || pow.target.Sign() <= 0 {
+		return false
+	}
+
+	hashInt.SetBytes(pow.Hash())
+
+	// 目前的工作量证明的验证是求块中所有数据的hash再判断其是否符合目标
+	isValid := hashInt.Cmp(pow.target) == -1
+
+	return isValid
+}
+
+// 将int64转化为byte数组的工具函数
+func intToHex(num int64) []byte {
+	buff := make([]byte, 8)
+	// use binary library to convert to hex as BigEndian
+	binary.BigEndian.PutUint64(buff, uint64(num))
+
+	return buff
+}
@@ -0,0 +1,130 @@
+package pow
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"math/big"
+	"runtime"
+	"testing"
+	"time"
+
+	"blockchain/core"
+)
+
+// regtest使用的最低难度
+const easyBits = 0x207fffff
+
+func newTestBlock(bits uint32) *core.Block {
+	return &core.Block{
+		Timestamp:     time.Now().Unix(),
+		PrevBlockHash: make([]byte, 32),
+		MerkleRoot:    make([]byte, 32),
+		Bits:          bits,
+		Height:        1,
+	}
+}
+
+func TestSolveResultValidates(t *testing.T) {
+	for _, workers := range []int{1, 3, 8} {
+		block := newTestBlock(easyBits)
+		pow := NewProofOfWork(block)
+
+		nonce, hash, err := pow.Solve(context.Background(), SolveOptions{Workers: workers})
+		if err != nil {
+			t.Fatal(err)
+		}
+		block.Nonce = nonce
+
+		if !pow.Validate(easyBits) {
+			t.Fatalf("workers=%d: solved header with nonce %d does not validate", workers, nonce)
+		}
+		if string(pow.Hash()) != string(hash) {
+			t.Fatalf("workers=%d: Solve() hash = %x, header hash = %x", workers, hash, pow.Hash())
+		}
+		// 区块声明的难度与链要求的不一致时无效
+		if pow.Validate(easyBits - 1) {
+			t.Fatalf("workers=%d: header validates against different required bits", workers)
+		}
+	}
+}
+
+func TestSolveCancelStopsWorkers(t *testing.T) {
+	before := runtime.NumGoroutine()
+
+	// 目标值为1 实际上不可能找到结果
+	pow := NewProofOfWork(newTestBlock(0x03000001))
+	ctx, cancel := context.WithCancel(context.Background())
+
+	done := make(chan error, 1)
+	go func() {
+		_, _, err := pow.Solve(ctx, SolveOptions{Workers: 4, HashRate: func(float64) {}, ReportInterval: time.Millisecond})
+		done <- err
+	}()
+
+	time.Sleep(50 * time.Millisecond)
+	cancel()
+
+	select {
+	case err := <-done:
+		if !errors.Is(err, context.Canceled) {
+			t.Fatalf("Solve() after cancel error = %v, want %v", err, context.Canceled)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Solve() did not return after the context was canceled")
+	}
+
+	// 所有worker与汇报速率的goroutine都应当退出
+	deadline := time.Now().Add(time.Second)
+	for runtime.NumGoroutine() > before {
+		if time.Now().After(deadline) {
+			t.Fatalf("%d goroutines still running after Solve() returned, want %d", runtime.NumGoroutine(), before)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestSearchCoversNonceRange(t *testing.T) {
+	// 跨越多个检查间隔 且不能被worker数整除
+	const end = 3*checkInterval + 5
+
+	for _, timestamp := range []int64{1, 2, 3} {
+		block := newTestBlock(easyBits)
+		block.Timestamp = timestamp
+		pow := NewProofOfWork(block)
+
+		// 找到[0, end]中hash最小的nonce 以它的hash加一为目标值时只有它满足条件
+		header := pow.prepareData(0)
+		data := append([]byte{}, header...)
+		var min *big.Int
+		minNonce := -1
+		for nonce := 0; nonce <= end; nonce++ {
+			binary.BigEndian.PutUint64(data[len(data)-8:], uint64(nonce))
+			hash := sha256.Sum256(data)
+			n := new(big.Int).SetBytes(hash[:])
+			if min == nil || n.Cmp(min) < 0 {
+				min, minNonce = n, nonce
+			}
+		}
+
+		for workers := 1; workers <= 8; workers++ {
+			pow.target = new(big.Int).Add(min, big.NewInt(1))
+			var hashes uint64
+			sol, ok := pow.search(context.Background(), workers, end, &hashes)
+			if !ok || sol.nonce != minNonce {
+				t.Fatalf("timestamp=%d workers=%d: search() = %d, %v, want %d", timestamp, workers, sol.nonce, ok, minNonce)
+			}
+
+			// 没有满足条件的nonce时 每个nonce恰好被尝试一次
+			pow.target = big.NewInt(0)
+			hashes = 0
+			if _, ok := pow.search(context.Background(), workers, end, &hashes); ok {
+				t.Fatalf("workers=%d: search() found a solution below a zero target", workers)
+			}
+			if hashes != end+1 {
+				t.Fatalf("workers=%d: search() tried %d nonces, want %d", workers, hashes, end+1)
+			}
+		}
+	}
+}
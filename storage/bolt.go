@@ -0,0 +1,133 @@
+package storage
+
+import (
+	"os"
+
+	"github.com/boltdb/bolt"
+)
+
+// BoltStore 基于BoltDB的存储 每个bucket对应BoltDB中的一个同名bucket
+type BoltStore struct {
+	db *bolt.DB
+}
+
+// OpenBolt 打开path对应的BoltDB数据库 文件不存在时会被创建
+func OpenBolt(path string) (*BoltStore, error) {
+	db, err := bolt.Open(path, 0600, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return &BoltStore{db}, nil
+}
+
+// BoltExists 判断path对应的数据库文件是否存在
+func BoltExists(path string) bool {
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		return false
+	}
+	return true
+}
+
+func (s *BoltStore) View(fn func(Tx) error) error {
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return fn(boltTx{tx})
+	})
+
+	return convertBoltErr(err)
+}
+
+func (s *BoltStore) Update(fn func(Tx) error) error {
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		return fn(boltTx{tx})
+	})
+
+	return convertBoltErr(err)
+}
+
+func (s *BoltStore) Batch(fn func(Tx) error) error {
+	err := s.db.Batch(func(tx *bolt.Tx) error {
+		return fn(boltTx{tx})
+	})
+
+	return convertBoltErr(err)
+}
+
+func (s *BoltStore) Close() error {
+	return s.db.Close()
+}
+
+type boltTx struct {
+	tx *bolt.Tx
+}
+
+func (t boltTx) Bucket(name string) Bucket {
+	b := t.tx.Bucket([]byte(name))
+	if b == nil {
+		return nil
+	}
+
+	return boltBucket{b}
+}
+
+func (t boltTx) CreateBucket(name string) (Bucket, error) {
+	b, err := t.tx.CreateBucket([]byte(name))
+	if err != nil {
+		return nil, convertBoltErr(err)
+	}
+
+	return boltBucket{b}, nil
+}
+
+func (t boltTx) CreateBucketIfNotExists(name string) (Bucket, error) {
+	b, err := t.tx.CreateBucketIfNotExists([]byte(name))
+	if err != nil {
+		return nil, convertBoltErr(err)
+	}
+
+	return boltBucket{b}, nil
+}
+
+func (t boltTx) DeleteBucket(name string) error {
+	return convertBoltErr(t.tx.DeleteBucket([]byte(name)))
+}
+
+type boltBucket struct {
+	b *bolt.Bucket
+}
+
+func (b boltBucket) Get(key []byte) []byte {
+	return b.b.Get(key)
+}
+
+func (b boltBucket) Put(key, value []byte) error {
+	return convertBoltErr(b.b.Put(key, value))
+}
+
+func (b boltBucket) Delete(key []byte) error {
+	return convertBoltErr(b.b.Delete(key))
+}
+
+func (b boltBucket) ForEach(fn func(k, v []byte) error) error {
+	return b.b.ForEach(fn)
+}
+
+func (b boltBucket) Cursor() Cursor {
+	return b.b.Cursor()
+}
+
+// 将BoltDB的错误转换为本包定义的错误
+func convertBoltErr(err error) error {
+	switch err {
+	case bolt.ErrBucketNotFound:
+		return ErrBucketNotFound
+	case bolt.ErrBucketExists:
+		return ErrBucketExists
+	case bolt.ErrTxNotWritable:
+		return ErrTxNotWritable
+	case bolt.ErrDatabaseNotOpen:
+		return ErrStoreClosed
+	}
+
+	return err
+}
@@ -0,0 +1,259 @@
+package storage
+
+import (
+	"bytes"
+	"sort"
+	"sync"
+)
+
+// MemoryStore 纯内存的存储 主要用于测试 关闭后数据即丢失
+// 读写事务之间互斥 读写事务返回错误时根据记录的旧值回滚
+type MemoryStore struct {
+	mu      sync.RWMutex
+	buckets map[string]map[string][]byte
+	closed  bool
+}
+
+// NewMemory 创建一个空的内存存储
+func NewMemory() *MemoryStore {
+	return &MemoryStore{buckets: make(map[string]map[string][]byte)}
+}
+
+func (s *MemoryStore) View(fn func(Tx) error) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if s.closed {
+		return ErrStoreClosed
+	}
+
+	return fn(&memoryTx{store: s})
+}
+
+func (s *MemoryStore) Update(fn func(Tx) error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return ErrStoreClosed
+	}
+
+	tx := &memoryTx{store: s, writable: true}
+	if err := fn(tx); err != nil {
+		tx.rollback()
+		return err
+	}
+
+	return nil
+}
+
+func (s *MemoryStore) Batch(fn func(Tx) error) error {
+	return s.Update(fn)
+}
+
+func (s *MemoryStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.closed = true
+	s.buckets = nil
+
+	return nil
+}
+
+// 回滚所需的记录 wholeOp为true时记录整个bucket的旧值(oldBucket为nil表示bucket原本不存在) 否则记录单个key的旧值
+type undoEntry struct {
+	bucket    string
+	key       string
+	value     []byte
+	existed   bool
+	oldBucket map[string][]byte
+	wholeOp   bool
+}
+
+type memoryTx struct {
+	store    *MemoryStore
+	writable bool
+	undo     []undoEntry
+}
+
+func (t *memoryTx) rollback() {
+	for i := len(t.undo) - 1; i >= 0; i-- {
+		u := t.undo[i]
+		if u.wholeOp {
+			if u.oldBucket == nil {
+				delete(t.store.buckets, u.bucket)
+			} else {
+				t.store.buckets[u.bucket] = u.oldBucket
+			}
+			continue
+		}
+
+		b := t.store.buckets[u.bucket]
+		if u.existed {
+			b[u.key] = u.value
+		} else {
+			delete(b, u.key)
+		}
+	}
+	t.undo = nil
+}
+
+func (t *memoryTx) Bucket(name string) Bucket {
+	if _, ok := t.store.buckets[name]; !ok {
+		return nil
+	}
+
+	return &memoryBucket{tx: t, name: name}
+}
+
+func (t *memoryTx) CreateBucket(name string) (Bucket, error) {
+	if !t.writable {
+		return nil, ErrTxNotWritable
+	}
+	if _, ok := t.store.buckets[name]; ok {
+		return nil, ErrBucketExists
+	}
+
+	t.undo = append(t.undo, undoEntry{bucket: name, wholeOp: true})
+	t.store.buckets[name] = make(map[string][]byte)
+
+	return &memoryBucket{tx: t, name: name}, nil
+}
+
+func (t *memoryTx) CreateBucketIfNotExists(name string) (Bucket, error) {
+	if b := t.Bucket(name); b != nil {
+		return b, nil
+	}
+
+	return t.CreateBucket(name)
+}
+
+func (t *memoryTx) DeleteBucket(name string) error {
+	if !t.writable {
+		return ErrTxNotWritable
+	}
+	old, ok := t.store.buckets[name]
+	if !ok {
+		return ErrBucketNotFound
+	}
+
+	t.undo = append(t.undo, undoEntry{bucket: name, wholeOp: true, oldBucket: old})
+	delete(t.store.buckets, name)
+
+	return nil
+}
+
+type memoryBucket struct {
+	tx   *memoryTx
+	name string
+}
+
+func (b *memoryBucket) data() map[string][]byte {
+	return b.tx.store.buckets[b.name]
+}
+
+func (b *memoryBucket) Get(key []byte) []byte {
+	return b.data()[string(key)]
+}
+
+func (b *memoryBucket) Put(key, value []byte) error {
+	if !b.tx.writable {
+		return ErrTxNotWritable
+	}
+
+	data := b.data()
+	old, existed := data[string(key)]
+	b.tx.undo = append(b.tx.undo, undoEntry{bucket: b.name, key: string(key), value: old, existed: existed})
+	// 复制一份数据 避免调用方之后修改传入的切片
+	data[string(key)] = append([]byte{}, value...)
+
+	return nil
+}
+
+func (b *memoryBucket) Delete(key []byte) error {
+	if !b.tx.writable {
+		return ErrTxNotWritable
+	}
+
+	data := b.data()
+	old, existed := data[string(key)]
+	if !existed {
+		return nil
+	}
+	b.tx.undo = append(b.tx.undo, undoEntry{bucket: b.name, key: string(key), value: old, existed: true})
+	delete(data, string(key))
+
+	return nil
+}
+
+func (b *memoryBucket) ForEach(fn func(k, v []byte) error) error {
+	c := b.Cursor()
+	for k, v := c.First(); k != nil; k, v = c.Next() {
+		if err := fn(k, v); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (b *memoryBucket) Cursor() Cursor {
+	data := b.data()
+	keys := make([]string, 0, len(data))
+	for k := range data {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	return &memoryCursor{bucket: b, keys: keys, pos: -1}
+}
+
+// 游标在创建时对key进行快照 遍历过程中被删除的key会被跳过
+type memoryCursor struct {
+	bucket *memoryBucket
+	keys   []string
+	pos    int
+}
+
+// 从pos开始沿step方向找到第一个仍然存在的key
+func (c *memoryCursor) move(pos, step int) ([]byte, []byte) {
+	data := c.bucket.data()
+	for ; pos >= 0 && pos < len(c.keys); pos += step {
+		if v, ok := data[c.keys[pos]]; ok {
+			c.pos = pos
+			return []byte(c.keys[pos]), v
+		}
+	}
+
+	if pos < 0 {
+		c.pos = -1
+	} else {
+		c.pos = len(c.keys)
+	}
+	return nil, nil
+}
+
+func (c *memoryCursor) First() ([]byte, []byte) {
+	return c.move(0, 1)
+}
+
+func (c *memoryCursor) Last() ([]byte, []byte) {
+	return c.move(len(c.keys)-1, -1)
+}
+
+func (c *memoryCursor) Next() ([]byte, []byte) {
+	return c.move(c.pos+1, 1)
+}
+
+func (c *memoryCursor) Prev() ([]byte, []byte) {
+	return c.move(c.pos-1, -1)
+}
+
+func (c *memoryCursor) Seek(seek []byte) ([]byte, []byte) {
+	pos := sort.Search(len(c.keys), func(i int) bool {
+		return bytes.Compare([]byte(c.keys[i]), seek) >= 0
+	})
+
+	return c.move(pos, 1)
+}
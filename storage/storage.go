@@ -0,0 +1,85 @@
+// Package storage 定义了区块链使用的键值存储接口 并提供BoltDB与内存两种实现
+//
+// 接口的形式与BoltDB保持一致: 数据按bucket划分 所有读写都在事务中进行
+// Update中的所有写操作要么全部生效 要么在返回错误时全部回滚
+package storage
+
+import "errors"
+
+// 区块链使用的bucket名称
+const (
+	// BlocksBucket 存储所有区块 以及指向最新区块的"l"
+	BlocksBucket = "blocks"
+	// ChainstateBucket 存储UTXO集
+	ChainstateBucket = "chainstate"
+	// BlockIndexBucket 存储每个区块的高度与累计工作量 包括侧链上的区块
+	BlockIndexBucket = "blockindex"
+	// UndoBucket 存储每个主链区块花费的输出 用于断开区块时恢复UTXO集
+	UndoBucket = "undo"
+	// HeightIndexBucket 存储主链上每个高度对应的区块hash
+	HeightIndexBucket = "heightindex"
+	// TxIndexBucket 可选的交易索引 存储主链上每笔交易所在的区块与位置
+	TxIndexBucket = "txindex"
+	// AddrUTXOBucket 地址索引 按地址(公钥hash)存储其未花费的输出
+	AddrUTXOBucket = "addrutxo"
+	// AddrHistoryBucket 地址索引 按地址存储与其相关的所有主链交易
+	AddrHistoryBucket = "addrhistory"
+	// MempoolBucket 存储交易池中尚未被打包的交易
+	MempoolBucket = "mempool"
+)
+
+var (
+	// ErrBucketNotFound 删除一个不存在的bucket
+	ErrBucketNotFound = errors.New("storage: bucket not found")
+	// ErrBucketExists 创建一个已经存在的bucket
+	ErrBucketExists = errors.New("storage: bucket already exists")
+	// ErrTxNotWritable 在只读事务中进行写操作
+	ErrTxNotWritable = errors.New("storage: tx not writable")
+	// ErrStoreClosed 存储已经被关闭
+	ErrStoreClosed = errors.New("storage: store is closed")
+)
+
+// Store 一个支持事务的键值存储
+type Store interface {
+	// View 在只读事务中执行fn
+	View(fn func(Tx) error) error
+	// Update 在读写事务中执行fn fn返回错误时事务回滚
+	Update(fn func(Tx) error) error
+	// Batch 与Update语义相同 实现可以将多个并发的调用合并为一次提交
+	Batch(fn func(Tx) error) error
+	// Close 关闭存储
+	Close() error
+}
+
+// Tx 一个存储事务 仅在View/Update的回调中有效
+type Tx interface {
+	// Bucket 返回对应名称的bucket 不存在时返回nil
+	Bucket(name string) Bucket
+	// CreateBucket 创建一个bucket 已存在时返回ErrBucketExists
+	CreateBucket(name string) (Bucket, error)
+	// CreateBucketIfNotExists 创建一个bucket 已存在时直接返回
+	CreateBucketIfNotExists(name string) (Bucket, error)
+	// DeleteBucket 删除一个bucket 不存在时返回ErrBucketNotFound
+	DeleteBucket(name string) error
+}
+
+// Bucket 事务中的一个键值集合 Get返回的数据仅在事务内有效
+type Bucket interface {
+	Get(key []byte) []byte
+	Put(key, value []byte) error
+	Delete(key []byte) error
+	// ForEach 按key的字节序遍历所有键值对 fn返回错误时终止遍历
+	ForEach(fn func(k, v []byte) error) error
+	// Cursor 返回一个按key的字节序遍历的游标
+	Cursor() Cursor
+}
+
+// Cursor 按key的字节序遍历bucket 到达边界时返回的key为nil
+type Cursor interface {
+	First() (key, value []byte)
+	Last() (key, value []byte)
+	Next() (key, value []byte)
+	Prev() (key, value []byte)
+	// Seek 移动到第一个大于等于seek的key
+	Seek(seek []byte) (key, value []byte)
+}
@@ -0,0 +1,336 @@
+package storage
+
+import (
+	"bytes"
+	"errors"
+	"path/filepath"
+	"testing"
+)
+
+// 对每一种存储实现运行同一个测试
+func testStores(t *testing.T, test func(t *testing.T, s Store)) {
+	stores := []struct {
+		name string
+		open func(t *testing.T) Store
+	}{
+		{"memory", func(t *testing.T) Store {
+			return NewMemory()
+		}},
+		{"bolt", func(t *testing.T) Store {
+			s, err := OpenBolt(filepath.Join(t.TempDir(), "test.db"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			return s
+		}},
+	}
+
+	for _, store := range stores {
+		t.Run(store.name, func(t *testing.T) {
+			s := store.open(t)
+			defer s.Close()
+
+			test(t, s)
+		})
+	}
+}
+
+// 在一个写事务中向bucket写入键值对 bucket不存在时创建
+func put(t *testing.T, s Store, bucket string, pairs ...string) {
+	t.Helper()
+
+	err := s.Update(func(tx Tx) error {
+		b, err := tx.CreateBucketIfNotExists(bucket)
+		if err != nil {
+			return err
+		}
+		for i := 0; i < len(pairs); i += 2 {
+			if err := b.Put([]byte(pairs[i]), []byte(pairs[i+1])); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// 读取bucket中的所有键值对 bucket不存在时返回nil
+func contents(t *testing.T, s Store, bucket string) map[string]string {
+	t.Helper()
+
+	var result map[string]string
+	err := s.View(func(tx Tx) error {
+		b := tx.Bucket(bucket)
+		if b == nil {
+			return nil
+		}
+		result = make(map[string]string)
+		return b.ForEach(func(k, v []byte) error {
+			result[string(k)] = string(v)
+			return nil
+		})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return result
+}
+
+func assertContents(t *testing.T, s Store, bucket string, want map[string]string) {
+	t.Helper()
+
+	got := contents(t, s, bucket)
+	if (got == nil) != (want == nil) || len(got) != len(want) {
+		t.Fatalf("bucket %q = %v, want %v", bucket, got, want)
+	}
+	for k, v := range want {
+		if got[k] != v {
+			t.Fatalf("bucket %q = %v, want %v", bucket, got, want)
+		}
+	}
+}
+
+func TestUpdateRollback(t *testing.T) {
+	errAbort := errors.New("abort")
+
+	tests := []struct {
+		name string
+		fn   func(tx Tx) error
+	}{
+		{"put and delete", func(tx Tx) error {
+			b := tx.Bucket("a")
+			if err := b.Put([]byte("k1"), []byte("changed")); err != nil {
+				return err
+			}
+			if err := b.Put([]byte("k3"), []byte("new")); err != nil {
+				return err
+			}
+			return b.Delete([]byte("k2"))
+		}},
+		{"create bucket", func(tx Tx) error {
+			b, err := tx.CreateBucket("c")
+			if err != nil {
+				return err
+			}
+			return b.Put([]byte("k"), []byte("v"))
+		}},
+		{"delete bucket", func(tx Tx) error {
+			return tx.DeleteBucket("b")
+		}},
+		{"delete and recreate bucket", func(tx Tx) error {
+			if err := tx.DeleteBucket("b"); err != nil {
+				return err
+			}
+			b, err := tx.CreateBucket("b")
+			if err != nil {
+				return err
+			}
+			return b.Put([]byte("k"), []byte("v"))
+		}},
+		{"write after delete", func(tx Tx) error {
+			if err := tx.Bucket("a").Put([]byte("k1"), []byte("changed")); err != nil {
+				return err
+			}
+			return tx.DeleteBucket("a")
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			testStores(t, func(t *testing.T, s Store) {
+				put(t, s, "a", "k1", "v1", "k2", "v2")
+				put(t, s, "b", "k", "v")
+
+				err := s.Update(func(tx Tx) error {
+					if err := tt.fn(tx); err != nil {
+						t.Fatal(err)
+					}
+					return errAbort
+				})
+				if err != errAbort {
+					t.Fatalf("Update() error = %v, want %v", err, errAbort)
+				}
+
+				assertContents(t, s, "a", map[string]string{"k1": "v1", "k2": "v2"})
+				assertContents(t, s, "b", map[string]string{"k": "v"})
+				assertContents(t, s, "c", nil)
+			})
+		})
+	}
+}
+
+func TestBuckets(t *testing.T) {
+	testStores(t, func(t *testing.T, s Store) {
+		put(t, s, "a", "k", "a")
+		put(t, s, "b", "k", "b")
+
+		// 不同bucket中相同的key互不影响
+		assertContents(t, s, "a", map[string]string{"k": "a"})
+		assertContents(t, s, "b", map[string]string{"k": "b"})
+
+		err := s.Update(func(tx Tx) error {
+			if _, err := tx.CreateBucket("a"); err != ErrBucketExists {
+				t.Errorf("CreateBucket(existing) error = %v, want %v", err, ErrBucketExists)
+			}
+			if err := tx.DeleteBucket("missing"); err != ErrBucketNotFound {
+				t.Errorf("DeleteBucket(missing) error = %v, want %v", err, ErrBucketNotFound)
+			}
+			if b := tx.Bucket("missing"); b != nil {
+				t.Errorf("Bucket(missing) = %v, want nil", b)
+			}
+			if _, err := tx.CreateBucketIfNotExists("a"); err != nil {
+				t.Errorf("CreateBucketIfNotExists(existing) error = %v", err)
+			}
+			return tx.DeleteBucket("b")
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		assertContents(t, s, "a", map[string]string{"k": "a"})
+		assertContents(t, s, "b", nil)
+
+		err = s.View(func(tx Tx) error {
+			if _, err := tx.CreateBucket("c"); err != ErrTxNotWritable {
+				t.Errorf("CreateBucket() in View error = %v, want %v", err, ErrTxNotWritable)
+			}
+			if err := tx.Bucket("a").Put([]byte("k"), []byte("v")); err != ErrTxNotWritable {
+				t.Errorf("Put() in View error = %v, want %v", err, ErrTxNotWritable)
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	})
+}
+
+func TestCursor(t *testing.T) {
+	// 按字节序排列 与写入顺序无关
+	keys := []string{"\x00", "\x01\xff", "a", "ab", "b", "\xff"}
+
+	tests := []struct {
+		name string
+		seek string
+		want string
+		end  bool
+	}{
+		{name: "existing key", seek: "ab", want: "ab"},
+		{name: "between keys", seek: "aa", want: "ab"},
+		{name: "before first", seek: "", want: "\x00"},
+		{name: "prefix", seek: "\x01", want: "\x01\xff"},
+		{name: "past last", seek: "\xff\x00", end: true},
+	}
+
+	testStores(t, func(t *testing.T, s Store) {
+		for i := len(keys) - 1; i >= 0; i-- {
+			put(t, s, "a", keys[i], "v"+keys[i])
+		}
+
+		err := s.View(func(tx Tx) error {
+			c := tx.Bucket("a").Cursor()
+
+			var forward []string
+			for k, v := c.First(); k != nil; k, v = c.Next() {
+				if string(v) != "v"+string(k) {
+					t.Errorf("value of %q = %q", k, v)
+				}
+				forward = append(forward, string(k))
+			}
+			assertKeys(t, "forward", forward, keys)
+
+			var backward []string
+			for k, _ := c.Last(); k != nil; k, _ = c.Prev() {
+				backward = append([]string{string(k)}, backward...)
+			}
+			assertKeys(t, "backward", backward, keys)
+
+			for _, tt := range tests {
+				k, _ := c.Seek([]byte(tt.seek))
+				if tt.end {
+					if k != nil {
+						t.Errorf("%s: Seek(%q) = %q, want nil", tt.name, tt.seek, k)
+					}
+					continue
+				}
+				if !bytes.Equal(k, []byte(tt.want)) {
+					t.Errorf("%s: Seek(%q) = %q, want %q", tt.name, tt.seek, k, tt.want)
+				}
+			}
+
+			// Seek之后从该位置继续遍历
+			c.Seek([]byte("a"))
+			if k, _ := c.Next(); string(k) != "ab" {
+				t.Errorf("Next() after Seek(%q) = %q, want %q", "a", k, "ab")
+			}
+
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	})
+}
+
+func assertKeys(t *testing.T, name string, got, want []string) {
+	t.Helper()
+
+	if len(got) != len(want) {
+		t.Fatalf("%s keys = %q, want %q", name, got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("%s keys = %q, want %q", name, got, want)
+		}
+	}
+}
+
+func TestDelete(t *testing.T) {
+	testStores(t, func(t *testing.T, s Store) {
+		put(t, s, "a", "k1", "v1", "k2", "v2", "k3", "v3")
+
+		err := s.Update(func(tx Tx) error {
+			b := tx.Bucket("a")
+			if err := b.Delete([]byte("k2")); err != nil {
+				return err
+			}
+			if v := b.Get([]byte("k2")); v != nil {
+				t.Errorf("Get() after Delete() = %q, want nil", v)
+			}
+			// 删除不存在的key不是错误
+			return b.Delete([]byte("missing"))
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		assertContents(t, s, "a", map[string]string{"k1": "v1", "k3": "v3"})
+
+		err = s.View(func(tx Tx) error {
+			c := tx.Bucket("a").Cursor()
+			if k, _ := c.Seek([]byte("k2")); string(k) != "k3" {
+				t.Errorf("Seek(deleted key) = %q, want %q", k, "k3")
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	})
+}
+
+func TestClosedStore(t *testing.T) {
+	testStores(t, func(t *testing.T, s Store) {
+		if err := s.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := s.View(func(Tx) error { return nil }); err != ErrStoreClosed {
+			t.Errorf("View() after Close() error = %v, want %v", err, ErrStoreClosed)
+		}
+		if err := s.Update(func(Tx) error { return nil }); err != ErrStoreClosed {
+			t.Errorf("Update() after Close() error = %v, want %v", err, ErrStoreClosed)
+		}
+	})
+}
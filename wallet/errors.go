@@ -0,0 +1,10 @@
+package wallet
+
+import "errors"
+
+var (
+	// ErrWalletNotFound 钱包文件中不存在对应地址
+	ErrWalletNotFound = errors.New("wallet is not found")
+	// ErrInvalidAddress 地址格式不合法
+	ErrInvalidAddress = errors.New("address is not valid")
+)
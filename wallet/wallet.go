@@ -0,0 +1,162 @@
+// Package wallet 管理用户的密钥对 并负责地址的生成与校验
+package wallet
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/gob"
+	"fmt"
+	"math/big"
+
+	"blockchain/encoding/base58"
+
+	"golang.org/x/crypto/ripemd160"
+)
+
+const addressChecksumLen = 4
+
+// Wallet 一个钱包存储一对公私钥
+type Wallet struct {
+	PrivateKey ecdsa.PrivateKey
+	PublicKey  []byte
+}
+
+// NewWallet 生成一对新的公私钥
+func NewWallet() (*Wallet, error) {
+	private, public, err := newKeyPair()
+	if err != nil {
+		return nil, err
+	}
+	wallet := Wallet{private, public}
+	return &wallet, nil
+}
+
+func newKeyPair() (ecdsa.PrivateKey, []byte, error) {
+	curve := elliptic.P256()
+	private, err := ecdsa.GenerateKey(curve, rand.Reader)
+	if err != nil {
+		return ecdsa.PrivateKey{}, nil, err
+	}
+	// 坐标各自补齐为32字节 验证签名时才能从中间准确地将其分开
+	pubKey := make([]byte, 64)
+	private.PublicKey.X.FillBytes(pubKey[:32])
+	private.PublicKey.Y.FillBytes(pubKey[32:])
+
+	return *private, pubKey, nil
+}
+
+// 钱包持久化时的格式 椭圆曲线本身无法被gob序列化 故只保存私钥的D与公钥
+type walletData struct {
+	D         []byte
+	PublicKey []byte
+}
+
+// GobEncode 将钱包编码为可持久化的格式
+func (w Wallet) GobEncode() ([]byte, error) {
+	var buff bytes.Buffer
+
+	err := gob.NewEncoder(&buff).Encode(walletData{w.PrivateKey.D.Bytes(), w.PublicKey})
+	if err != nil {
+		return nil, err
+	}
+
+	return buff.Bytes(), nil
+}
+
+// GobDecode 从持久化的格式中恢复钱包 曲线固定为P256
+func (w *Wallet) GobDecode(data []byte) error {
+	var wd walletData
+
+	err := gob.NewDecoder(bytes.NewReader(data)).Decode(&wd)
+	if err != nil {
+		return err
+	}
+
+	keyLen := len(wd.PublicKey)
+	if keyLen == 0 || keyLen%2 != 0 {
+		return fmt.Errorf("wallet: malformed public key of %d bytes", keyLen)
+	}
+
+	w.PrivateKey = ecdsa.PrivateKey{
+		PublicKey: ecdsa.PublicKey{
+			Curve: elliptic.P256(),
+			X:     new(big.Int).SetBytes(wd.PublicKey[:keyLen/2]),
+			Y:     new(big.Int).SetBytes(wd.PublicKey[keyLen/2:]),
+		},
+		D: new(big.Int).SetBytes(wd.D),
+	}
+	w.PublicKey = wd.PublicKey
+
+	return nil
+}
+
+// GetAddress 由公钥与所在网络的版本字节生成base58编码的地址 不同网络的地址互不通用
+func (w Wallet) GetAddress(version byte) []byte {
+	pubKeyHash := HashPubKey(w.PublicKey)
+
+	versionedPayload := append([]byte{version}, pubKeyHash...)
+	checksum := checkSum(versionedPayload)
+
+	fullPayload := append(versionedPayload, checksum...)
+	address := base58.Encode(fullPayload)
+
+	return address
+}
+
+// HashPubKey 计算公钥的hash 即RIPEMD160(SHA256(pubKey))
+func HashPubKey(pubKey []byte) []byte {
+	publicSHA256 := sha256.Sum256(pubKey)
+
+	RIPEMD160Hasher := ripemd160.New()
+	// hash.Hash的Write不会返回错误
+	_, _ = RIPEMD160Hasher.Write(publicSHA256[:])
+	publicRIPEMD160 := RIPEMD160Hasher.Sum(nil)
+
+	return publicRIPEMD160
+
+}
+
+// 获取指定内容的校验码
+func checkSum(payload []byte) []byte {
+	firstSHA := sha256.Sum256(payload)
+	secondSHA := sha256.Sum256(firstSHA[:])
+
+	return secondSHA[:addressChecksumLen]
+}
+
+// ValidateAddress 校验地址的格式与校验码是否合法 且版本号是否为所在网络的version
+func ValidateAddress(address string, version byte) bool {
+	// 分离出来原始的校验码 之后通过已有信息重新计算校验码
+	pubKeyHash := base58.Decode([]byte(address))
+	// 长度不足以包含版本号与校验码的地址直接判定为不合法
+	if len(pubKeyHash) <= addressChecksumLen+1 {
+		return false
+	}
+	// 分离出校验码
+	actualChecksum := pubKeyHash[len(pubKeyHash)-addressChecksumLen:]
+	// 分离出版本号 其他网络的地址不合法
+	if pubKeyHash[0] != version {
+		return false
+	}
+	// 分离原始公钥hash
+	pubKeyHash = pubKeyHash[1 : len(pubKeyHash)-addressChecksumLen]
+	// 重新计算校验码
+	targetChecksum := checkSum(append([]byte{version}, pubKeyHash...))
+	// 验证校验码是否合法
+	return bytes.Compare(actualChecksum, targetChecksum) == 0
+}
+
+// PubKeyHashFromAddress 从版本号为version的地址中解析出公钥hash 地址不合法时返回ErrInvalidAddress
+func PubKeyHashFromAddress(address string, version byte) ([]byte, error) {
+	if !ValidateAddress(address, version) {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidAddress, address)
+	}
+
+	// 地址解码后中间部分即为公钥hash
+	pubKeyHash := base58.Decode([]byte(address))
+
+	return pubKeyHash[1 : len(pubKeyHash)-addressChecksumLen], nil
+}
@@ -0,0 +1,81 @@
+package wallet
+
+import (
+	"bytes"
+	"errors"
+	"path/filepath"
+	"testing"
+)
+
+const (
+	mainVersion = 0x00
+	testVersion = 0x6f
+)
+
+func TestAddressVersion(t *testing.T) {
+	w, err := NewWallet()
+	if err != nil {
+		t.Fatal(err)
+	}
+	mainAddress := string(w.GetAddress(mainVersion))
+	testAddress := string(w.GetAddress(testVersion))
+
+	tests := []struct {
+		address string
+		version byte
+		valid   bool
+	}{
+		{mainAddress, mainVersion, true},
+		{testAddress, testVersion, true},
+		{mainAddress, testVersion, false},
+		{testAddress, mainVersion, false},
+	}
+
+	for _, tt := range tests {
+		if got := ValidateAddress(tt.address, tt.version); got != tt.valid {
+			t.Errorf("ValidateAddress(%s, %#x) = %v, want %v", tt.address, tt.version, got, tt.valid)
+		}
+
+		pubKeyHash, err := PubKeyHashFromAddress(tt.address, tt.version)
+		if !tt.valid {
+			if !errors.Is(err, ErrInvalidAddress) {
+				t.Errorf("PubKeyHashFromAddress(%s, %#x) error = %v, want %v", tt.address, tt.version, err, ErrInvalidAddress)
+			}
+			continue
+		}
+		if err != nil || !bytes.Equal(pubKeyHash, HashPubKey(w.PublicKey)) {
+			t.Errorf("PubKeyHashFromAddress(%s, %#x) = %x, %v", tt.address, tt.version, pubKeyHash, err)
+		}
+	}
+}
+
+func TestWalletsAddressVersion(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "wallet.dat")
+	ws, err := NewWallets(path, mainVersion)
+	if err != nil {
+		t.Fatal(err)
+	}
+	address, err := ws.CreateWallet()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ValidateAddress(address, mainVersion) {
+		t.Fatalf("CreateWallet() = %s, not an address of version %#x", address, mainVersion)
+	}
+	if err := ws.SaveToFile(); err != nil {
+		t.Fatal(err)
+	}
+
+	// 同一个钱包文件在其他网络中以该网络的版本字节生成地址
+	ws, err = NewWallets(path, testVersion)
+	if err != nil {
+		t.Fatal(err)
+	}
+	addresses := ws.GetAddresses()
+	if len(addresses) != 1 || !ValidateAddress(addresses[0], testVersion) {
+		t.Fatalf("GetAddresses() = %v, want one address of version %#x", addresses, testVersion)
+	}
+	if _, err := ws.GetWallet(address); !errors.Is(err, ErrWalletNotFound) {
+		t.Fatalf("GetWallet(%s) error = %v, want %v", address, err, ErrWalletNotFound)
+	}
+}
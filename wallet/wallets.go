@@ -0,0 +1,107 @@
+package wallet
+
+import (
+	"bytes"
+	"encoding/gob"
+	"fmt"
+	"io/ioutil"
+	"os"
+)
+
+// Wallets 保存钱包文件中的所有钱包 以地址为索引
+type Wallets struct {
+	Wallets map[string]*Wallet
+
+	// 钱包文件的路径与生成地址使用的版本字节 不参与序列化
+	walletFile string
+	version    byte
+}
+
+// NewWallets 从walletFile中加载所有钱包 以version生成各钱包的地址 文件不存在时返回一个空的钱包集合
+func NewWallets(walletFile string, version byte) (*Wallets, error) {
+	wallets := Wallets{walletFile: walletFile, version: version}
+	wallets.Wallets = make(map[string]*Wallet)
+
+	err := wallets.LoadFromFile()
+	if err != nil {
+		return nil, err
+	}
+
+	return &wallets, nil
+}
+
+// GetWallet 取出地址对应的钱包 不存在时返回ErrWalletNotFound
+func (ws Wallets) GetWallet(address string) (Wallet, error) {
+	wallet, ok := ws.Wallets[address]
+	if !ok {
+		return Wallet{}, fmt.Errorf("%w: %s", ErrWalletNotFound, address)
+	}
+
+	return *wallet, nil
+}
+
+// CreateWallet 生成一个新钱包并返回其地址 需要调用SaveToFile才会持久化
+func (ws *Wallets) CreateWallet() (string, error) {
+	wallet, err := NewWallet()
+	if err != nil {
+		return "", err
+	}
+	address := fmt.Sprintf("%s", wallet.GetAddress(ws.version))
+
+	ws.Wallets[address] = wallet
+
+	return address, nil
+}
+
+// GetAddresses 返回所有钱包的地址
+func (ws *Wallets) GetAddresses() []string {
+	var addresses []string
+
+	for address := range ws.Wallets {
+		addresses = append(addresses, address)
+	}
+
+	return addresses
+}
+
+// LoadFromFile 从钱包文件中读取钱包 文件不存在时不做任何处理
+func (ws *Wallets) LoadFromFile() error {
+	if _, err := os.Stat(ws.walletFile); os.IsNotExist(err) {
+		return nil
+	}
+
+	fileContent, err := ioutil.ReadFile(ws.walletFile)
+	if err != nil {
+		return err
+	}
+
+	var wallets Wallets
+	// 按照这种数据结构对其进行反序列化
+	decoder := gob.NewDecoder(bytes.NewReader(fileContent))
+	err = decoder.Decode(&wallets)
+	if err != nil {
+		return err
+	}
+
+	// 地址由公钥与当前网络的版本字节重新生成 文件中保存的索引可能来自其他网络
+	ws.Wallets = make(map[string]*Wallet, len(wallets.Wallets))
+	for _, wallet := range wallets.Wallets {
+		ws.Wallets[string(wallet.GetAddress(ws.version))] = wallet
+	}
+
+	return nil
+}
+
+// SaveToFile 将所有钱包写入钱包文件
+func (ws Wallets) SaveToFile() error {
+	var content bytes.Buffer
+
+	// 按照这种数据结构对其进行序列化
+	encoder := gob.NewEncoder(&content)
+	err := encoder.Encode(ws)
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(ws.walletFile, content.Bytes(), 0600)
+}
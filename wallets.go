@@ -1,90 +0,0 @@
-package main
-
-import (
-	"bytes"
-	"crypto/elliptic"
-	"encoding/gob"
-	"fmt"
-	"io/ioutil"
-	"log"
-	"os"
-)
-
-type Wallets struct {
-	Wallets map[string]*Wallet
-}
-
-func NewWallets() (*Wallets, error) {
-	wallets := Wallets{}
-	wallets.Wallets = make(map[string]*Wallet)
-
-	err := wallets.LoadFromFile()
-
-	return &wallets, err
-}
-
-func (ws Wallets) GetWallet(address string) Wallet {
-	return *ws.Wallets[address]
-}
-
-func (ws *Wallets) CreateWallet() string {
-	wallet := NewWallet()
-	address := fmt.Sprintf("%s", wallet.GetAddress())
-
-	ws.Wallets[address] = wallet
-
-	return address
-}
-
-func (ws *Wallets) GetAddresses() []string {
-	var addresses []string
-
-	for address := range ws.Wallets {
-		addresses = append(addresses, address)
-	}
-
-	return addresses
-}
-
-func (ws *Wallets) LoadFromFile() error {
-	if _, err := os.Stat(walletFile); os.IsNotExist(err) {
-		return err
-	}
-
-	fileContent, err := ioutil.ReadFile(walletFile)
-	if err != nil {
-		log.Panic(err)
-	}
-
-	var wallets Wallets
-	// 按照这种数据结构对其进行反序列化
-	gob.Register(elliptic.P256())
-
-	decoder := gob.NewDecoder(bytes.NewReader(fileContent))
-	err = decoder.Decode(&wallets)
-	if err != nil {
-		log.Panic(err)
-	}
-
-	ws.Wallets = wallets.Wallets
-
-	return nil
-}
-
-func (ws Wallets) SaveToFile() {
-	var content bytes.Buffer
-
-	// 按照这种数据结构对其进行序列化
-	gob.Register(elliptic.P256())
-
-	encoder := gob.NewEncoder(&content)
-	err := encoder.Encode(ws)
-	if err != nil {
-		log.Panic(err)
-	}
-
-	err = ioutil.WriteFile(walletFile, content.Bytes(), 0644)
-	if err != nil {
-		log.Panic(err)
-	}
-}